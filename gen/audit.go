@@ -0,0 +1,263 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// auditSeverities are the advisory severities, from least to most severe.
+var auditSeverities = []string{"info", "low", "moderate", "high", "critical"}
+
+// advisory is a vulnerability advisory for a node package.
+type advisory struct {
+	pkg      string
+	severity string
+	title    string
+	url      string
+	versions string
+	paths    []string
+}
+
+// Audit resolves the node packages used by the build, and audits them with
+// the package manager, printing a report of the found advisories.
+//
+// An error is returned when any advisory is at or above flags.AuditLevel.
+func Audit(flags *Flags) error {
+	if flags.DryRun {
+		return errors.New("cannot audit a dry run")
+	}
+	level := auditSeverity(flags.AuditLevel)
+	if level == -1 {
+		return fmt.Errorf("invalid audit level %q", flags.AuditLevel)
+	}
+	flags.auditing = true
+	if err := Assetgen(flags); err != nil {
+		return err
+	}
+	// run audit
+	params := []string{"audit", "--json"}
+	switch {
+	case flags.bun:
+	case flags.yarnBerry:
+		params = []string{"npm", "audit", "--json", "--recursive"}
+	default:
+		params = append(params, "--groups", "dependencies")
+	}
+	commandf(flags, flags.YarnBin, params...)
+	var stdout, stderr bytes.Buffer
+	cmd := newCmd(flags, flags.YarnBin, params...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// the package managers exit non-zero when advisories are found, so the
+	// output is parsed before checking the error
+	runErr := cmd.Run()
+	advisories, err := parseAudit(stdout.Bytes())
+	switch {
+	case err != nil && runErr != nil:
+		return newStepError(flags.YarnBin, params, stderr.Bytes(), runErr)
+	case err != nil:
+		return fmt.Errorf("could not parse audit output: %w", err)
+	case runErr != nil && len(advisories) == 0:
+		return newStepError(flags.YarnBin, params, stderr.Bytes(), runErr)
+	}
+	// report
+	printAudit(os.Stdout, advisories)
+	var count int
+	for _, a := range advisories {
+		if auditSeverity(a.severity) >= level {
+			count++
+		}
+	}
+	if count != 0 {
+		return fmt.Errorf("found %d advisories at or above %s severity", count, flags.AuditLevel)
+	}
+	return nil
+}
+
+// auditSeverity returns the index of the severity in auditSeverities, or -1
+// when not a valid severity.
+func auditSeverity(severity string) int {
+	for i, s := range auditSeverities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseAudit parses the json output of yarn audit (newline delimited
+// auditAdvisory records), yarn npm audit (a npm audit report for yarn 2/3, or
+// newline delimited records for yarn 4), and bun audit (advisories keyed by
+// package), returning the advisories sorted by severity and package.
+func parseAudit(buf []byte) ([]advisory, error) {
+	seen := make(map[string]*advisory)
+	var advisories []*advisory
+	add := func(a advisory, paths ...string) {
+		key := a.pkg + "\n" + a.title + "\n" + a.url
+		if p, ok := seen[key]; ok {
+			p.paths = append(p.paths, paths...)
+			return
+		}
+		a.paths = paths
+		seen[key] = &a
+		advisories = append(advisories, &a)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	for {
+		var v map[string]json.RawMessage
+		switch err := dec.Decode(&v); {
+		case err == io.EOF:
+			return sortAdvisories(advisories), nil
+		case err != nil:
+			return nil, err
+		}
+		switch {
+		case v["type"] != nil:
+			// yarn classic
+			var typ string
+			if err := json.Unmarshal(v["type"], &typ); err != nil {
+				return nil, err
+			}
+			if typ != "auditAdvisory" {
+				continue
+			}
+			var r struct {
+				Resolution struct {
+					Path string `json:"path"`
+				} `json:"resolution"`
+				Advisory npmAdvisory `json:"advisory"`
+			}
+			if err := json.Unmarshal(v["data"], &r); err != nil {
+				return nil, err
+			}
+			add(r.Advisory.advisory(""), strings.ReplaceAll(r.Resolution.Path, ">", " > "))
+		case v["advisories"] != nil:
+			// yarn 2/3
+			var r map[string]npmAdvisory
+			if err := json.Unmarshal(v["advisories"], &r); err != nil {
+				return nil, err
+			}
+			for _, a := range r {
+				var paths []string
+				for _, f := range a.Findings {
+					for _, p := range f.Paths {
+						paths = append(paths, strings.ReplaceAll(p, ">", " > "))
+					}
+				}
+				add(a.advisory(""), paths...)
+			}
+		case v["value"] != nil && v["children"] != nil:
+			// yarn 4
+			var pkg string
+			if err := json.Unmarshal(v["value"], &pkg); err != nil {
+				return nil, err
+			}
+			var r struct {
+				Issue      string   `json:"Issue"`
+				URL        string   `json:"URL"`
+				Severity   string   `json:"Severity"`
+				Versions   string   `json:"Vulnerable Versions"`
+				Dependents []string `json:"Dependents"`
+			}
+			if err := json.Unmarshal(v["children"], &r); err != nil {
+				return nil, err
+			}
+			add(advisory{
+				pkg:      pkg,
+				severity: strings.ToLower(r.Severity),
+				title:    r.Issue,
+				url:      r.URL,
+				versions: r.Versions,
+			}, r.Dependents...)
+		default:
+			// bun
+			for pkg, raw := range v {
+				var r []npmAdvisory
+				if err := json.Unmarshal(raw, &r); err != nil {
+					return nil, fmt.Errorf("unknown audit record for %s: %w", pkg, err)
+				}
+				for _, a := range r {
+					add(a.advisory(pkg))
+				}
+			}
+		}
+	}
+}
+
+// npmAdvisory is a npm registry advisory.
+type npmAdvisory struct {
+	ModuleName         string `json:"module_name"`
+	Severity           string `json:"severity"`
+	Title              string `json:"title"`
+	URL                string `json:"url"`
+	VulnerableVersions string `json:"vulnerable_versions"`
+	Findings           []struct {
+		Paths []string `json:"paths"`
+	} `json:"findings"`
+}
+
+// advisory converts the npm advisory, using pkg when the advisory does not
+// have a module name.
+func (a npmAdvisory) advisory(pkg string) advisory {
+	if a.ModuleName != "" {
+		pkg = a.ModuleName
+	}
+	return advisory{
+		pkg:      pkg,
+		severity: strings.ToLower(a.Severity),
+		title:    a.Title,
+		url:      a.URL,
+		versions: a.VulnerableVersions,
+	}
+}
+
+// sortAdvisories sorts advisories by descending severity, and package.
+func sortAdvisories(v []*advisory) []advisory {
+	sort.SliceStable(v, func(i, j int) bool {
+		a, b := auditSeverity(v[i].severity), auditSeverity(v[j].severity)
+		if a != b {
+			return a > b
+		}
+		return v[i].pkg < v[j].pkg
+	})
+	advisories := make([]advisory, len(v))
+	for i, a := range v {
+		sort.Strings(a.paths)
+		advisories[i] = *a
+	}
+	return advisories
+}
+
+// printAudit prints a report of the advisories.
+func printAudit(w io.Writer, advisories []advisory) {
+	counts := make(map[string]int)
+	for _, a := range advisories {
+		counts[a.severity]++
+	}
+	var summary []string
+	for i := len(auditSeverities) - 1; i >= 0; i-- {
+		if n := counts[auditSeverities[i]]; n != 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", n, auditSeverities[i]))
+		}
+	}
+	if len(advisories) == 0 {
+		fmt.Fprintln(w, "AUDIT: no advisories found")
+		return
+	}
+	fmt.Fprintf(w, "AUDIT: %d advisories (%s)\n", len(advisories), strings.Join(summary, ", "))
+	for _, a := range advisories {
+		fmt.Fprintf(w, "  %-8s %s %s\n", strings.ToUpper(a.severity), a.pkg, a.versions)
+		fmt.Fprintf(w, "           %s\n", a.title)
+		if a.url != "" {
+			fmt.Fprintf(w, "           %s\n", a.url)
+		}
+		for _, p := range a.paths {
+			fmt.Fprintf(w, "           via %s\n", p)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package gen
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Option is a build option.
+type Option func(*Flags) error
+
+// Build generates assets using the provided options.
+//
+// Build does not modify the process' environment variables: child processes
+// are passed the build's environment explicitly, and are stopped when ctx is
+// done. The working directory defaults to the current working
+// directory when not specified with WithWd.
+func Build(ctx context.Context, opts ...Option) error {
+	flags := NewFlags("")
+	flags.ctx = ctx
+	// set defaults
+	flags.flagSet = flags.FlagSet("assetgen", flag.ContinueOnError)
+	flags.flagSet.SetOutput(ioutil.Discard)
+	if err := flags.flagSet.Parse(nil); err != nil {
+		return err
+	}
+	// apply opts
+	for _, o := range opts {
+		if err := o(flags); err != nil {
+			return err
+		}
+	}
+	if flags.Wd == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("could not determine working directory: %w", err)
+		}
+		flags.Wd = wd
+	}
+	return Assetgen(flags)
+}
+
+// WithWd is a build option to set the working directory.
+func WithWd(wd string) Option {
+	return func(flags *Flags) error {
+		flags.Wd = wd
+		return nil
+	}
+}
+
+// WithAssets is a build option to set the assets directory.
+func WithAssets(assets string) Option {
+	return func(flags *Flags) error {
+		flags.Assets = assets
+		return nil
+	}
+}
+
+// WithRoots is a build option to add additional assets directories, as
+// dir[=prefix] (see the -roots flag).
+func WithRoots(roots ...string) Option {
+	return func(flags *Flags) error {
+		flags.Roots = strings.Join(append(strings.Split(flags.Roots, ","), roots...), ",")
+		return nil
+	}
+}
+
+// WithDist is a build option to set the dist directory.
+func WithDist(dist string) Option {
+	return func(flags *Flags) error {
+		flags.Dist = dist
+		return nil
+	}
+}
+
+// WithAssetsGo is a build option to set the generated assets package file and
+// package name.
+func WithAssetsGo(file, pkg string) Option {
+	return func(flags *Flags) error {
+		flags.AssetsGo, flags.AssetsPkg = file, pkg
+		return nil
+	}
+}
+
+// WithCache is a build option to set the cache directory.
+func WithCache(cache string) Option {
+	return func(flags *Flags) error {
+		flags.Cache = cache
+		return nil
+	}
+}
+
+// WithScript is a build option to set the assets script.
+func WithScript(script string) Option {
+	return func(flags *Flags) error {
+		flags.Script = script
+		return nil
+	}
+}
+
+// WithLogger is a build option to set the logger.
+func WithLogger(logger Logger) Option {
+	return func(flags *Flags) error {
+		flags.Logger = logger
+		return nil
+	}
+}
+
+// WithEnv is a build option to set an environment variable for child
+// processes.
+func WithEnv(key, value string) Option {
+	return func(flags *Flags) error {
+		flags.env = append(flags.env, key+"="+value)
+		return nil
+	}
+}
+
+// WithArgs is a build option to set flags using command line arguments (ie,
+// "-node-version", "18").
+func WithArgs(args ...string) Option {
+	return func(flags *Flags) error {
+		if flags.flagSet == nil {
+			return errors.New("WithArgs can only be used with Build")
+		}
+		if err := flags.flagSet.Parse(args); err != nil {
+			return fmt.Errorf("could not parse args: %w", err)
+		}
+		if flags.flagSet.NArg() != 0 {
+			return fmt.Errorf("unexpected args: %v", flags.flagSet.Args())
+		}
+		return nil
+	}
+}
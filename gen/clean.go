@@ -0,0 +1,135 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yookoala/realpath"
+)
+
+// cleanTargets are the generated state removable by the clean command, in
+// removal order.
+var cleanTargets = []string{"build", "cache", "dist", "node-modules"}
+
+// Clean removes the generated state for the targets (build, cache, dist,
+// node-modules). When no targets are given, the build and dist directories
+// are removed.
+//
+// All targets are checked before anything is removed, and a directory
+// containing the working directory or the assets directory is never removed.
+// With flags.DryRun, the directories are printed but not removed.
+func Clean(flags *Flags, targets []string) error {
+	if len(targets) == 0 {
+		targets = []string{"build", "dist"}
+	}
+	for _, t := range targets {
+		if !contains(cleanTargets, t) {
+			return fmt.Errorf("unknown clean target %q (available: %s)", t, strings.Join(cleanTargets, ", "))
+		}
+	}
+	if err := initFlags(flags); err != nil {
+		return err
+	}
+	if err := resolvePackageManager(flags); err != nil {
+		return err
+	}
+	var dirs []string
+	for _, t := range cleanTargets {
+		if !contains(targets, t) {
+			continue
+		}
+		dir, err := cleanDir(flags, t)
+		switch {
+		case err != nil:
+			return err
+		case dir != "":
+			dirs = append(dirs, dir)
+		}
+	}
+	var removed []string
+	for _, dir := range dirs {
+		if cleanContains(removed, dir) {
+			continue
+		}
+		removed = append(removed, dir)
+		if flags.DryRun {
+			fmt.Fprintf(os.Stdout, "WOULD REMOVE: %s\n", dir)
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("could not remove %s: %w", dir, err)
+		}
+		fmt.Fprintf(os.Stdout, "REMOVED: %s\n", dir)
+	}
+	return nil
+}
+
+// cleanDir returns the real path of the directory for the clean target,
+// checking it is safe to remove. An empty string is returned when the
+// directory does not exist.
+func cleanDir(flags *Flags, target string) (string, error) {
+	var dir string
+	var parents []string
+	switch target {
+	case "build":
+		dir, parents = flags.Build, []string{flags.Wd}
+	case "cache":
+		// the cache may be shared outside of the working directory
+		dir = flags.Cache
+	case "dist":
+		dir, parents = flags.Dist, []string{flags.Assets, filepath.Dir(flags.AssetsGo)}
+	case "node-modules":
+		switch {
+		case flags.NoInstall:
+			return "", errors.New("cannot clean node-modules: node packages are managed by the project when using -no-install")
+		case flags.workspace != "" && flags.NodeModules == filepath.Join(flags.workspace, nodeModulesDir):
+			return "", fmt.Errorf("cannot clean node-modules: %s is shared by the workspace at %s", flags.NodeModules, flags.workspace)
+		}
+		dir, parents = flags.NodeModules, []string{flags.Wd, flags.Cache}
+	}
+	if !fileExists(dir) {
+		return "", nil
+	}
+	v, err := realpath.Realpath(dir)
+	if err != nil {
+		return "", fmt.Errorf("could not determine realpath for %s: %w", dir, err)
+	}
+	fi, err := os.Stat(v)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("could not stat %s: %w", v, err)
+	case !fi.IsDir():
+		return "", fmt.Errorf("cannot clean %s: %s is not a directory", target, v)
+	case isParentDir(v, flags.Wd):
+		return "", fmt.Errorf("cannot clean %s: %s contains the working directory", target, v)
+	case fileExists(flags.Assets) && isParentDir(v, flags.Assets):
+		return "", fmt.Errorf("cannot clean %s: %s contains the assets directory", target, v)
+	}
+	if home, err := os.UserHomeDir(); err == nil && fileExists(home) {
+		if home, err = realpath.Realpath(home); err == nil && isParentDir(v, home) {
+			return "", fmt.Errorf("cannot clean %s: %s contains the home directory", target, v)
+		}
+	}
+	if parents == nil {
+		return v, nil
+	}
+	for _, p := range parents {
+		if fileExists(p) && isParentDir(p, v) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("cannot clean %s: %s is not a subdirectory of %s", target, v, strings.Join(parents, " or "))
+}
+
+// cleanContains determines if dir is in any of the removed directories.
+func cleanContains(removed []string, dir string) bool {
+	for _, r := range removed {
+		if dir == r || strings.HasPrefix(dir, r+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
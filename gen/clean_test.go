@@ -0,0 +1,72 @@
+package gen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/realpath"
+)
+
+func TestCleanDir(t *testing.T) {
+	root, err := realpath.Realpath(t.TempDir())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	wd := filepath.Join(root, "wd")
+	assets := filepath.Join(wd, "assets")
+	for _, d := range []string{
+		filepath.Join(wd, "build"),
+		filepath.Join(assets, "dist"),
+		filepath.Join(root, "other"),
+		filepath.Join(root, "cache"),
+	} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(wd, "file"), nil, 0o644); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		target string
+		f      func(*Flags)
+		exp    string
+		err    string
+	}{
+		{"build", nil, filepath.Join(wd, "build"), ""},
+		{"dist", nil, filepath.Join(assets, "dist"), ""},
+		{"cache", nil, filepath.Join(root, "cache"), ""},
+		{"build", func(f *Flags) { f.Build = filepath.Join(wd, "missing") }, "", ""},
+		{"build", func(f *Flags) { f.Build = wd }, "", "contains the working directory"},
+		{"build", func(f *Flags) { f.Build = filepath.Join(wd, "file") }, "", "is not a directory"},
+		{"build", func(f *Flags) { f.Build = filepath.Join(root, "other") }, "", "is not a subdirectory"},
+		{"dist", func(f *Flags) { f.Wd, f.Dist = filepath.Join(root, "other"), wd }, "", "contains the assets directory"},
+		{"dist", func(f *Flags) { f.Dist = filepath.Join(root, "other") }, "", "is not a subdirectory"},
+		{"node-modules", func(f *Flags) { f.NoInstall = true }, "", "-no-install"},
+	}
+	for i, test := range tests {
+		flags := &Flags{
+			Wd:       wd,
+			Assets:   assets,
+			AssetsGo: filepath.Join(wd, "assets.go"),
+			Build:    filepath.Join(wd, "build"),
+			Cache:    filepath.Join(root, "cache"),
+			Dist:     filepath.Join(assets, "dist"),
+		}
+		if test.f != nil {
+			test.f(flags)
+		}
+		dir, err := cleanDir(flags, test.target)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("test %d expected no error, got: %v", i, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("test %d expected error containing %q, got: %v", i, test.err, err)
+		case dir != test.exp:
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, dir)
+		}
+	}
+}
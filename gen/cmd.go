@@ -0,0 +1,57 @@
+package gen
+
+import (
+	"errors"
+)
+
+// command is a assetgen command.
+type command struct {
+	desc string
+	run  func(*Flags, []string) error
+}
+
+// commands are the available assetgen commands.
+var commands = map[string]command{
+	"build": {
+		"generate assets (default)",
+		func(flags *Flags, _ []string) error {
+			return Assetgen(flags)
+		},
+	},
+	"audit": {
+		"resolve node packages, and audit them for known vulnerabilities",
+		func(flags *Flags, _ []string) error {
+			return Audit(flags)
+		},
+	},
+	"clean": {
+		"remove generated state (build, cache, dist, node-modules; default: build dist)",
+		func(flags *Flags, args []string) error {
+			return Clean(flags, args)
+		},
+	},
+	"doctor": {
+		"check the environment for common problems, and print fixes",
+		func(flags *Flags, _ []string) error {
+			return Doctor(flags)
+		},
+	},
+	"init": {
+		"scaffold an assets tree from a starter (plain, tailwind, bootstrap)",
+		func(flags *Flags, args []string) error {
+			switch len(args) {
+			case 0:
+				return Init(flags, "")
+			case 1:
+				return Init(flags, args[0])
+			}
+			return errors.New("init takes at most one starter name")
+		},
+	},
+	"vendor": {
+		"generate assets, and vendor the retrieved tools and node packages",
+		func(flags *Flags, _ []string) error {
+			return Vendor(flags)
+		},
+	},
+}
@@ -0,0 +1,156 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// addCssURLs configures a script step for rewriting the url() references in
+// the packed css (such as vendor css packed with staticDir) to the packed
+// asset urls.
+//
+// References are resolved relative to the packed css file (or to the dist root
+// when absolute), and are only rewritten when they resolve to a packed asset.
+// Data uris, external urls, and already packed asset urls are left as is.
+//
+// As rewriting a css file changes its hash, css files are rewritten after the
+// css files they reference.
+//
+// A step is added for the default dist, and for each named dist.
+func (s *Script) addCssURLs() {
+	names := []string{""}
+	for _, d := range s.dists {
+		names = append(names, d.name)
+	}
+	for _, name := range names {
+		s.addCssURLsStep(name)
+	}
+}
+
+// addCssURLsStep adds the step rewriting the url() references in the css
+// packed to the named dist.
+func (s *Script) addCssURLsStep(name string) {
+	s.exec = append(s.exec, step{
+		name: "css urls",
+		dist: name,
+		run: func(dist *pack.Pack) error {
+			dir := s.distPath(name)
+			files := make(map[string][]byte)
+			for _, name := range dist.Files() {
+				if !strings.HasSuffix(name, ".css") {
+					continue
+				}
+				buf, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil {
+					return err
+				}
+				files[name] = buf
+			}
+			for _, name := range s.cssOrder(files) {
+				m, err := dist.Manifest()
+				if err != nil {
+					return fmt.Errorf("unable to load manifest: %w", err)
+				}
+				out := s.rewriteCssURLs(m, s.urlPrefix(dist), name, files[name])
+				if bytes.Equal(out, files[name]) {
+					continue
+				}
+				if err := dist.PackBytes(name, out); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		plan: func() ([]string, []string, error) {
+			return []string{"rewrite url() references in packed css"}, nil, nil
+		},
+	})
+}
+
+// cssOrder returns the names of the packed css files, ordered so that css
+// files come after the css files they reference.
+func (s *Script) cssOrder(files map[string][]byte) []string {
+	keys := make(map[string]string, len(files))
+	var names []string
+	for name := range files {
+		keys[s.manifestKey(name)] = name
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var order []string
+	visited := make(map[string]bool)
+	var visit func(string, []string)
+	visit = func(name string, stack []string) {
+		for _, z := range stack {
+			if z == name {
+				warnf(s.flags, "css url() references in %s are circular: hashes of rewritten css may be stale", name)
+				return
+			}
+		}
+		if visited[name] {
+			return
+		}
+		for _, m := range cssURLRE.FindAllSubmatch(files[name], -1) {
+			if key, _, ok := s.cssURLKey(name, string(m[1])); ok && keys[key] != "" && keys[key] != name {
+				visit(keys[key], append(stack, name))
+			}
+		}
+		if !visited[name] {
+			visited[name], order = true, append(order, name)
+		}
+	}
+	for _, name := range names {
+		visit(name, nil)
+	}
+	return order
+}
+
+// cssURLKey returns the manifest key of the url() reference u in the packed
+// css file name, and the reference's query string or fragment. Returns false
+// for data uris, external urls, and fragments.
+func (s *Script) cssURLKey(name, u string) (string, string, bool) {
+	switch {
+	case u == "",
+		strings.HasPrefix(u, "#"),
+		strings.HasPrefix(u, "//"),
+		strings.Contains(u, ":"):
+		return "", "", false
+	}
+	// save query string
+	var qstr string
+	if i := strings.IndexAny(u, "?#"); i != -1 {
+		qstr, u = u[i:], u[:i]
+	}
+	key := u
+	if !strings.HasPrefix(u, "/") {
+		key = path.Join(path.Dir(name), u)
+	}
+	if s.flags.root != "" {
+		key = "/" + s.flags.root + key
+	}
+	return path.Clean(key), qstr, true
+}
+
+// rewriteCssURLs rewrites the url() references in the packed css file name
+// with contents buf, using the manifest m and url prefix. References not in
+// the manifest (such as already packed asset urls) are left as is.
+func (s *Script) rewriteCssURLs(m map[string]string, prefix, name string, buf []byte) []byte {
+	return cssURLRE.ReplaceAllFunc(buf, func(b []byte) []byte {
+		key, qstr, ok := s.cssURLKey(name, string(cssURLRE.FindSubmatch(b)[1]))
+		if !ok {
+			return b
+		}
+		n, ok := m[key]
+		if !ok {
+			return b
+		}
+		return []byte(fmt.Sprintf("url('%s%s%s')", prefix, n, qstr))
+	})
+}
@@ -0,0 +1,130 @@
+package gen
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+func TestRewriteCssURLs(t *testing.T) {
+	m := map[string]string{
+		"/img/a.png":            "111111.aaaaaa.png",
+		"/css/vendor/b.png":     "222222.bbbbbb.png",
+		"/fonts/c.woff2":        "333333.cccccc.woff2",
+		"/css/vendor/font.woff": "555555.eeeeee.woff",
+	}
+	tests := []struct {
+		prefix, name, s, exp string
+	}{
+		{"/", "/css/vendor/x.css", "a{background:url(b.png)}", "a{background:url('/222222.bbbbbb.png')}"},
+		{"/", "/css/vendor/x.css", "a{background:url('../../img/a.png')}", "a{background:url('/111111.aaaaaa.png')}"},
+		{"/", "/css/vendor/x.css", `a{background:url("/img/a.png")}`, "a{background:url('/111111.aaaaaa.png')}"},
+		{"/_/", "/css/vendor/x.css", "a{background:url( /img/a.png )}", "a{background:url('/_/111111.aaaaaa.png')}"},
+		{"/", "/css/vendor/x.css", "@font-face{src:url(font.woff?v=1#iefix)}", "@font-face{src:url('/555555.eeeeee.woff?v=1#iefix')}"},
+		{"/", "/css/x.css", "@font-face{src:url(../fonts/c.woff2)}", "@font-face{src:url('/333333.cccccc.woff2')}"},
+		// left as is
+		{"/", "/css/x.css", "a{background:url(/missing.png)}", "a{background:url(/missing.png)}"},
+		{"/", "/css/x.css", "a{background:url(data:image/png;base64,AAAA)}", "a{background:url(data:image/png;base64,AAAA)}"},
+		{"/", "/css/x.css", "a{background:url(https://example.com/a.png)}", "a{background:url(https://example.com/a.png)}"},
+		{"/", "/css/x.css", "a{background:url(//example.com/a.png)}", "a{background:url(//example.com/a.png)}"},
+		{"/", "/css/x.css", "a{filter:url(#svg)}", "a{filter:url(#svg)}"},
+		{"/_/", "/css/x.css", "a{background:url('/_/111111.aaaaaa.png')}", "a{background:url('/_/111111.aaaaaa.png')}"},
+	}
+	s := &Script{flags: &Flags{}}
+	for i, test := range tests {
+		if out := string(s.rewriteCssURLs(m, test.prefix, test.name, []byte(test.s))); out != test.exp {
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, out)
+		}
+	}
+	// additional roots
+	s = &Script{flags: &Flags{root: "admin"}}
+	m = map[string]string{"/admin/img/a.png": "111111.aaaaaa.png"}
+	if out := string(s.rewriteCssURLs(m, "/", "/css/x.css", []byte("a{background:url(../img/a.png)}"))); out != "a{background:url('/111111.aaaaaa.png')}" {
+		t.Errorf("expected root url rewritten, got: %q", out)
+	}
+}
+
+func TestCssOrder(t *testing.T) {
+	tests := []struct {
+		files map[string]string
+		exp   []string
+	}{
+		{map[string]string{"/a.css": "", "/b.css": ""}, []string{"/a.css", "/b.css"}},
+		{map[string]string{"/a.css": "@import url(b.css);", "/b.css": ""}, []string{"/b.css", "/a.css"}},
+		{map[string]string{"/a.css": "@import url(c/b.css);", "/c/b.css": "@import url(/d.css);", "/d.css": ""}, []string{"/d.css", "/c/b.css", "/a.css"}},
+		{map[string]string{"/a.css": "@import url(a.css);"}, []string{"/a.css"}},
+		{map[string]string{"/a.css": "@import url(b.css);", "/b.css": "@import url(a.css);"}, []string{"/b.css", "/a.css"}},
+	}
+	for i, test := range tests {
+		files := make(map[string][]byte)
+		for n, s := range test.files {
+			files[n] = []byte(s)
+		}
+		flags := &Flags{}
+		flags.Logger, _ = NewLogger(ioutil.Discard, LogQuiet, "")
+		s := &Script{flags: flags}
+		if order := s.cssOrder(files); !reflect.DeepEqual(order, test.exp) {
+			t.Errorf("test %d expected %v, got: %v", i, test.exp, order)
+		}
+	}
+}
+
+func TestCssURLsStep(t *testing.T) {
+	dir := t.TempDir()
+	flags := &Flags{Dist: dir, UrlPrefix: "/"}
+	flags.Logger, _ = NewLogger(ioutil.Discard, LogQuiet, "")
+	dist, err := pack.NewBase(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for n, s := range map[string]string{
+		"/css/a.css":  "@import url(b.css);a{background:url(../img/x.png)}",
+		"/css/b.css":  "b{background:url(/img/x.png)}",
+		"/img/x.png":  "png",
+		"/css/c.css":  "c{}",
+		"/css/z.css":  "@import url(a.css);",
+		"/css/d.css":  "d{background:url(/missing.png)}",
+		"/js/app.css": "@import url('/css/z.css');",
+	} {
+		if err := dist.PackString(n, s); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	s := &Script{flags: flags}
+	s.addCssURLsStep("")
+	if err := s.exec[0].run(dist); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	m, err := dist.Manifest()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// each reference is to the final hashed name
+	for n, refs := range map[string][]string{
+		"/css/a.css":  {"/css/b.css", "/img/x.png"},
+		"/css/b.css":  {"/img/x.png"},
+		"/css/z.css":  {"/css/a.css"},
+		"/js/app.css": {"/css/z.css"},
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(n)))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		for _, ref := range refs {
+			if !strings.Contains(string(buf), "url('/"+m[ref]+"')") {
+				t.Errorf("expected %s to reference %s as %s, got: %s", n, ref, m[ref], buf)
+			}
+		}
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "css", "d.css"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if string(buf) != "d{background:url(/missing.png)}" {
+		t.Errorf("expected d.css unchanged, got: %s", buf)
+	}
+}
@@ -0,0 +1,101 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cssModulesScopedName is the default css modules scoped class name pattern.
+const cssModulesScopedName = "[name]__[local]___[hash:base64:5]"
+
+// setCssModules is the script handler to compile sass entrypoints generating
+// a *.module.css (ie, the .module.scss files in the sass directory) as css
+// modules, with locally scoped class names. The scoped class name pattern can
+// be changed from the default:
+//
+//	cssModules("[local]_[hash:base64:6]")
+//
+// The mapping of original to scoped class names for each module is written to
+// the build directory as json, and to the cssmodules.go of the generated
+// templates package as the CssModules map, keyed by the module name (ie, the
+// generated css name less .module.css).
+func (s *Script) setCssModules(v ...string) error {
+	s.nodeDeps = append(s.nodeDeps, dep{"postcss-modules", ""})
+	s.cssModules = cssModulesScopedName
+	if len(v) != 0 {
+		if v[0] == "" {
+			return fmt.Errorf("invalid cssModules() scoped name %q", v[0])
+		}
+		s.cssModules = v[0]
+	}
+	return nil
+}
+
+// cssModuleName returns the css module name for the sass entrypoint, and
+// whether or not the entrypoint is a css module.
+func (s *Script) cssModuleName(e sassEntry) (string, bool) {
+	if s.cssModules == "" || !strings.HasSuffix(e.name, ".module") {
+		return "", false
+	}
+	return strings.TrimSuffix(e.name, ".module"), true
+}
+
+// loadCssModuleClasses loads the scoped class names written by postcss for
+// the css module.
+func (s *Script) loadCssModuleClasses(e sassEntry, name string) error {
+	buf, err := ioutil.ReadFile(s.sassOut(e, ".classes.json"))
+	if err != nil {
+		return fmt.Errorf("could not read css module classes for %s: %w", name, err)
+	}
+	var classes map[string]string
+	if err := json.Unmarshal(buf, &classes); err != nil {
+		return fmt.Errorf("invalid css module classes for %s: %w", name, err)
+	}
+	if s.cssClasses == nil {
+		s.cssClasses = make(map[string]map[string]string)
+	}
+	s.cssClasses[name] = classes
+	return nil
+}
+
+// writeCssModulesGo writes the css module class names to the cssmodules.go
+// file in the templates package for dir.
+func (s *Script) writeCssModulesGo(dir string) error {
+	if len(s.cssClasses) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range s.cssClasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var entries []string
+	for _, name := range names {
+		var keys, values []string
+		for k := range s.cssClasses[name] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			values = append(values, fmt.Sprintf("%q", s.cssClasses[name][k]))
+		}
+		classes := strings.ReplaceAll(goMapEntries(keys, values), "\n\t", "\n\t\t")
+		if classes != "" {
+			classes += "\t"
+		}
+		entries = append(entries, fmt.Sprintf("\t%q: {%s},", name, classes))
+	}
+	out := dir
+	if s.tplOut != "" {
+		out = s.tplOut
+	}
+	return writeGo(
+		s.flags,
+		filepath.Join(out, cssModulesFile),
+		[]byte(tplf(cssModulesFile, s.templatePkg(dir), "\n"+strings.Join(entries, "\n")+"\n")),
+	)
+}
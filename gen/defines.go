@@ -0,0 +1,93 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// define is a build variable defined by the script.
+type define struct {
+	name  string
+	value interface{}
+}
+
+// define is the script handler to define a build variable, which is
+// substituted in the js (as a global definition), declared as a sass variable
+// in _assetgen.scss, and declared as a constant (and in the Defines map) in
+// the defines.go of the generated templates package.
+func (s *Script) define(name string, value interface{}) error {
+	if !isValidIdentifier(name) {
+		return fmt.Errorf("invalid define() name %q", name)
+	}
+	switch value.(type) {
+	case string, bool, int64, float64:
+	default:
+		return fmt.Errorf("invalid define() %s value type %T", name, value)
+	}
+	for i, d := range s.defines {
+		if d.name == name {
+			s.defines[i].value = value
+			return nil
+		}
+	}
+	s.defines = append(s.defines, define{name, value})
+	return nil
+}
+
+// definesParams returns the uglifyjs params for the defines.
+func (s *Script) definesParams() []string {
+	var params []string
+	for _, d := range s.defines {
+		buf, _ := json.Marshal(d.value)
+		params = append(params, "--define", d.name+"="+string(buf))
+	}
+	return params
+}
+
+// definesScss returns the sass variable declarations for the defines.
+func (s *Script) definesScss() string {
+	var lines []string
+	for _, d := range s.defines {
+		v := fmt.Sprintf("%v", d.value)
+		if z, ok := d.value.(string); ok {
+			v = strconv.Quote(z)
+		}
+		lines = append(lines, fmt.Sprintf("$%s: %s;", d.name, v))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n// defines.\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// writeDefinesGo writes the defines to the defines.go file in the templates
+// package for dir.
+func (s *Script) writeDefinesGo(dir string) error {
+	if len(s.defines) == 0 {
+		return nil
+	}
+	var consts, entries []string
+	for _, d := range s.defines {
+		v := fmt.Sprintf("%#v", d.value)
+		if z, ok := d.value.(float64); ok {
+			v = strconv.FormatFloat(z, 'g', -1, 64)
+			if !strings.ContainsAny(v, ".e") {
+				v += ".0"
+			}
+		}
+		consts = append(consts, fmt.Sprintf("\t%s = %s", d.name, v))
+		entries = append(entries, fmt.Sprintf("\t%q: %s,", d.name, d.name))
+	}
+	out := dir
+	if s.tplOut != "" {
+		out = s.tplOut
+	}
+	return writeGo(
+		s.flags,
+		filepath.Join(out, definesFile),
+		[]byte(tplf(definesFile, s.templatePkg(dir), strings.Join(consts, "\n"), strings.Join(entries, "\n"))),
+	)
+}
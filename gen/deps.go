@@ -0,0 +1,149 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// bundleDeps holds the source files contributing to a build output.
+type bundleDeps struct {
+	out     string
+	sources []string
+}
+
+// addDeps records the source files contributing to the build output out (a
+// packed asset name, or a generated file relative to the working directory).
+// Sources are recorded relative to the working directory, when contained
+// within it.
+func (s *Script) addDeps(out string, sources ...string) {
+	d := bundleDeps{out: out}
+	for _, n := range sources {
+		d.sources = append(d.sources, s.depPath(n))
+	}
+	s.flags.deps = append(s.flags.deps, d)
+}
+
+// depPath returns n relative to the working directory, when n is contained
+// within it.
+func (s *Script) depPath(n string) string {
+	return wdPath(s.flags.Wd, n)
+}
+
+// wdPath returns n relative to the working directory wd, when n is contained
+// within it.
+func wdPath(wd, n string) string {
+	if filepath.IsAbs(n) {
+		if rel, err := filepath.Rel(wd, n); err == nil && !strings.HasPrefix(rel, "..") {
+			n = rel
+		}
+	}
+	return filepath.ToSlash(n)
+}
+
+// sourceMapRE matches an embedded source map.
+var sourceMapRE = regexp.MustCompile(`sourceMappingURL=data:application/json;(?:charset=utf-8;)?base64,([A-Za-z0-9+/=]+)`)
+
+// sourceMapSources returns the sources in the source map embedded in the file
+// n, resolved relative to the file's directory.
+func sourceMapSources(n string) ([]string, error) {
+	buf, err := ioutil.ReadFile(n)
+	if err != nil {
+		return nil, err
+	}
+	m := sourceMapRE.FindSubmatch(buf)
+	if m == nil {
+		return nil, nil
+	}
+	dec, err := base64.StdEncoding.DecodeString(string(m[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source map in %s: %w", n, err)
+	}
+	var v struct {
+		Sources []string `json:"sources"`
+	}
+	if err := json.Unmarshal(dec, &v); err != nil {
+		return nil, fmt.Errorf("invalid source map in %s: %w", n, err)
+	}
+	var sources []string
+	for _, z := range v.Sources {
+		z = filepath.FromSlash(strings.TrimPrefix(z, "file://"))
+		if !filepath.IsAbs(z) {
+			z = filepath.Join(filepath.Dir(n), z)
+		}
+		if fileExists(z) {
+			sources = append(sources, z)
+		}
+	}
+	return sources, nil
+}
+
+// qtcCatRE matches a quicktemplate cat tag, which includes a file.
+var qtcCatRE = regexp.MustCompile(`\{%-?\s*cat\s+"([^"]+)"\s*-?%\}`)
+
+// templateIncludes returns the files included by the quicktemplate n.
+func templateIncludes(n string) ([]string, error) {
+	buf, err := ioutil.ReadFile(n)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, m := range qtcCatRE.FindAllSubmatch(buf, -1) {
+		files = append(files, filepath.Join(filepath.Dir(n), filepath.FromSlash(string(m[1]))))
+	}
+	return files, nil
+}
+
+// writeDeps writes the dependency graph of the build outputs to the reports
+// directory as json, and when flags.DepsDot is set, as a graphviz dot file.
+func writeDeps(flags *Flags) error {
+	graph := make(map[string][]string)
+	for _, d := range flags.deps {
+		graph[d.out] = append(graph[d.out], d.sources...)
+	}
+	var outs []string
+	for out, sources := range graph {
+		outs = append(outs, out)
+		graph[out] = uniqueStrings(sources)
+	}
+	sort.Strings(outs)
+	buf, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeChanged(filepath.Join(flags.reports, depsFile), append(buf, '\n')); err != nil {
+		return err
+	}
+	if !flags.DepsDot {
+		return nil
+	}
+	dot := new(bytes.Buffer)
+	fmt.Fprintln(dot, "digraph deps {")
+	fmt.Fprintln(dot, "  rankdir=LR;")
+	for _, out := range outs {
+		for _, n := range graph[out] {
+			fmt.Fprintf(dot, "  %q -> %q;\n", n, out)
+		}
+	}
+	fmt.Fprintln(dot, "}")
+	return writeChanged(filepath.Join(flags.reports, depsDotFile), dot.Bytes())
+}
+
+// uniqueStrings returns v without duplicates, preserving order.
+func uniqueStrings(v []string) []string {
+	seen := make(map[string]bool, len(v))
+	var u []string
+	for _, s := range v {
+		if !seen[s] {
+			seen[s] = true
+			u = append(u, s)
+		}
+	}
+	return u
+}
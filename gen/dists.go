@@ -0,0 +1,270 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// namedDist is a named dist declared by the script, packed, and generated as
+// a Go package, separately from the default dist.
+type namedDist struct {
+	// name is the name of the dist, and the generated package name.
+	name string
+	// dir is the generated package directory.
+	dir string
+	// dist is the dist directory.
+	dist string
+	// urlPrefix is the url prefix for the packed assets.
+	urlPrefix string
+	// pack is the dist's pack, created before the script is run.
+	pack *pack.Pack
+}
+
+// distNameRE matches valid dist names.
+var distNameRE = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// dist is the script handler to declare a named dist, to which the steps
+// declared after it in the script (js, sass entrypoints, remote, plugin, and
+// so on) are packed, instead of the default dist:
+//
+//	dist("admin", {"urlPrefix": "/admin/_/"})
+//
+// Each named dist is packed to the dist directory of its own package
+// directory in the assets directory (ie, assets/admin/dist), with its own
+// manifest and generated assets.go. Directory steps (fonts, images, sass,
+// templates, and emails) always pack to the default dist, and steps declared
+// after dist("") pack to the default dist. The url prefix defaults to the url
+// prefix with the name appended (ie, /_/admin/).
+func (s *Script) dist(name string, v ...interface{}) error {
+	if s.flags.root != "" {
+		return fmt.Errorf("dist() is not supported for additional roots")
+	}
+	s.assignDists()
+	if name == "" {
+		s.distName = ""
+		return nil
+	}
+	switch name {
+	case distDir, fontsDir, imagesDir, jsDir, sassDir, cssDir, templatesDir, emailsDir, nodeModulesDir:
+		return fmt.Errorf("dist() name %q is reserved", name)
+	}
+	if !distNameRE.MatchString(name) {
+		return fmt.Errorf("invalid dist() name %q", name)
+	}
+	d := s.namedDist(name)
+	if d == nil {
+		dir := filepath.Join(s.flags.Assets, name)
+		d = &namedDist{
+			name:      name,
+			dir:       dir,
+			dist:      filepath.Join(dir, distDir),
+			urlPrefix: strings.TrimSuffix(s.flags.UrlPrefix, "/") + "/" + name + "/",
+		}
+		s.dists = append(s.dists, d)
+	}
+	for _, z := range v {
+		opts, ok := z.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("unknown type passed to dist(): %T", z)
+		}
+		for k, v := range opts {
+			switch k {
+			case "urlPrefix":
+				d.urlPrefix = forceString(v)
+			default:
+				return fmt.Errorf("invalid dist() option %v", k)
+			}
+		}
+	}
+	s.distName = name
+	return nil
+}
+
+// assignDists assigns the current dist to the steps declared since the last
+// call.
+func (s *Script) assignDists() {
+	for i := s.distStart; i < len(s.exec); i++ {
+		s.exec[i].dist = s.distName
+	}
+	s.distStart = len(s.exec)
+}
+
+// namedDist returns the named dist, or nil when not declared.
+func (s *Script) namedDist(name string) *namedDist {
+	for _, d := range s.dists {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// distMarkerFile is the file marking a named dist's dist directory as created
+// by assetgen.
+const distMarkerFile = ".assetgen-dist"
+
+// createDists recreates the dist directories and packs of the named dists.
+//
+// An existing dist directory is only removed when it was created by assetgen,
+// as the directory is named by the script.
+func (s *Script) createDists() error {
+	for _, d := range s.dists {
+		if err := checkDistDir(d.dist, s.flags.PackManifest); err != nil {
+			return fmt.Errorf("cannot create dist %s: %w", d.name, err)
+		}
+		if s.flags.IsolateBuild {
+			if err := stageDir(s.flags, &d.dist); err != nil {
+				return err
+			}
+		} else if err := os.RemoveAll(d.dist); err != nil {
+			return fmt.Errorf("unable to remove %s: %w", d.dist, err)
+		}
+		if err := os.MkdirAll(d.dist, 0755); err != nil {
+			return fmt.Errorf("unable to create %s: %w", d.dist, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(d.dist, distMarkerFile), nil, 0644); err != nil {
+			return fmt.Errorf("unable to write %s: %w", distMarkerFile, err)
+		}
+		// ignore the dist directory
+		if gitignore := filepath.Join(d.dir, gitignoreFile); !s.flags.NoScaffold && !fileExists(gitignore) {
+			if err := ioutil.WriteFile(gitignore, []byte(tplf("gitignore")), 0644); err != nil {
+				return fmt.Errorf("unable to write %s: %w", gitignore, err)
+			}
+		}
+		var err error
+		if d.pack, err = pack.NewBase(d.dist, packOptions(s.flags)...); err != nil {
+			return fmt.Errorf("unable to create dist %s: %w", d.name, err)
+		}
+	}
+	return nil
+}
+
+// checkDistDir checks that the dist directory dir, when it exists, is a
+// directory created by assetgen: it is empty, or contains the dist marker or
+// the manifest.
+func checkDistDir(dir, manifest string) error {
+	fi, err := os.Lstat(dir)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case !fi.IsDir():
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	for _, n := range []string{distMarkerFile, manifest} {
+		if fileExists(filepath.Join(dir, n)) {
+			return nil
+		}
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err == io.EOF {
+		return nil
+	}
+	return fmt.Errorf("refusing to remove %s: not created by assetgen (no %s or %s)", dir, distMarkerFile, manifest)
+}
+
+// packOptions returns the options for a dist's pack, with the manifest name
+// and exclusion patterns, and the additional options.
+func packOptions(flags *Flags, opts ...pack.Option) []pack.Option {
+	opts = append(opts, pack.WithManifest(flags.PackManifest))
+	var patterns []string
+	for _, z := range strings.Split(flags.ManifestExclude, ",") {
+		if z = strings.TrimSpace(z); z != "" {
+			patterns = append(patterns, z)
+		}
+	}
+	if len(patterns) != 0 {
+		opts = append(opts, pack.WithManifestExclude(patterns...))
+	}
+	return opts
+}
+
+// distPack returns the pack for the named dist, or dist when name is empty.
+func (s *Script) distPack(dist *pack.Pack, name string) (*pack.Pack, error) {
+	if name == "" {
+		return dist, nil
+	}
+	d := s.namedDist(name)
+	if d == nil || d.pack == nil {
+		return nil, fmt.Errorf("dist %s was not created", name)
+	}
+	return d.pack, nil
+}
+
+// distPath returns the dist directory of the named dist, or of the default
+// dist when name is empty.
+func (s *Script) distPath(name string) string {
+	if d := s.namedDist(name); d != nil {
+		return d.dist
+	}
+	return s.flags.Dist
+}
+
+// distOf returns the named dist of the pack, or nil for the default dist.
+func (s *Script) distOf(p *pack.Pack) *namedDist {
+	for _, d := range s.dists {
+		if d.pack == p {
+			return d
+		}
+	}
+	return nil
+}
+
+// urlPrefix returns the url prefix of the dist the pack belongs to.
+func (s *Script) urlPrefix(p *pack.Pack) string {
+	if d := s.distOf(p); d != nil {
+		return d.urlPrefix
+	}
+	return s.flags.UrlPrefix
+}
+
+// setPack sets the pack used by the IPC callbacks.
+func (s *Script) setPack(p *pack.Pack) {
+	s.curMu.Lock()
+	defer s.curMu.Unlock()
+	s.cur = p
+}
+
+// curPack returns the pack used by the IPC callbacks, or dist when not set.
+func (s *Script) curPack(dist *pack.Pack) *pack.Pack {
+	s.curMu.Lock()
+	defer s.curMu.Unlock()
+	if s.cur != nil {
+		return s.cur
+	}
+	return dist
+}
+
+// distFlags returns a copy of flags for generating the named dist's package.
+func distFlags(flags *Flags, d *namedDist) *Flags {
+	f := *flags
+	f.Assets, f.Dist, f.UrlPrefix = d.dir, d.dist, d.urlPrefix
+	f.AssetsGo, f.AssetsPkg = filepath.Join(d.dir, assetsFile), d.name
+	return &f
+}
+
+// writeDistsGo writes the manifests and generated packages of the named
+// dists.
+func (s *Script) writeDistsGo(flags *Flags) error {
+	for _, d := range s.dists {
+		f := distFlags(flags, d)
+		f.goDirs = nil
+		if err := writeAssetsGo(f, d.pack, s.prof.debug); err != nil {
+			return fmt.Errorf("could not write %s for dist %s: %w", assetsFile, d.name, err)
+		}
+		flags.goDirs = append(flags.goDirs, f.goDirs...)
+	}
+	return nil
+}
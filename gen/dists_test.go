@@ -0,0 +1,104 @@
+package gen
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNamedDist(t *testing.T) {
+	for _, isolate := range []bool{false, true} {
+		dir := t.TempDir()
+		flags := &Flags{
+			Assets:       dir,
+			UrlPrefix:    "/_/",
+			PackManifest: "manifest.json",
+			NoScaffold:   true,
+			IsolateBuild: isolate,
+		}
+		s := &Script{flags: flags}
+		if err := s.dist("admin"); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		// build twice, so the second build replaces the first
+		for i := 0; i < 2; i++ {
+			if err := s.createDists(); err != nil {
+				t.Fatalf("isolate %t build %d expected no error, got: %v", isolate, i, err)
+			}
+			d := s.namedDist("admin")
+			if d.urlPrefix != "/_/admin/" {
+				t.Errorf("expected url prefix %q, got: %q", "/_/admin/", d.urlPrefix)
+			}
+			if err := d.pack.PackString("js/app.js", "x()"); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			m, err := d.pack.Manifest()
+			if err != nil {
+				t.Fatalf("isolate %t build %d expected no error, got: %v", isolate, i, err)
+			}
+			if len(m) != 1 || m["/js/app.js"] == "" {
+				t.Errorf("isolate %t build %d expected manifest with /js/app.js, got: %v", isolate, i, m)
+			}
+			if err := d.pack.WriteManifestInverted(); err != nil {
+				t.Fatalf("isolate %t build %d expected no error, got: %v", isolate, i, err)
+			}
+			if err := swapStaged(flags); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			buf, err := ioutil.ReadFile(filepath.Join(dir, "admin", distDir, "manifest.json"))
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			var v map[string]string
+			if err := json.Unmarshal(buf, &v); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if len(v) != 1 || v[m["/js/app.js"]] != "/js/app.js" {
+				t.Errorf("isolate %t build %d expected inverted manifest, got: %v", isolate, i, v)
+			}
+		}
+	}
+}
+
+func TestCheckDistDir(t *testing.T) {
+	tests := []struct {
+		files []string
+		ok    bool
+	}{
+		{nil, true},
+		{[]string{distMarkerFile}, true},
+		{[]string{"manifest.json"}, true},
+		{[]string{distMarkerFile, "app.js"}, true},
+		{[]string{"manifest.json", "app.js"}, true},
+		{[]string{"app.js"}, false},
+		{[]string{"other.json"}, false},
+	}
+	for i, test := range tests {
+		dir := filepath.Join(t.TempDir(), "dist")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		for _, n := range test.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, n), nil, 0644); err != nil {
+				t.Fatalf("test %d expected no error, got: %v", i, err)
+			}
+		}
+		if err := checkDistDir(dir, "manifest.json"); (err == nil) != test.ok {
+			t.Errorf("test %d expected ok %t, got: %v", i, test.ok, err)
+		}
+	}
+	// missing
+	if err := checkDistDir(filepath.Join(t.TempDir(), "missing"), "manifest.json"); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	// not a directory
+	n := filepath.Join(t.TempDir(), "dist")
+	if err := ioutil.WriteFile(n, nil, 0644); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := checkDistDir(n, "manifest.json"); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,269 @@
+package gen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// doctorMinFree is the free disk space below which the doctor command warns.
+const doctorMinFree = 1 << 30
+
+// diagnosis is the result of a doctor check.
+type diagnosis struct {
+	name string
+	// status is ok, warn, or fail.
+	status string
+	msg    string
+	fix    string
+}
+
+// Doctor checks the environment used for generating assets, printing the
+// result of each check along with a fix for any problems found.
+//
+// An error is returned when any check fails.
+func Doctor(flags *Flags) error {
+	if err := initFlags(flags); err != nil {
+		return err
+	}
+	var diags []diagnosis
+	for _, f := range []func(*Flags) []diagnosis{
+		doctorCache,
+		doctorTools,
+		doctorDownloads,
+		doctorBinLinks,
+		doctorImagemin,
+		doctorIpc,
+		doctorDisk,
+	} {
+		diags = append(diags, f(flags)...)
+	}
+	return printDiagnoses(os.Stdout, diags)
+}
+
+// printDiagnoses prints the diagnoses, returning an error when any failed.
+func printDiagnoses(w io.Writer, diags []diagnosis) error {
+	var failed int
+	fmt.Fprintln(w, "DOCTOR:")
+	for _, d := range diags {
+		fmt.Fprintf(w, "  %-4s %s: %s\n", strings.ToUpper(d.status), d.name, d.msg)
+		if d.fix != "" {
+			fmt.Fprintf(w, "       fix: %s\n", d.fix)
+		}
+		if d.status == "fail" {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("found %d problems", failed)
+	}
+	return nil
+}
+
+// doctorCache checks the cache and build directories are writable.
+func doctorCache(flags *Flags) []diagnosis {
+	var diags []diagnosis
+	for _, d := range []struct{ n, dir, fix string }{
+		{"cache", flags.Cache, "fix the permissions, or use -cache (or ASSETGEN_CACHE) for a writable directory"},
+		{"build", flags.Build, "fix the permissions, or use -build for a writable directory"},
+	} {
+		if err := os.MkdirAll(d.dir, 0755); err != nil {
+			diags = append(diags, diagnosis{d.n, "fail", fmt.Sprintf("cannot create %s: %v", d.dir, err), d.fix})
+			continue
+		}
+		f, err := ioutil.TempFile(d.dir, ".doctor")
+		if err != nil {
+			diags = append(diags, diagnosis{d.n, "fail", fmt.Sprintf("%s is not writable: %v", d.dir, err), d.fix})
+			continue
+		}
+		f.Close()
+		os.Remove(f.Name())
+		diags = append(diags, diagnosis{d.n, "ok", d.dir + " is writable", ""})
+	}
+	return diags
+}
+
+// doctorTools checks node, and yarn or bun, are available and satisfy their
+// constraints, without retrieving them.
+func doctorTools(flags *Flags) []diagnosis {
+	type tool struct {
+		name, dir, bin, constraint, version string
+	}
+	tools := []tool{
+		{"node", flags.Node, flags.NodeBin, flags.NodeConstraint, flags.NodeVersion},
+		{"yarn", flags.Yarn, flags.YarnBin, flags.YarnConstraint, flags.YarnVersion},
+	}
+	switch {
+	case flags.Runtime == "bun":
+		tools = []tool{{"bun", flags.Bun, flags.BunBin, flags.BunConstraint, flags.BunVersion}}
+	case isBerryPackageManager(flags):
+		tools[1].constraint = berryConstraint
+	}
+	var diags []diagnosis
+	for _, t := range tools {
+		bin := t.bin
+		switch {
+		case bin == "" && t.dir != "":
+			bin = filepath.Join(t.dir, "bin", t.name)
+		case bin == "":
+			if _, b, ok := systemTool(flags, t.name, t.constraint, systemWant(flags, t.name, t.version)); ok {
+				bin = b
+			}
+		}
+		if bin == "" {
+			msg := fmt.Sprintf("no %s on PATH satisfying %s", t.name, t.constraint)
+			if flags.Vendored {
+				diags = append(diags, diagnosis{t.name, "warn", msg + ": the vendored version will be used", ""})
+				continue
+			}
+			diags = append(diags, diagnosis{t.name, "warn", msg + ": it will be retrieved", "use -" + t.name + " to use an installed " + t.name})
+			continue
+		}
+		out, err := runCombined(flags, bin, "--version")
+		if err != nil {
+			diags = append(diags, diagnosis{t.name, "fail", fmt.Sprintf("%s --version failed: %v", bin, err), "reinstall " + t.name + ", or use -force-download"})
+			continue
+		}
+		v := strings.TrimPrefix(out, "v")
+		if !compareSemver(v, t.constraint) {
+			diags = append(diags, diagnosis{t.name, "fail", fmt.Sprintf("%s %s does not satisfy %s", bin, v, t.constraint), "use -" + t.name + "-version or -" + t.name + " to select a matching " + t.name + ", or change -" + t.name + "-constraint"})
+			continue
+		}
+		diags = append(diags, diagnosis{t.name, "ok", fmt.Sprintf("%s %s", bin, v), ""})
+	}
+	return diags
+}
+
+// doctorDownloads checks the retrieved files in the cache match their
+// recorded checksums. Node and yarn archives have their signatures verified
+// when retrieved.
+func doctorDownloads(flags *Flags) []diagnosis {
+	var count int
+	var bad []string
+	err := filepath.Walk(flags.Cache, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() && n == flags.NodeModules:
+			return filepath.SkipDir
+		case fi.IsDir() || !strings.HasSuffix(n, ".sha256"):
+			return nil
+		}
+		base := strings.TrimSuffix(n, ".sha256")
+		if !fileExists(base) {
+			return nil
+		}
+		count++
+		if _, err := readCached(base); err != nil {
+			bad = append(bad, base)
+		}
+		return nil
+	})
+	switch {
+	case err != nil && !os.IsNotExist(err):
+		return []diagnosis{{"downloads", "fail", fmt.Sprintf("could not read %s: %v", flags.Cache, err), ""}}
+	case len(bad) != 0:
+		return []diagnosis{{"downloads", "fail", "checksum mismatch: " + strings.Join(bad, ", "), "remove the listed files, and build again to retrieve them"}}
+	}
+	return []diagnosis{{"downloads", "ok", fmt.Sprintf("%d cached files match their checksums", count), ""}}
+}
+
+// doctorBinLinks checks for broken links in node_modules/.bin.
+func doctorBinLinks(flags *Flags) []diagnosis {
+	dir := flags.NodeModulesBin
+	if dir == "" {
+		dir = filepath.Join(flags.Cache, nodeModulesDir, nodeModulesBinDir)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return []diagnosis{{"node_modules/.bin", "ok", dir + " not yet created", ""}}
+	case err != nil:
+		return []diagnosis{{"node_modules/.bin", "fail", fmt.Sprintf("could not read %s: %v", dir, err), ""}}
+	}
+	var broken []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(dir, e.Name())); err != nil {
+			broken = append(broken, e.Name())
+		}
+	}
+	if len(broken) != 0 {
+		return []diagnosis{{"node_modules/.bin", "fail", "broken links: " + strings.Join(broken, ", "), "remove " + dir + ", and build again to recreate the links"}}
+	}
+	return []diagnosis{{"node_modules/.bin", "ok", fmt.Sprintf("%d links", len(entries)), ""}}
+}
+
+// imageminBins are the native binaries used by the imagemin plugins, by node
+// package.
+var imageminBins = map[string]string{
+	"gifsicle":     "gifsicle",
+	"guetzli":      "guetzli",
+	"pngquant-bin": "pngquant",
+}
+
+// doctorImagemin checks the native binaries of the installed imagemin plugins
+// were built or retrieved.
+func doctorImagemin(flags *Flags) []diagnosis {
+	nodeModules := flags.NodeModules
+	if nodeModules == "" {
+		nodeModules = filepath.Join(flags.Cache, nodeModulesDir)
+	}
+	var diags []diagnosis
+	for _, pkg := range []string{"gifsicle", "guetzli", "pngquant-bin"} {
+		bin := imageminBins[pkg]
+		if runtime.GOOS == "windows" {
+			bin += ".exe"
+		}
+		dir := filepath.Join(nodeModules, pkg)
+		if !fileExists(dir) {
+			continue
+		}
+		if n := filepath.Join(dir, "vendor", bin); !fileExists(n) {
+			diags = append(diags, diagnosis{"imagemin", "fail", fmt.Sprintf("%s is missing %s", pkg, n), "install the build tools for " + pkg + " (ie, autoconf, automake, libtool, make, and libpng headers), and remove " + dir + " to reinstall it"})
+			continue
+		}
+		diags = append(diags, diagnosis{"imagemin", "ok", pkg + " binary present", ""})
+	}
+	return diags
+}
+
+// doctorIpc checks the ipc transport can be listened on and connected to.
+func doctorIpc(flags *Flags) []diagnosis {
+	fix := "use -ipc-transport=tcp"
+	s, err := NewIpcServer(nil, WithIpcTransport(flags.IpcTransport), WithIpcLogf(func(string, ...interface{}) {}))
+	if err != nil {
+		return []diagnosis{{"ipc", "fail", err.Error(), fix}}
+	}
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		return []diagnosis{{"ipc", "fail", fmt.Sprintf("could not listen on %s: %v", s.SocketPath(), err), fix}}
+	}
+	if s.transport != "pipe" {
+		conn, err := net.Dial(s.transport, s.addr)
+		if err != nil {
+			return []diagnosis{{"ipc", "fail", fmt.Sprintf("could not connect to %s: %v", s.SocketPath(), err), fix}}
+		}
+		conn.Close()
+	}
+	return []diagnosis{{"ipc", "ok", s.transport + " transport", ""}}
+}
+
+// doctorDisk checks the free disk space for the cache directory.
+func doctorDisk(flags *Flags) []diagnosis {
+	free, err := diskFree(flags.Cache)
+	switch {
+	case err != nil:
+		return []diagnosis{{"disk", "warn", fmt.Sprintf("could not determine free space: %v", err), ""}}
+	case free < doctorMinFree:
+		return []diagnosis{{"disk", "warn", fmt.Sprintf("%s free for %s", formatBytes(int64(free)), flags.Cache), "free disk space, or use -cache for a directory on another disk"}}
+	}
+	return []diagnosis{{"disk", "ok", fmt.Sprintf("%s free for %s", formatBytes(int64(free)), flags.Cache), ""}}
+}
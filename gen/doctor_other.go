@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package gen
+
+import (
+	"errors"
+)
+
+// diskFree returns the free disk space available for dir.
+func diskFree(string) (uint64, error) {
+	return 0, errors.New("not supported on this platform")
+}
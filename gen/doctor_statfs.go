@@ -0,0 +1,17 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package gen
+
+import (
+	"syscall"
+)
+
+// diskFree returns the free disk space available for dir.
+func diskFree(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,111 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// dryRun loads the script, and prints the resolved directories, node
+// dependencies, and the ordered script steps, along with the commands each
+// step would run and the files each step would pack, without executing
+// anything.
+func dryRun(flags *Flags) error {
+	// determine package manager without installing any tools
+	if err := resolvePackageManager(flags); err != nil {
+		return err
+	}
+	// load script
+	s, err := LoadScript(flags)
+	if err != nil {
+		return fmt.Errorf("unable to load script %s: %w", flags.Script, err)
+	}
+	roots, err := loadRoots(flags)
+	if err != nil {
+		return err
+	}
+	w := os.Stdout
+	// directories
+	fmt.Fprintln(w, "DIRECTORIES:")
+	for _, d := range []struct {
+		n, dir string
+	}{
+		{"wd", flags.Wd},
+		{"workspace", flags.workspace},
+		{"cache", flags.Cache},
+		{"build", flags.Build},
+		{"assets", flags.Assets},
+		{"dist", flags.Dist},
+		{"script", flags.Script},
+		{"assets.go", flags.AssetsGo},
+		{"node_modules", flags.NodeModules},
+		{"node_modules/.bin", flags.NodeModulesBin},
+	} {
+		if d.dir == "" {
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %s\n", d.n, d.dir)
+	}
+	for _, d := range s.dists {
+		fmt.Fprintf(w, "  dist (%s): %s (%s)\n", d.name, d.dist, d.urlPrefix)
+	}
+	// node deps
+	fmt.Fprintln(w, "NODE DEPS:")
+	for _, d := range s.nodeDeps {
+		if d.ver != "" {
+			fmt.Fprintf(w, "  %s@%s\n", d.name, d.ver)
+		} else {
+			fmt.Fprintf(w, "  %s\n", d.name)
+		}
+	}
+	for _, d := range s.resolutions {
+		fmt.Fprintf(w, "  %s: %s@%s\n", s.resolutionsKey(), d.name, d.ver)
+	}
+	switch params, err := s.addDepsParams(); {
+	case flags.NoInstall:
+		fmt.Fprintln(w, "  (node packages managed by the project)")
+	case err != nil && !os.IsNotExist(err):
+		return fmt.Errorf("unable to configure dependencies: %w", err)
+	case err != nil:
+		fmt.Fprintln(w, "  (package.json would be generated, and all deps added)")
+	case params != nil:
+		fmt.Fprintf(w, "  $ %s\n", strings.ReplaceAll(formatCommand(flags.YarnBin, params...), "\n", "\n  "))
+	}
+	// steps
+	fmt.Fprintln(w, "STEPS:")
+	if err := printSteps(w, s); err != nil {
+		return err
+	}
+	for _, r := range roots {
+		fmt.Fprintf(w, "STEPS (%s -> /%s):\n", r.flags.Assets, r.flags.root)
+		if err := printSteps(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printSteps prints the script's steps, along with the commands each step
+// would run and the files each step would pack.
+func printSteps(w io.Writer, s *Script) error {
+	for i, st := range s.exec {
+		if st.dist != "" {
+			fmt.Fprintf(w, "  %d. %s -> dist %s\n", i+1, st.name, st.dist)
+		} else {
+			fmt.Fprintf(w, "  %d. %s\n", i+1, st.name)
+		}
+		cmds, files, err := st.plan()
+		if err != nil {
+			return fmt.Errorf("could not plan %s: %w", st.name, err)
+		}
+		for _, cmd := range cmds {
+			fmt.Fprintf(w, "    $ %s\n", strings.ReplaceAll(cmd, "\n", "\n    "))
+		}
+		for _, file := range files {
+			fmt.Fprintf(w, "    + %s\n", path.Join("/", s.flags.root, file))
+		}
+	}
+	return nil
+}
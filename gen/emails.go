@@ -0,0 +1,174 @@
+package gen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// addEmails configures a script step for compiling the email templates in
+// the emails directory.
+//
+// MJML (.mjml) templates are compiled to html with mjml, and the css of html
+// (.html) templates is inlined with juice. The asset references (src, href,
+// and background attributes, and css url() references) of the compiled emails
+// are rewritten to absolute urls of the packed assets using -email-url-prefix,
+// and the emails are packed to the emails directory in the dist (ie,
+// emails/welcome.html). Files starting with _ are not compiled, and can be
+// included by the templates (ie, with mj-include).
+func (s *Script) addEmails(_, dir string) {
+	for _, n := range []string{
+		"juice",
+		"mjml",
+	} {
+		s.nodeDeps = append(s.nodeDeps, dep{n, ""})
+	}
+	s.exec = append(s.exec, step{
+		name: "emails",
+		run: func(dist *pack.Pack) error {
+			files, err := s.emailFiles(dir)
+			if err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				return nil
+			}
+			if u, err := url.Parse(s.flags.EmailUrlPrefix); err != nil || !u.IsAbs() {
+				warnf(s.flags, "email url prefix %q is not an absolute url (see -email-url-prefix)", s.flags.EmailUrlPrefix)
+			}
+			if err := ioutil.WriteFile(filepath.Join(s.flags.Build, emailsJs), []byte(tplf(emailsJs)), 0644); err != nil {
+				return fmt.Errorf("could not write %s: %w", emailsJs, err)
+			}
+			m, err := dist.Manifest()
+			if err != nil {
+				return fmt.Errorf("unable to load manifest: %w", err)
+			}
+			for _, fn := range files {
+				out := s.emailOut(fn)
+				if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+					return err
+				}
+				if err := runSilent(s.flags, s.flags.NodeBin, filepath.Join(s.flags.Build, emailsJs), filepath.Join(dir, fn), out); err != nil {
+					return fmt.Errorf("could not compile email %s: %w", fn, err)
+				}
+				buf, err := ioutil.ReadFile(out)
+				if err != nil {
+					return err
+				}
+				name := s.emailName(fn)
+				if err := dist.PackBytes(name, s.rewriteEmailURLs(m, name, buf)); err != nil {
+					return err
+				}
+				s.addDeps(s.manifestKey(name), filepath.Join(dir, fn))
+			}
+			return nil
+		},
+		plan: func() ([]string, []string, error) {
+			files, err := s.emailFiles(dir)
+			if err != nil {
+				return nil, nil, err
+			}
+			var cmds, names []string
+			for _, fn := range files {
+				cmds = append(cmds, formatCommand("node", filepath.Join(s.flags.Build, emailsJs), filepath.Join(dir, fn), s.emailOut(fn)))
+				names = append(names, s.emailName(fn))
+			}
+			if len(files) != 0 {
+				cmds = append(cmds, "rewrite asset references to "+s.flags.EmailUrlPrefix)
+			}
+			return cmds, names, nil
+		},
+	})
+}
+
+// emailFiles returns the email templates in dir, relative to dir.
+func (s *Script) emailFiles(dir string) ([]string, error) {
+	var files []string
+	err := walk(s.flags, dir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case s.ignored(n, fi):
+			return skipDir(fi)
+		case fi.IsDir():
+			return nil
+		}
+		switch base := filepath.Base(n); {
+		case strings.HasPrefix(base, "_"), strings.HasPrefix(base, "."):
+			return nil
+		case strings.HasSuffix(base, ".mjml"), strings.HasSuffix(base, ".html"):
+			files = append(files, strings.TrimPrefix(n, dir+"/"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// emailName returns the packed name of the email template fn.
+func (s *Script) emailName(fn string) string {
+	return emailsDir + "/" + strings.TrimSuffix(filepath.ToSlash(fn), filepath.Ext(fn)) + ".html"
+}
+
+// emailOut returns the path in the build directory of the compiled email
+// template fn.
+func (s *Script) emailOut(fn string) string {
+	return filepath.Join(s.flags.Build, filepath.FromSlash(s.emailName(fn)))
+}
+
+// emailAttrRE matches the src, href, and background attributes of html
+// elements.
+var emailAttrRE = regexp.MustCompile(`(?i)(\s(?:src|href|background)\s*=\s*)(["'])([^"']*)(["'])`)
+
+// rewriteEmailURLs rewrites the asset references in the compiled email name
+// with contents buf to absolute urls, using the manifest m. References are
+// resolved relative to the packed email (or to the dist root when absolute).
+// References that are not packed assets, or that are external urls, anchors,
+// or template actions, are left as is.
+func (s *Script) rewriteEmailURLs(m map[string]string, name string, buf []byte) []byte {
+	rewrite := func(u string) (string, bool) {
+		switch {
+		case u == "",
+			strings.HasPrefix(u, "#"),
+			strings.HasPrefix(u, "//"),
+			strings.Contains(u, "{{"),
+			strings.Contains(u, ":"):
+			return "", false
+		}
+		z, qstr := splitAssetURL(u)
+		key := z
+		if !strings.HasPrefix(z, "/") {
+			key = path.Join("/", path.Dir(name), z)
+		}
+		n, ok := m[s.manifestKey(path.Clean(key))]
+		if !ok {
+			warnf(s.flags, "no asset %q in manifest for email %s", u, name)
+			return "", false
+		}
+		return s.flags.EmailUrlPrefix + n + qstr, true
+	}
+	buf = emailAttrRE.ReplaceAllFunc(buf, func(b []byte) []byte {
+		v := emailAttrRE.FindSubmatch(b)
+		u, ok := rewrite(string(v[3]))
+		if !ok {
+			return b
+		}
+		return []byte(string(v[1]) + string(v[2]) + u + string(v[4]))
+	})
+	return cssURLRE.ReplaceAllFunc(buf, func(b []byte) []byte {
+		u, ok := rewrite(string(cssURLRE.FindSubmatch(b)[1]))
+		if !ok {
+			return b
+		}
+		return []byte(fmt.Sprintf("url('%s')", u))
+	})
+}
@@ -0,0 +1,390 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stepErrorMaxOutput is the maximum command output retained by a StepError.
+const stepErrorMaxOutput = 64 * 1024
+
+// stepErrorReportLines is the number of output lines included in a StepError
+// report.
+const stepErrorReportLines = 20
+
+// StepError is a failed build step, with the command that was run and its
+// captured stderr.
+type StepError struct {
+	// Step is the name of the tool that failed (ie, node-sass).
+	Step string
+	// Command is the formatted command.
+	Command string
+	// Output is the tail of the command's stderr.
+	Output []byte
+	// Err is the underlying error.
+	Err error
+}
+
+// newStepError creates a step error for the command.
+func newStepError(name string, params []string, output []byte, err error) *StepError {
+	return &StepError{
+		Step:    strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)),
+		Command: formatCommand(name, params...),
+		Output:  bytes.TrimSpace(output),
+		Err:     err,
+	}
+}
+
+// Error satisfies the error interface.
+func (err *StepError) Error() string {
+	if line := err.lastLine(); line != "" {
+		return fmt.Sprintf("%s failed: %v: %s", err.Step, err.Err, line)
+	}
+	return fmt.Sprintf("%s failed: %v", err.Step, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *StepError) Unwrap() error {
+	return err.Err
+}
+
+// Report returns a readable failure report, with the command and the trimmed
+// tail of its output.
+func (err *StepError) Report() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s failed: %v\n\n", err.Step, err.Err)
+	fmt.Fprintf(&sb, "command:\n  %s\n", strings.ReplaceAll(err.Command, "\n", "\n  "))
+	if len(err.Output) != 0 {
+		lines := strings.Split(string(err.Output), "\n")
+		if len(lines) > stepErrorReportLines {
+			fmt.Fprintf(&sb, "\noutput (last %d of %d lines):\n", stepErrorReportLines, len(lines))
+			lines = lines[len(lines)-stepErrorReportLines:]
+		} else {
+			sb.WriteString("\noutput:\n")
+		}
+		for _, line := range lines {
+			fmt.Fprintf(&sb, "  %s\n", strings.TrimRight(line, " \t\r"))
+		}
+	}
+	return sb.String()
+}
+
+// lastLine returns the last non-empty line of output.
+func (err *StepError) lastLine() string {
+	lines := strings.Split(string(err.Output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// sassErrorContextLines is the number of source lines preceding the error
+// line included in a SassError report.
+const sassErrorContextLines = 2
+
+// SassError is a sass compile error, with the location of the error in the
+// source.
+type SassError struct {
+	// File is the source file.
+	File string
+	// Line is the 1-based line of the error.
+	Line int
+	// Column is the 1-based column of the error.
+	Column int
+	// Message is the error message.
+	Message string
+	// Err is the underlying step error.
+	Err *StepError
+}
+
+// ansiRE matches ansi escape sequences.
+var ansiRE = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// newSassError creates a sass error from the json error written to stderr by
+// node-sass, returning err when the output cannot be parsed.
+func newSassError(err *StepError) error {
+	out := ansiRE.ReplaceAll(err.Output, nil)
+	i, j := bytes.IndexByte(out, '{'), bytes.LastIndexByte(out, '}')
+	if i == -1 || j < i {
+		return err
+	}
+	var v struct {
+		File    string `json:"file"`
+		Line    int    `json:"line"`
+		Column  int    `json:"column"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(out[i:j+1], &v) != nil || v.Message == "" {
+		return err
+	}
+	return &SassError{
+		File:    v.File,
+		Line:    v.Line,
+		Column:  v.Column,
+		Message: v.Message,
+		Err:     err,
+	}
+}
+
+// Error satisfies the error interface.
+func (err *SassError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", err.File, err.Line, err.Column, err.Message)
+}
+
+// Unwrap returns the underlying error.
+func (err *SassError) Unwrap() error {
+	return err.Err
+}
+
+// Report returns a readable failure report, with the error location and an
+// excerpt of the source marking the error.
+func (err *SassError) Report() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s failed: %s\n\n", err.Err.Step, err.Message)
+	fmt.Fprintf(&sb, "  --> %s:%d:%d\n", err.File, err.Line, err.Column)
+	buf, e := ioutil.ReadFile(err.File)
+	if e != nil || err.Line < 1 {
+		return sb.String()
+	}
+	lines := strings.Split(string(buf), "\n")
+	if err.Line > len(lines) {
+		return sb.String()
+	}
+	width := len(fmt.Sprintf("%d", err.Line))
+	start := err.Line - sassErrorContextLines
+	if start < 1 {
+		start = 1
+	}
+	fmt.Fprintf(&sb, "%*s |\n", width, "")
+	for i := start; i <= err.Line; i++ {
+		fmt.Fprintf(&sb, "%*d | %s\n", width, i, strings.TrimRight(lines[i-1], " \t\r"))
+	}
+	// align the caret with the error column, preserving tabs
+	var pad []rune
+	for i, r := range []rune(lines[err.Line-1]) {
+		if i >= err.Column-1 {
+			break
+		}
+		if r != '\t' {
+			r = ' '
+		}
+		pad = append(pad, r)
+	}
+	fmt.Fprintf(&sb, "%*s | %s^\n", width, "", string(pad))
+	return sb.String()
+}
+
+// SourceError is a build error located in a source file, such as a
+// javascript parse error or a template syntax error.
+type SourceError struct {
+	// File is the source file.
+	File string
+	// Line is the 1-based line of the error.
+	Line int
+	// Column is the 1-based column of the error, or 0 when not known.
+	Column int
+	// Message is the error message.
+	Message string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (err *SourceError) Error() string {
+	if err.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", err.File, err.Line, err.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", err.File, err.Line, err.Column, err.Message)
+}
+
+// Unwrap returns the underlying error.
+func (err *SourceError) Unwrap() error {
+	return err.Err
+}
+
+// uglifyErrorRE and uglifyMessageRE match the location and message of an
+// uglify-js parse error.
+var (
+	uglifyErrorRE   = regexp.MustCompile(`(?m)^Parse error at (.+):(\d+),(\d+)\s*$`)
+	uglifyMessageRE = regexp.MustCompile(`(?m)^ERROR: (.+)$`)
+)
+
+// newUglifyError creates a source error from an uglify-js parse error of the
+// concatenated scripts, mapping the location back to the script file. The
+// line counts of the scripts are passed in lines. Returns err when the output
+// cannot be parsed.
+func newUglifyError(err error, scripts []string, lines []int) error {
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) {
+		return err
+	}
+	out := ansiRE.ReplaceAll(stepErr.Output, nil)
+	m, msg := uglifyErrorRE.FindSubmatch(out), uglifyMessageRE.FindSubmatch(out)
+	if m == nil || msg == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(string(m[2]))
+	col, _ := strconv.Atoi(string(m[3]))
+	for i, n := range lines {
+		if line <= n {
+			return &SourceError{
+				File:    scripts[i],
+				Line:    line,
+				Column:  col + 1,
+				Message: strings.TrimSpace(string(msg[1])),
+				Err:     err,
+			}
+		}
+		line -= n
+	}
+	return err
+}
+
+// qtcErrorRE matches the location of a quicktemplate parse error.
+var qtcErrorRE = regexp.MustCompile(`(?i)(?:\.?\s+(?:found\s+)?at\s+)?file "([^"]+)", line (\d+), pos (\d+)`)
+
+// newQtcError creates a source error from a quicktemplate parse error,
+// returning err when the error has no location.
+func newQtcError(err error) error {
+	msg := err.Error()
+	v := qtcErrorRE.FindAllStringSubmatchIndex(msg, -1)
+	if v == nil {
+		return err
+	}
+	// use the innermost location, dropping it and the trailing context from
+	// the message
+	m := v[len(v)-1]
+	line, _ := strconv.Atoi(msg[m[4]:m[5]])
+	pos, _ := strconv.Atoi(msg[m[6]:m[7]])
+	return &SourceError{
+		File:    msg[m[2]:m[3]],
+		Line:    line,
+		Column:  pos + 1,
+		Message: msg[:m[0]],
+		Err:     err,
+	}
+}
+
+// newGoFormatError creates a source error from a go/format error of the
+// generated Go code buf for the file name, including the offending line of
+// the generated code in the message. Returns err when the error has no
+// location.
+func newGoFormatError(name string, buf []byte, err error) error {
+	var errs scanner.ErrorList
+	if !errors.As(err, &errs) || len(errs) == 0 {
+		return fmt.Errorf("could not format %s: %w", name, err)
+	}
+	e := errs[0]
+	msg := e.Msg
+	if lines := strings.Split(string(buf), "\n"); e.Pos.Line > 0 && e.Pos.Line <= len(lines) {
+		msg += fmt.Sprintf(" (generated code: %s)", strings.TrimSpace(lines[e.Pos.Line-1]))
+	}
+	return &SourceError{
+		File:    name,
+		Line:    e.Pos.Line,
+		Column:  e.Pos.Column,
+		Message: "invalid generated code: " + msg,
+		Err:     err,
+	}
+}
+
+// Problem is a build error in the format printed by -error-format.
+type Problem struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// problemFor returns the problem for err, with the location of the source
+// file when known. Files are relative to the working directory wd, when
+// contained within it.
+func problemFor(wd string, err error) Problem {
+	var sassErr *SassError
+	var srcErr *SourceError
+	switch {
+	case errors.As(err, &sassErr):
+		return Problem{wdPath(wd, sassErr.File), sassErr.Line, sassErr.Column, sassErr.Message}
+	case errors.As(err, &srcErr):
+		return Problem{wdPath(wd, srcErr.File), srcErr.Line, srcErr.Column, srcErr.Message}
+	}
+	return Problem{Message: err.Error()}
+}
+
+// printProblem writes the problem for err to w in the error format, either as
+// a file:line:col: message line (unix), or as a json object (json).
+func printProblem(w io.Writer, format, wd string, err error) error {
+	p := problemFor(wd, err)
+	if format == "json" {
+		return json.NewEncoder(w).Encode(p)
+	}
+	msg := strings.ReplaceAll(strings.TrimSpace(p.Message), "\n", " ")
+	var e error
+	switch {
+	case p.File == "":
+		_, e = fmt.Fprintf(w, "error: %s\n", msg)
+	case p.Column == 0:
+		_, e = fmt.Fprintf(w, "%s:%d: %s\n", p.File, p.Line, msg)
+	default:
+		_, e = fmt.Fprintf(w, "%s:%d:%d: %s\n", p.File, p.Line, p.Column, msg)
+	}
+	return e
+}
+
+// reportedError is an error already written in the error format.
+type reportedError struct {
+	err error
+}
+
+// Error satisfies the error interface.
+func (err *reportedError) Error() string {
+	return err.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (err *reportedError) Unwrap() error {
+	return err.err
+}
+
+// IsReported returns true when err was already written to stderr in the
+// format set by -error-format.
+func IsReported(err error) bool {
+	var e *reportedError
+	return errors.As(err, &e)
+}
+
+// tailBuffer is a writer that retains the last n bytes written.
+type tailBuffer struct {
+	buf []byte
+	n   int
+}
+
+// newTailBuffer creates a tail buffer retaining n bytes.
+func newTailBuffer(n int) *tailBuffer {
+	return &tailBuffer{n: n}
+}
+
+// Write satisfies the io.Writer interface.
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.n {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.n:]...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns the retained bytes.
+func (b *tailBuffer) Bytes() []byte {
+	return b.buf
+}
@@ -1,42 +1,196 @@
 package gen
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 
 	"github.com/kenshaw/assetgen/pack"
+	"github.com/kenshaw/assetgen/serve"
 )
 
 // setupFiles creates default files when they do not already exist.
 func setupFiles(flags *Flags) error {
 	app := filepath.Base(flags.Wd)
+	// package.json and package manager config is left to projects managing
+	// their own node packages, and not needed when node is not set up
+	packageJson := flags.PackageJson
+	if flags.NoInstall || flags.noNode {
+		packageJson = "none"
+	}
 	// build relative cache paths
+	var cacheDirs []string
 	var cacheList string
-	for i, d := range buildCacheDirs(flags.Wd, flags.Cache, flags.NodeModules, flags.NodeModulesBin) {
+	if packageJson != "none" {
+		cacheDirs = buildCacheDirs(flags.Wd, flags.Cache, flags.NodeModules, flags.NodeModulesBin)
+	}
+	for i, d := range cacheDirs {
 		if i != 0 {
 			cacheList += ","
 		}
 		cacheList = cacheList + fmt.Sprintf("\n    %q", d)
 	}
 	// create files if not present
-	for _, d := range []struct{ path, contents string }{
-		{filepath.Join(flags.Wd, "package.json"), tplf("package.json", app, app+" app", cacheList)},
-		{filepath.Join(flags.Assets, ".gitignore"), tplf("gitignore")},
-		{filepath.Join(flags.Assets, scriptName), tplf("assets.anko")},
-	} {
+	type file struct{ path, contents string }
+	var files []file
+	if packageJson != "none" {
+		files = append(files, file{filepath.Join(flags.Wd, "package.json"), tplf("package.json", app, app+" app", cacheList)})
+	}
+	if !flags.NoScaffold {
+		files = append(files,
+			file{filepath.Join(flags.Assets, ".gitignore"), tplf("gitignore")},
+			file{filepath.Join(flags.Assets, scriptName), tplf("assets.anko")},
+		)
+	}
+	for _, d := range files {
 		if err := writeCond(d.path, d.contents); err != nil {
 			return fmt.Errorf("unable to setup %s: %w", d.path, err)
 		}
 	}
+	if packageJson == "merge" {
+		if err := mergeCacheDirs(flags, cacheDirs); err != nil {
+			return fmt.Errorf("unable to merge cacheDirectories into package.json: %w", err)
+		}
+	}
+	if flags.NoInstall || flags.noNode {
+		return nil
+	}
+	if flags.yarnBerry {
+		if err := setupYarnrc(flags); err != nil {
+			return fmt.Errorf("unable to setup %s: %w", yarnrcYml, err)
+		}
+	}
+	if flags.NpmRegistry != "" {
+		if err := setupRegistry(flags); err != nil {
+			return fmt.Errorf("unable to setup registry: %w", err)
+		}
+	}
+	if flags.vendoring || flags.Vendored {
+		if err := setupOfflineMirror(flags); err != nil {
+			return fmt.Errorf("unable to setup offline mirror: %w", err)
+		}
+	}
+	return nil
+}
+
+var nodeLinkerRE = regexp.MustCompile(`(?m)^nodeLinker:\s*["']?([a-z-]+)["']?\s*$`)
+
+// setupYarnrc ensures the yarn berry config uses the node-modules linker, as
+// assetgen relies on a populated node_modules directory.
+func setupYarnrc(flags *Flags) error {
+	n := filepath.Join(flags.Wd, yarnrcYml)
+	buf, err := ioutil.ReadFile(n)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return writeCond(n, "nodeLinker: node-modules")
+	case err != nil:
+		return err
+	}
+	m := nodeLinkerRE.FindSubmatch(buf)
+	switch {
+	case m == nil:
+		return setConfigLine(n, nodeLinkerRE, "nodeLinker: node-modules")
+	case string(m[1]) != "node-modules":
+		return fmt.Errorf("nodeLinker must be node-modules, currently: %s", m[1])
+	}
+	return nil
+}
+
+var (
+	npmrcRegistryRE  = regexp.MustCompile(`(?m)^registry\s*=.*$`)
+	yarnrcRegistryRE = regexp.MustCompile(`(?m)^registry\s+.*$`)
+	berryRegistryRE  = regexp.MustCompile(`(?m)^npmRegistryServer:.*$`)
+)
+
+// setupRegistry points npm and yarn at the configured npm registry.
+func setupRegistry(flags *Flags) error {
+	yarnrc, yarnrcRE, yarnrcLine := ".yarnrc", yarnrcRegistryRE, fmt.Sprintf("registry %q", flags.NpmRegistry)
+	if flags.yarnBerry {
+		yarnrc, yarnrcRE, yarnrcLine = yarnrcYml, berryRegistryRE, fmt.Sprintf("npmRegistryServer: %q", flags.NpmRegistry)
+	}
+	for _, c := range []struct {
+		name string
+		re   *regexp.Regexp
+		line string
+	}{
+		{".npmrc", npmrcRegistryRE, "registry=" + flags.NpmRegistry},
+		{yarnrc, yarnrcRE, yarnrcLine},
+	} {
+		if err := setConfigLine(filepath.Join(flags.Wd, c.name), c.re, c.line); err != nil {
+			return fmt.Errorf("unable to write %s: %w", c.name, err)
+		}
+	}
 	return nil
 }
 
+var (
+	yarnrcMirrorRE      = regexp.MustCompile(`(?m)^yarn-offline-mirror\s+.*$`)
+	yarnrcMirrorPruneRE = regexp.MustCompile(`(?m)^yarn-offline-mirror-pruning\s+.*$`)
+	berryCacheFolderRE  = regexp.MustCompile(`(?m)^cacheFolder:.*$`)
+	berryGlobalCacheRE  = regexp.MustCompile(`(?m)^enableGlobalCache:.*$`)
+)
+
+// setupOfflineMirror configures yarn to store (and install from) package
+// tarballs in the vendor directory.
+func setupOfflineMirror(flags *Flags) error {
+	rel, err := filepath.Rel(flags.Wd, filepath.Join(flags.Vendor, "npm"))
+	if err != nil {
+		return err
+	}
+	rel = "./" + filepath.ToSlash(rel)
+	n, configs := filepath.Join(flags.Wd, ".yarnrc"), []struct {
+		re   *regexp.Regexp
+		line string
+	}{
+		{yarnrcMirrorRE, fmt.Sprintf("yarn-offline-mirror %q", rel)},
+		{yarnrcMirrorPruneRE, "yarn-offline-mirror-pruning true"},
+	}
+	if flags.yarnBerry {
+		n, configs = filepath.Join(flags.Wd, yarnrcYml), []struct {
+			re   *regexp.Regexp
+			line string
+		}{
+			{berryCacheFolderRE, fmt.Sprintf("cacheFolder: %q", rel)},
+			{berryGlobalCacheRE, "enableGlobalCache: false"},
+		}
+	}
+	for _, c := range configs {
+		if err := setConfigLine(n, c.re, c.line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setConfigLine replaces the first line in the config file path matching re
+// with line, appending line when there is no match.
+func setConfigLine(path string, re *regexp.Regexp, line string) error {
+	buf, err := ioutil.ReadFile(path)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return writeCond(path, line)
+	case err != nil:
+		return err
+	}
+	if loc := re.FindIndex(buf); loc != nil {
+		if string(buf[loc[0]:loc[1]]) == line {
+			return nil
+		}
+		buf = append(append(append([]byte{}, buf[:loc[0]]...), line...), buf[loc[1]:]...)
+	} else {
+		buf = append(bytes.TrimSuffix(buf, []byte("\n")), []byte("\n"+line+"\n")...)
+	}
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
 // buildCacheDirs builds a list of directory paths relative to the working
 // directory wd to cache.
 //
@@ -76,6 +230,39 @@ func buildCacheDirs(wd string, paths ...string) []string {
 	return d
 }
 
+// mergeCacheDirs adds the cache directories missing from the working
+// directory's package.json cacheDirectories.
+func mergeCacheDirs(flags *Flags, cacheDirs []string) error {
+	name := filepath.Join(flags.Wd, "package.json")
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var v struct {
+		CacheDirectories []string `json:"cacheDirectories"`
+	}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return errors.New("invalid package.json")
+	}
+	dirs, changed := v.CacheDirectories, false
+	have := make(map[string]bool)
+	for _, d := range dirs {
+		have[d] = true
+	}
+	for _, d := range cacheDirs {
+		if !have[d] {
+			dirs, changed = append(dirs, d), true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if buf, err = setJSONKey(buf, "cacheDirectories", dirs); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, buf, 0644)
+}
+
 // writeCond conditionally writes contents to path if path doesn't exist.
 //
 // Note: never writes a blank file: always adds \n if not present in contents.
@@ -92,13 +279,114 @@ func writeCond(path, contents string) error {
 	return nil
 }
 
-// writeAssetsGo generates the assets.go for the packed assets.
-func writeAssetsGo(flags *Flags, dist *pack.Pack) error {
+// embedDistPath returns the dist directory relative to the assets package
+// directory, as used by the embed directives.
+func embedDistPath(flags *Flags) (string, error) {
+	dir, dist := filepath.Dir(flags.AssetsGo), flags.Dist
+	for _, d := range flags.staged {
+		if d.staging == dist {
+			dist = d.dir
+		}
+	}
+	rel, err := filepath.Rel(dir, dist)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("dist %s must be within the assets package directory %s", dist, dir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// stagedDir is a dist directory built in a per-run staging directory, and
+// swapped into place after the build.
+type stagedDir struct {
+	// dir is the dist directory.
+	dir string
+	// staging is the staging directory.
+	staging string
+	// p is the path set to the staging directory during the build.
+	p *string
+}
+
+// stageDir creates a hidden per-run staging directory next to the directory
+// *p, and sets *p to it until the staged directories are swapped into place.
+func stageDir(flags *Flags, p *string) error {
+	dir := *p
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(dir), err)
+	}
+	staging, err := ioutil.TempDir(filepath.Dir(dir), "."+filepath.Base(dir)+"-run-")
+	if err != nil {
+		return fmt.Errorf("unable to create staging directory for %s: %w", dir, err)
+	}
+	flags.staged = append(flags.staged, &stagedDir{dir: dir, staging: staging, p: p})
+	*p = staging
+	return nil
+}
+
+// swapStaged renames the staged directories into place, replacing the
+// existing directories.
+func swapStaged(flags *Flags) error {
+	for len(flags.staged) != 0 {
+		d := flags.staged[0]
+		old := d.staging + "-old"
+		// the directory may have been moved by a concurrent build
+		if err := os.Rename(d.dir, old); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to move %s: %w", d.dir, err)
+		}
+		if err := os.Rename(d.staging, d.dir); err != nil {
+			return fmt.Errorf("unable to move %s to %s: %w", d.staging, d.dir, err)
+		}
+		*d.p, flags.staged = d.dir, flags.staged[1:]
+		if err := os.RemoveAll(old); err != nil {
+			warnf(flags, "could not remove %s: %v", old, err)
+		}
+	}
+	return nil
+}
+
+// removeStaged removes the staged directories not swapped into place.
+func removeStaged(flags *Flags) {
+	for _, d := range flags.staged {
+		if err := os.RemoveAll(d.staging); err != nil {
+			warnf(flags, "could not remove %s: %v", d.staging, err)
+		}
+		*d.p = d.dir
+	}
+	flags.staged = nil
+}
+
+// verifyGo builds the packages of the generated Go code with go build.
+func verifyGo(flags *Flags) error {
+	var pkgs []string
+	for _, dir := range uniqueStrings(flags.goDirs) {
+		if n := wdPath(flags.Wd, dir); !filepath.IsAbs(n) {
+			dir = "./" + n
+		}
+		pkgs = append(pkgs, dir)
+	}
+	if len(pkgs) == 0 {
+		return nil
+	}
+	if err := run(flags, "go", append([]string{"build"}, pkgs...)...); err != nil {
+		return fmt.Errorf("could not build generated code: %w", err)
+	}
+	return nil
+}
+
+// goIdentRE matches valid Go package names.
+var goIdentRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// writeAssetsGo generates the assets package file for the packed assets, in
+// debug mode when debug is set. The dist directory must be within the
+// package's directory, as embedded files are relative to it.
+func writeAssetsGo(flags *Flags, dist *pack.Pack, debug bool) error {
+	distshort, err := embedDistPath(flags)
+	if err != nil {
+		return err
+	}
 	// write manifest
 	if err := dist.WriteManifestInverted(); err != nil {
 		return fmt.Errorf("unable to write manifest: %w", err)
 	}
-	distshort := strings.TrimPrefix(flags.Dist, flags.Assets+"/")
 	// build asset list
 	manifest, err := dist.Manifest()
 	if err != nil {
@@ -109,14 +397,125 @@ func writeAssetsGo(flags *Flags, dist *pack.Pack) error {
 		assets = append(assets, k)
 	}
 	sort.Strings(assets)
-	for i := 0; i < len(assets); i++ {
-		assets[i] = `//go:embed ` + path.Join(distshort, assets[i])
+	var embeds []string
+	if flags.Embed != "assets" {
+		embeds = append(embeds, `//go:embed `+path.Join(distshort, flags.PackManifest))
+	}
+	if flags.Embed != "manifest" {
+		for _, n := range assets {
+			embeds = append(embeds, `//go:embed `+path.Join(distshort, n))
+		}
+	}
+	// generate the manifest when not embedded
+	gomanifest := "map[string]string"
+	if flags.Embed == "assets" {
+		gomanifest, err = goManifest(dist)
+		if err != nil {
+			return err
+		}
+	}
+	// build preload links and critical assets
+	var links string
+	if flags.PreloadLinks {
+		links = preloadLinks(flags, manifest)
+	}
+	critical, err := criticalAssets(flags, dist, manifest)
+	if err != nil {
+		return fmt.Errorf("unable to determine critical assets: %w", err)
 	}
-	assets = append([]string{`//go:embed ` + path.Join(distshort, flags.PackManifest)}, assets...)
 	// write assets.go
-	return ioutil.WriteFile(
-		filepath.Join(flags.Assets, assetsFile),
-		[]byte(tplf(assetsFile, strings.Join(assets, "\n"), distshort, flags.PackManifest)),
-		0644,
+	if err := os.MkdirAll(filepath.Dir(flags.AssetsGo), 0755); err != nil {
+		return err
+	}
+	return writeGo(
+		flags,
+		flags.AssetsGo,
+		[]byte(tplf(assetsFile, flags.AssetsPkg, buildVersion(), strings.Join(embeds, "\n"), distshort, flags.PackManifest, flags.UrlPrefix, flags.Env, debug, flags.Immutable, flags.Unhashed, flags.DistEnv, links, critical, gomanifest)),
 	)
 }
+
+// preloadLinks returns the PreloadLinks map entries for the packed css and js
+// in the manifest.
+func preloadLinks(flags *Flags, manifest map[string]string) string {
+	var names []string
+	for k := range manifest {
+		switch path.Ext(k) {
+		case ".css", ".js":
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var keys, values []string
+	for _, n := range names {
+		keys = append(keys, strings.TrimPrefix(n, "/"))
+		values = append(values, fmt.Sprintf("%q", serve.PreloadLink(flags.UrlPrefix+manifest[n])))
+	}
+	return goMapEntries(keys, values)
+}
+
+// criticalAssets returns the CriticalAssets map entries for the packed css,
+// listing the url of each css file and of the fonts it references.
+func criticalAssets(flags *Flags, dist *pack.Pack, manifest map[string]string) (string, error) {
+	var keys, values []string
+	for _, name := range dist.Files() {
+		n, ok := manifest[name]
+		if !ok || path.Ext(name) != ".css" {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(flags.Dist, filepath.FromSlash(name)))
+		if err != nil {
+			return "", err
+		}
+		urls := []string{fmt.Sprintf("%q", flags.UrlPrefix+n)}
+		seen := make(map[string]bool)
+		for _, m := range cssURLRE.FindAllSubmatch(buf, -1) {
+			u := string(m[1])
+			if i := strings.IndexAny(u, "?#"); i != -1 {
+				u = u[:i]
+			}
+			switch path.Ext(u) {
+			case ".woff2", ".woff", ".ttf", ".otf", ".eot":
+			default:
+				continue
+			}
+			if !strings.HasPrefix(u, flags.UrlPrefix) || seen[u] {
+				continue
+			}
+			seen[u] = true
+			urls = append(urls, fmt.Sprintf("%q", u))
+		}
+		keys = append(keys, strings.TrimPrefix(name, "/"))
+		values = append(values, "{"+strings.Join(urls, ", ")+"}")
+	}
+	return goMapEntries(keys, values), nil
+}
+
+// goManifest returns the go map literal of the dist's manifest file.
+func goManifest(dist *pack.Pack) (string, error) {
+	manifest, err := dist.ManifestInverted()
+	if err != nil {
+		return "", fmt.Errorf("unable to load manifest: %w", err)
+	}
+	var keys, values []string
+	for k := range manifest {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values = append(values, fmt.Sprintf("%q", manifest[k]))
+	}
+	return "= map[string]string{" + goMapEntries(keys, values) + "}", nil
+}
+
+// goMapEntries returns the go map literal entries for the keys and values.
+// The entries are aligned when the file is formatted by writeGo.
+func goMapEntries(keys, values []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	var entries []string
+	for i, k := range keys {
+		entries = append(entries, fmt.Sprintf("\t%q: %s,", k, values[i]))
+	}
+	return "\n" + strings.Join(entries, "\n") + "\n"
+}
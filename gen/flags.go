@@ -1,33 +1,135 @@
 package gen
 
 import (
+	"context"
 	"flag"
+	"net/http"
 	"runtime"
 	"time"
 )
 
 // Flags holds config flags for generating static assets.
 type Flags struct {
-	Wd             string
-	Verbose        bool
-	Node           string
-	NodeBin        string
-	Yarn           string
-	YarnBin        string
-	Cache          string
-	Build          string
-	NodeModules    string
-	NodeModulesBin string
-	YarnUpgrade    bool
-	YarnLatest     bool
-	Assets         string
-	Dist           string
-	Script         string
-	PackManifest   string
-	PackMask       string
-	Ttl            time.Duration
-	Workers        int
-	TFuncName      string
+	Wd                 string
+	Version            bool
+	Verbose            bool
+	LogLevel           string
+	LogFormat          string
+	ErrorFormat        string
+	Node               string
+	NodeBin            string
+	NodeVersion        string
+	NodeConstraint     string
+	NodeMirror         string
+	NodeMirrorKey      string
+	NodeLibc           string
+	NpmRegistry        string
+	Yarn               string
+	YarnBin            string
+	YarnVersion        string
+	YarnConstraint     string
+	Runtime            string
+	Bun                string
+	BunBin             string
+	BunVersion         string
+	BunConstraint      string
+	Cache              string
+	Build              string
+	IsolateBuild       bool
+	NodeModules        string
+	NodeModulesBin     string
+	YarnUpgrade        bool
+	YarnLatest         bool
+	NoInstall          bool
+	NoScaffold         bool
+	PackageJson        string
+	Frozen             bool
+	ForceDownload      bool
+	Vendor             string
+	Vendored           bool
+	DryRun             bool
+	Env                string
+	FontAwesomeVersion string
+	Assets             string
+	Roots              string
+	Symlinks           string
+	Dist               string
+	Script             string
+	AssetsGo           string
+	AssetsPkg          string
+	PackManifest       string
+	PackMask           string
+	ManifestExclude    string
+	UrlPrefix          string
+	EmailUrlPrefix     string
+	Immutable          bool
+	Unhashed           bool
+	Embed              string
+	DistEnv            string
+	PreloadLinks       bool
+	DepsDot            bool
+	VerifyGo           bool
+	Ttl                time.Duration
+	CaCert             string
+	HttpTimeout        time.Duration
+	Retries            int
+	Workers            int
+	IpcTransport       string
+	TFuncName          string
+	HtmlMinifier       string
+	AuditLevel         string
+
+	// Logger is the logger used for all output. When nil, a logger is
+	// created using LogLevel and LogFormat.
+	Logger Logger
+
+	// ctx is the build context.
+	ctx context.Context
+	// flagSet is the flag set bound to the flags by Build.
+	flagSet *flag.FlagSet
+	// path are the directories prepended to PATH for child processes.
+	path []string
+	// env are the additional environment variables for child processes.
+	env []string
+	// logLevel is the resolved log level.
+	logLevel LogLevel
+	// lock is the resolved tool versions.
+	lock *lock
+	// versions are the versions of the tools used by the build.
+	versions map[string]string
+	// client is the http client used for retrieving remote files.
+	client *http.Client
+	// vendoring is set when building for the vendor command.
+	vendoring bool
+	// auditing is set when resolving dependencies for the audit command.
+	auditing bool
+	// noNode is set when no step of the build needs node (see nodeNeeded).
+	noNode bool
+	// downloads are the files retrieved during the build.
+	downloads []download
+	// timings are the times taken by each build step.
+	timings []timing
+	// reports is the directory the build reports (sbom and dependency graph)
+	// are written to, which is the build directory shared across runs.
+	reports string
+	// deps are the source files contributing to each build output.
+	deps []bundleDeps
+	// goDirs are the package directories of the generated Go code.
+	goDirs []string
+	// staged are the dist directories built in per-run staging directories.
+	staged []*stagedDir
+	// roots are the additional assets roots.
+	roots []root
+	// root is the manifest prefix of the additional assets root being
+	// built.
+	root string
+	// yarnBerry is set when the resolved yarn is yarn berry (v2+).
+	yarnBerry bool
+	// bun is set when bun is the runtime and package manager.
+	bun bool
+	// workspace is the yarn/npm workspace root, when the working directory
+	// is a workspace package.
+	workspace string
 }
 
 // NewFlags creates a set of flags for use by assetgen.
@@ -40,22 +142,69 @@ func NewFlags(wd string) *Flags {
 // FlagSet returns a standard flag set for assetgen flags.
 func (f *Flags) FlagSet(name string, errorHandling flag.ErrorHandling) *flag.FlagSet {
 	fs := flag.NewFlagSet(name, errorHandling)
+	fs.BoolVar(&f.Version, "version", false, "print version and exit")
 	fs.BoolVar(&f.Verbose, "v", true, "toggle verbose")
+	fs.StringVar(&f.LogLevel, "log-level", "", "log level (quiet, warn, normal, verbose, debug) (default: verbose, or quiet with -v=false)")
+	fs.StringVar(&f.LogFormat, "log-format", "text", "log format (text, json)")
+	fs.StringVar(&f.ErrorFormat, "error-format", "text", "error output format (text, unix, json): unix prints file:line:col: message lines and json prints json objects, for editors and ci annotations")
 	fs.StringVar(&f.Node, "node", "", "path to node executable")
+	fs.StringVar(&f.NodeVersion, "node-version", "", "node version to retrieve (default: .nvmrc, .node-version, or latest lts)")
+	fs.StringVar(&f.NodeConstraint, "node-constraint", "", "semver constraint node must satisfy (default: package.json engines.node, or "+nodeConstraint+")")
+	fs.StringVar(&f.NodeMirror, "node-mirror", "", "node distribution mirror url")
+	fs.StringVar(&f.NodeMirrorKey, "node-mirror-key", "", "path to the public key (pgp keyring, minisign, or cosign) the node mirror's SHASUMS256.txt is signed with")
+	fs.StringVar(&f.NodeLibc, "node-libc", "auto", "node libc (auto, glibc, musl)")
+	fs.StringVar(&f.NpmRegistry, "npm-registry", "", "npm registry url")
 	fs.StringVar(&f.Yarn, "yarn", "", "path to yarn executable")
+	fs.StringVar(&f.YarnVersion, "yarn-version", "", "yarn version to retrieve (default: locked or latest)")
+	fs.StringVar(&f.YarnConstraint, "yarn-constraint", "", "semver constraint yarn must satisfy (default: package.json engines.yarn, or "+yarnConstraint+")")
+	fs.StringVar(&f.Runtime, "runtime", "node", "javascript runtime and package manager (node, bun): with bun, node package executables are run with bunx")
+	fs.StringVar(&f.Bun, "bun", "", "path to bun executable")
+	fs.StringVar(&f.BunVersion, "bun-version", "", "bun version to retrieve (default: locked or latest)")
+	fs.StringVar(&f.BunConstraint, "bun-constraint", "", "semver constraint bun must satisfy (default: package.json engines.bun, or "+bunConstraint+")")
+	fs.StringVar(&f.FontAwesomeVersion, "fontawesome-version", "", "fontawesome version to retrieve (default: locked or latest)")
 	fs.StringVar(&f.Cache, "cache", "", "cache directory")
 	fs.StringVar(&f.Build, "build", "", "build directory")
+	fs.BoolVar(&f.IsolateBuild, "isolate-build", false, "use a per-run directory in the build directory for intermediate outputs, removed after the build, and build the dist directories in per-run directories swapped into place after the build (for concurrent builds sharing a build or dist directory)")
 	fs.StringVar(&f.NodeModules, "node-modules", "", "node_modules path")
 	fs.StringVar(&f.NodeModulesBin, "node-modules-bin", "", "node_modules/.bin path")
 	fs.BoolVar(&f.YarnUpgrade, "upgrade", false, "toggle upgrade")
 	fs.BoolVar(&f.YarnLatest, "latest", false, "toggle upgrade latest")
+	fs.BoolVar(&f.NoInstall, "no-install", false, "use the node packages installed by the project, without running yarn or modifying package.json")
+	fs.BoolVar(&f.NoScaffold, "no-scaffold", false, "do not create the default assets .gitignore and script when not present")
+	fs.StringVar(&f.PackageJson, "package-json", "create", "package.json handling (create, merge, none): create when not present, additionally merge cacheDirectories into an existing package.json, or never create or modify")
+	fs.BoolVar(&f.Frozen, "frozen", false, "fail if resolved tool versions differ from "+lockFile)
+	fs.BoolVar(&f.ForceDownload, "force-download", false, "always retrieve node and yarn, instead of using versions on PATH")
+	fs.StringVar(&f.Vendor, "vendor", "", "vendor directory")
+	fs.BoolVar(&f.Vendored, "vendored", false, "only use vendored tools and node packages")
+	fs.StringVar(&f.Env, "env", productionEnv, "build environment (production, development)")
+	fs.BoolVar(&f.DryRun, "dry-run", false, "print the planned steps, commands, and packed files without executing anything")
 	fs.StringVar(&f.Assets, "assets", "", "assets path")
+	fs.StringVar(&f.Roots, "roots", "", "additional assets paths, as comma separated dir[=prefix] (dir may be a glob)")
+	fs.StringVar(&f.Symlinks, "symlinks", symlinksFollow, "symlink policy for asset walks (follow, skip, error)")
 	fs.StringVar(&f.Dist, "dist", "", "assets dist dir")
 	fs.StringVar(&f.Script, "script", "", "assets script")
+	fs.StringVar(&f.AssetsGo, "assets-go", "", "generated assets package file (default: assets.go in the assets dir); the dist dir defaults to the dist dir in its directory, and must be within it")
+	fs.StringVar(&f.AssetsPkg, "assets-pkg", assetsDir, "generated assets package name")
 	fs.StringVar(&f.PackManifest, "pack-manifest", "manifest.json", "pack manifest name")
+	fs.StringVar(&f.ManifestExclude, "manifest-exclude", "", "comma separated glob patterns of packed files written to the dist dir but omitted from the manifest (and generated assets package), matching the base name, or the name when containing a slash")
 	fs.StringVar(&f.PackMask, "pack-mask", "{{path[:6]}}.{{hash[:6]}}.{{ext}}", "pack file mask")
+	fs.StringVar(&f.UrlPrefix, "url-prefix", "/_/", "url prefix for packed assets")
+	fs.StringVar(&f.EmailUrlPrefix, "email-url-prefix", "", "absolute url prefix (ie, https://cdn.example.com/_/) for the packed assets referenced by email templates (default: the url prefix)")
+	fs.BoolVar(&f.Immutable, "immutable", false, "mark packed assets as immutable in the Cache-Control header of the generated handler")
+	fs.BoolVar(&f.Unhashed, "unhashed", false, "additionally serve the packed assets by their original, unhashed names from the generated handler, for stable urls (ie, for emails)")
+	fs.StringVar(&f.Embed, "embed", "all", "files embedded by the generated assets package (all, assets, manifest): assets generates the manifest as go code instead of embedding it, and manifest embeds only the manifest (for assets served from a cdn)")
+	fs.StringVar(&f.DistEnv, "dist-env", "", "environment variable naming a dist directory the generated assets package loads from at runtime instead of the embedded files")
+	fs.BoolVar(&f.PreloadLinks, "preload-links", false, "generate Link preload header values for the packed css and js")
+	fs.BoolVar(&f.VerifyGo, "verify-go", false, "build the packages of the generated Go code with go build after generating")
+	fs.BoolVar(&f.DepsDot, "deps-dot", false, "additionally write the dependency graph of the build outputs as a graphviz dot file")
 	fs.DurationVar(&f.Ttl, "ttl", 24*7*time.Hour, "ttl for retrieved dependencies (node, yarn)")
+	fs.StringVar(&f.CaCert, "ca-cert", "", "additional root CA certificates (PEM) for downloads")
+	fs.DurationVar(&f.HttpTimeout, "http-timeout", 5*time.Minute, "timeout for downloads")
+	fs.IntVar(&f.Retries, "retries", 3, "number of times to retry failed downloads")
+	fs.StringVar(&f.IpcTransport, "ipc-transport", "auto", "ipc callback transport (auto, unix, pipe, tcp)")
 	fs.IntVar(&f.Workers, "workers", runtime.NumCPU()+1, "number of workers")
 	fs.StringVar(&f.TFuncName, "trans", "T", "trans func name")
+	fs.StringVar(&f.HtmlMinifier, "html-minifier", "node", "template html minifier (node, go): with go, node is not set up for builds where no other step needs node")
+	fs.StringVar(&f.AuditLevel, "audit-level", "high", "minimum severity failing the audit command (info, low, moderate, high, critical)")
 	return fs
 }
@@ -5,10 +5,14 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kenshaw/assetgen/pack"
 	"github.com/yookoala/realpath"
@@ -17,6 +21,7 @@ import (
 const (
 	nodeConstraint    = ">=14.16.x"
 	yarnConstraint    = ">=1.22.x"
+	berryConstraint   = ">=2.0.0"
 	cacheDir          = ".cache"
 	buildDir          = "build"
 	nodeModulesDir    = "node_modules"
@@ -27,6 +32,13 @@ const (
 	distDir           = "dist"
 	scriptName        = "assets.anko"
 	assetsFile        = "assets.go"
+	templatesFile     = "templates.go"
+	registryFile      = "registry.go"
+	definesFile       = "defines.go"
+	cssModulesFile    = "cssmodules.go"
+	sbomFile          = "sbom.cdx.json"
+	depsFile          = "deps.json"
+	depsDotFile       = "deps.dot"
 	fontsDir          = "fonts"
 	imagesDir         = "images"
 	jsDir             = "js"
@@ -34,29 +46,241 @@ const (
 	cssDir            = "css"
 	sassJs            = "sass.js"
 	postcssJs         = "postcss.config.js"
+	noTailwindDir     = "notailwind"
+	subsetJs          = "subset.js"
+	brotliJs          = "brotli.js"
+	fontconvertJs     = "fontconvert.js"
 	assetgenScss      = "_assetgen.scss"
 	templatesDir      = "templates"
+	emailsDir         = "emails"
+	emailsJs          = "emails.js"
+	yarnrcYml         = ".yarnrc.yml"
+	lockFile          = "assetgen.lock"
+	vendorDir         = "assetgen-vendor"
 	nodeDistURL       = "https://nodejs.org/dist"
+	bunConstraint     = ">=1.0.0"
+	nodeMuslDistURL   = "https://unofficial-builds.nodejs.org/download/release"
 )
 
 // Run generates assets using the current working directory and default flags.
+//
+// When the first argument is not a flag, it names the command to run (see
+// commands), otherwise assets are built.
 func Run() error {
 	// load working directory
 	wd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("could not determine working directory: %w", err)
 	}
+	// determine command
+	name, args := "build", os.Args[1:]
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
 	// build flags
 	flags := NewFlags(wd)
-	fs := flags.FlagSet(filepath.Base(os.Args[0]), flag.ExitOnError)
-	if err := fs.Parse(os.Args[1:]); err != nil {
+	fs := flags.FlagSet(filepath.Base(os.Args[0])+" "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		printHelp(fs.Output(), name, fs)
+	}
+	if err := fs.Parse(args); err != nil {
 		return fmt.Errorf("could not parse args: %w", err)
 	}
-	return Assetgen(flags)
+	if flags.Version {
+		fmt.Fprintln(os.Stdout, "assetgen", buildVersion())
+		return nil
+	}
+	// stop on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	flags.ctx = ctx
+	switch flags.ErrorFormat {
+	case "", "text":
+		return cmd.run(flags, fs.Args())
+	case "unix", "json":
+	default:
+		return fmt.Errorf("invalid error format %q", flags.ErrorFormat)
+	}
+	if err := cmd.run(flags, fs.Args()); err != nil {
+		if e := printProblem(os.Stderr, flags.ErrorFormat, flags.Wd, err); e != nil {
+			return err
+		}
+		return &reportedError{err}
+	}
+	return nil
 }
 
 // Assetgen generates assets based on the passed flags.
 func Assetgen(flags *Flags) error {
+	start := time.Now()
+	if err := initFlags(flags); err != nil {
+		return err
+	}
+	// print the plan without executing anything
+	if flags.DryRun {
+		return dryRun(flags)
+	}
+	// check setup
+	needNode, err := nodeNeeded(flags)
+	if err != nil {
+		return err
+	}
+	flags.noNode = !needNode
+	if err := checkSetup(flags); err != nil {
+		return err
+	}
+	// use a per-run build directory
+	flags.reports = flags.Build
+	if flags.IsolateBuild {
+		dir, err := ioutil.TempDir(flags.Build, "run-")
+		if err != nil {
+			return fmt.Errorf("unable to create build directory: %w", err)
+		}
+		flags.Build = dir
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				warnf(flags, "could not remove %s: %v", dir, err)
+			}
+			flags.Build = flags.reports
+		}()
+		// build the dist in a per-run directory
+		if err := stageDir(flags, &flags.Dist); err != nil {
+			return err
+		}
+		defer removeStaged(flags)
+	}
+	// set PATH and NODE_PATH for child processes
+	dirs := nodeModulesDirs(flags)
+	for _, dir := range dirs[:len(dirs)-1] {
+		flags.path = append(flags.path, filepath.Join(dir, nodeModulesBinDir))
+	}
+	flags.path = append(flags.path, flags.NodeModulesBin)
+	flags.env = append(flags.env, "NODE_PATH="+strings.Join(dirs, string(os.PathListSeparator)))
+	// load script
+	s, err := LoadScript(flags)
+	if err != nil {
+		return fmt.Errorf("unable to load script %s: %w", flags.Script, err)
+	}
+	// load additional roots
+	roots, err := loadRoots(flags)
+	if err != nil {
+		return err
+	}
+	// setup dependencies, or check they are installed when node packages
+	// are managed by the project
+	switch {
+	case flags.noNode:
+	case flags.NoInstall:
+		for _, z := range append([]*Script{s}, roots...) {
+			if err := z.checkDeps(); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := timed(flags, "add deps", s.ConfigDeps); err != nil {
+			return fmt.Errorf("unable to configure dependencies: %w", err)
+		}
+		for _, r := range roots {
+			if err := timed(flags, "add deps ("+r.flags.root+")", r.ConfigDeps); err != nil {
+				return fmt.Errorf("unable to configure dependencies for %s: %w", r.flags.Assets, err)
+			}
+		}
+	}
+	// fix links in node/.bin directory (yarn berry, bun, and workspaces
+	// manage their own links)
+	if !flags.noNode && modulesParams(flags) != nil {
+		if err := fixNodeModulesBinLinks(flags); err != nil {
+			return fmt.Errorf("unable to fix bin links in %s: %w", flags.NodeModulesBin, err)
+		}
+	}
+	// stop once dependencies are resolved when auditing
+	if flags.auditing {
+		return nil
+	}
+	// recreate dist
+	if err := os.RemoveAll(s.flags.Dist); err != nil {
+		return fmt.Errorf("unable to remove %s: %w", s.flags.Dist, err)
+	}
+	if err := os.MkdirAll(s.flags.Dist, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %w", s.flags.Dist, err)
+	}
+	dist, err := pack.NewBase(s.flags.Dist, packOptions(s.flags)...)
+	if err != nil {
+		return fmt.Errorf("unable to create dist: %w", err)
+	}
+	if err := s.createDists(); err != nil {
+		return err
+	}
+	// build additional roots
+	for _, r := range roots {
+		if err := buildRoot(flags, dist, r); err != nil {
+			return fmt.Errorf("could not build root %s: %w", r.flags.Assets, err)
+		}
+	}
+	// run script
+	if err := s.run(dist); err != nil {
+		return err
+	}
+	// write assets.go
+	if err := writeAssetsGo(flags, dist, s.prof.debug); err != nil {
+		return fmt.Errorf("could not write %s: %w", assetsFile, err)
+	}
+	if err := s.writeDistsGo(flags); err != nil {
+		return err
+	}
+	// swap the staged dists into place
+	if err := swapStaged(flags); err != nil {
+		return err
+	}
+	// build the generated packages
+	if flags.VerifyGo {
+		if err := verifyGo(flags); err != nil {
+			return err
+		}
+	}
+	// write sbom
+	if err := writeSbom(flags); err != nil {
+		return fmt.Errorf("could not write %s: %w", sbomFile, err)
+	}
+	// write dependency graph
+	if err := writeDeps(flags); err != nil {
+		return fmt.Errorf("could not write %s: %w", depsFile, err)
+	}
+	// write lock
+	if err := flags.lock.write(flags); err != nil {
+		return fmt.Errorf("could not write %s: %w", lockFile, err)
+	}
+	// summarize downloads
+	for _, d := range flags.downloads {
+		infof(flags, "DOWNLOADED: %s (%s) -> %s", d.urlstr, formatBytes(d.size), d.path)
+	}
+	// summarize packed asset sizes
+	if flags.logLevel >= LogVerbose {
+		if err := logSizes(flags, dist); err != nil {
+			return fmt.Errorf("could not determine packed asset sizes: %w", err)
+		}
+		for _, d := range s.dists {
+			if err := logSizes(distFlags(flags, d), d.pack); err != nil {
+				return fmt.Errorf("could not determine packed asset sizes for dist %s: %w", d.name, err)
+			}
+		}
+	}
+	// summarize timings
+	for _, t := range flags.timings {
+		infof(flags, "TIMING: %s %v", t.name, t.d.Round(time.Millisecond))
+	}
+	infof(flags, "TIMING: total %v", time.Since(start).Round(time.Millisecond))
+	infof(flags, "VERSION: assetgen %s", buildVersion())
+	return nil
+}
+
+// initFlags checks the flags, resolves default paths and settings, and loads
+// the lock and additional roots.
+func initFlags(flags *Flags) error {
 	// check working directory is usable
 	wdfi, err := os.Stat(flags.Wd)
 	if err != nil || !wdfi.IsDir() {
@@ -75,6 +299,63 @@ func Assetgen(flags *Flags) error {
 	if !isValidIdentifier(flags.TFuncName) {
 		return errors.New("invalid trans func name")
 	}
+	switch flags.HtmlMinifier {
+	case "":
+		flags.HtmlMinifier = "node"
+	case "node", "go":
+	default:
+		return fmt.Errorf("invalid html minifier %q", flags.HtmlMinifier)
+	}
+	switch flags.Embed {
+	case "":
+		flags.Embed = "all"
+	case "all", "assets", "manifest":
+	default:
+		return fmt.Errorf("invalid embed %q", flags.Embed)
+	}
+	switch flags.PackageJson {
+	case "":
+		flags.PackageJson = "create"
+	case "create", "merge", "none":
+	default:
+		return fmt.Errorf("invalid package.json handling %q", flags.PackageJson)
+	}
+	switch flags.Env {
+	case "":
+		flags.Env = productionEnv
+	case productionEnv, developmentEnv:
+	default:
+		return fmt.Errorf("invalid env %q", flags.Env)
+	}
+	switch flags.Symlinks {
+	case "":
+		flags.Symlinks = symlinksFollow
+	case symlinksFollow, symlinksSkip, symlinksError:
+	default:
+		return fmt.Errorf("invalid symlink policy %q", flags.Symlinks)
+	}
+	if flags.ctx == nil {
+		flags.ctx = context.Background()
+	}
+	// disable yarn berry network access for vendored builds
+	if flags.Vendored {
+		flags.env = append(flags.env, "YARN_ENABLE_NETWORK=0")
+	}
+	// create logger
+	flags.logLevel = LogQuiet
+	switch {
+	case flags.LogLevel != "":
+		if flags.logLevel, err = ParseLogLevel(flags.LogLevel); err != nil {
+			return err
+		}
+	case flags.Verbose:
+		flags.logLevel = LogVerbose
+	}
+	if flags.Logger == nil {
+		if flags.Logger, err = NewLogger(os.Stderr, flags.logLevel, flags.LogFormat); err != nil {
+			return err
+		}
+	}
 	// ensure paths are set
 	if flags.Cache == "" {
 		if dir := os.Getenv("ASSETGEN_CACHE"); dir != "" {
@@ -83,91 +364,92 @@ func Assetgen(flags *Flags) error {
 			flags.Cache = filepath.Join(flags.Wd, cacheDir)
 		}
 	}
-	if flags.Build == "" {
-		flags.Build = filepath.Join(flags.Wd, buildDir)
+	if flags.Runtime == "" {
+		flags.Runtime = "node"
 	}
-	if flags.NodeModules == "" {
-		flags.NodeModules = filepath.Join(flags.Cache, nodeModulesDir)
+	if flags.NodeMirror == "" {
+		if urlstr := os.Getenv("ASSETGEN_NODE_MIRROR"); urlstr != "" {
+			flags.NodeMirror = urlstr
+		} else {
+			flags.NodeMirror = nodeDistURL
+		}
 	}
-	if flags.NodeModulesBin == "" {
-		flags.NodeModulesBin = filepath.Join(flags.NodeModules, nodeModulesBinDir)
+	flags.NodeMirror = strings.TrimSuffix(flags.NodeMirror, "/")
+	if flags.NodeMirrorKey == "" {
+		flags.NodeMirrorKey = os.Getenv("ASSETGEN_NODE_MIRROR_KEY")
+	}
+	if flags.NpmRegistry == "" {
+		flags.NpmRegistry = os.Getenv("ASSETGEN_NPM_REGISTRY")
+	}
+	if flags.CaCert == "" {
+		flags.CaCert = os.Getenv("ASSETGEN_CA_CERT")
+	}
+	if flags.Build == "" {
+		flags.Build = filepath.Join(flags.Wd, buildDir)
 	}
 	if flags.Assets == "" {
 		flags.Assets = filepath.Join(flags.Wd, assetsDir)
 	}
+	if flags.AssetsGo == "" {
+		flags.AssetsGo = filepath.Join(flags.Assets, assetsFile)
+	}
 	if flags.Dist == "" {
-		flags.Dist = filepath.Join(flags.Assets, distDir)
+		flags.Dist = filepath.Join(filepath.Dir(flags.AssetsGo), distDir)
 	}
 	if flags.Script == "" {
 		flags.Script = filepath.Join(flags.Assets, scriptName)
 	}
-	// set working directory
-	if err := os.Chdir(flags.Wd); err != nil {
-		return fmt.Errorf("could not change to dir: %w", err)
+	switch {
+	case flags.AssetsPkg == "":
+		flags.AssetsPkg = assetsDir
+	case !goIdentRE.MatchString(flags.AssetsPkg):
+		return fmt.Errorf("invalid assets package name %q", flags.AssetsPkg)
 	}
-	// check setup
-	if err := checkSetup(flags); err != nil {
-		return err
+	if flags.Vendor == "" {
+		flags.Vendor = filepath.Join(flags.Wd, vendorDir)
 	}
-	// set PATH
-	if err := os.Setenv("PATH", strings.Join([]string{
-		filepath.Dir(flags.NodeBin),
-		flags.NodeModulesBin,
-		os.Getenv("PATH"),
-	}, ":")); err != nil {
-		return fmt.Errorf("could not set PATH: %w", err)
+	if flags.UrlPrefix == "" {
+		flags.UrlPrefix = "/"
 	}
-	// set NODE_PATH
-	if err := os.Setenv("NODE_PATH", flags.NodeModules); err != nil {
-		return fmt.Errorf("could not set NODE_PATH: %w", err)
+	if !strings.HasSuffix(flags.UrlPrefix, "/") {
+		flags.UrlPrefix += "/"
 	}
-	// load script
-	s, err := LoadScript(flags)
-	if err != nil {
-		return fmt.Errorf("unable to load script %s: %w", flags.Script, err)
+	if flags.EmailUrlPrefix == "" {
+		flags.EmailUrlPrefix = flags.UrlPrefix
 	}
-	// setup dependencies
-	if err := s.ConfigDeps(); err != nil {
-		return fmt.Errorf("unable to configure dependencies: %w", err)
+	if !strings.HasSuffix(flags.EmailUrlPrefix, "/") {
+		flags.EmailUrlPrefix += "/"
 	}
-	// fix links in node/.bin directory
-	if err := fixNodeModulesBinLinks(flags); err != nil {
-		return fmt.Errorf("unable to fix bin links in %s: %w", flags.NodeModulesBin, err)
+	if flags.Vendored && flags.YarnUpgrade {
+		return errors.New("cannot upgrade a vendored build")
 	}
-	// recreate dist
-	if err := os.RemoveAll(s.flags.Dist); err != nil {
-		return fmt.Errorf("unable to remove %s: %w", s.flags.Dist, err)
-	}
-	if err := os.MkdirAll(s.flags.Dist, 0755); err != nil {
-		return fmt.Errorf("unable to create %s: %w", s.flags.Dist, err)
+	// make paths relative to the working directory
+	for _, p := range []*string{
+		&flags.Cache, &flags.Build, &flags.Assets, &flags.Dist, &flags.Script, &flags.AssetsGo, &flags.Vendor,
+		&flags.NodeModules, &flags.NodeModulesBin, &flags.Node, &flags.Yarn, &flags.Bun, &flags.CaCert,
+	} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(flags.Wd, *p)
+		}
 	}
-	dist, err := pack.NewBase(s.flags.Dist, pack.WithManifest(s.flags.PackManifest))
-	if err != nil {
-		return fmt.Errorf("unable to create dist: %w", err)
+	if _, err := embedDistPath(flags); err != nil {
+		return err
 	}
-	ctxt, cancel := context.WithCancel(context.Background())
-	// start callback server
-	sock, err := s.startCallbackServer(ctxt, dist)
-	if err != nil {
-		return fmt.Errorf("could not start callback server: %w", err)
+	// resolve tool constraints
+	if err := resolveConstraints(flags); err != nil {
+		return err
 	}
-	defer func() {
-		cancel()
-		if err := os.RemoveAll(filepath.Dir(sock)); err != nil {
-			warnf(flags, "could not remove %s: %w", sock, err)
-		}
-	}()
-	// set ASSETGEN_SOCK
-	if err := os.Setenv("ASSETGEN_SOCK", sock); err != nil {
-		return fmt.Errorf("could not set ASSETGEN_SOCK: %w", err)
+	// create http client
+	if flags.client, err = newHttpClient(flags); err != nil {
+		return fmt.Errorf("unable to create http client: %w", err)
 	}
-	// run script
-	if err := s.Execute(dist); err != nil {
-		return fmt.Errorf("could not run script: %w", err)
+	// load lock
+	if flags.lock, err = loadLock(flags); err != nil {
+		return fmt.Errorf("unable to load %s: %w", lockFile, err)
 	}
-	// write assets.go
-	if err := writeAssetsGo(flags, dist); err != nil {
-		return fmt.Errorf("could not write %s: %w", assetsFile, err)
+	// parse additional roots
+	if flags.roots, err = parseRoots(flags); err != nil {
+		return err
 	}
 	return nil
 }
@@ -179,23 +461,91 @@ func checkSetup(flags *Flags) error {
 	if err := checkDirs(flags, &flags.Cache, &flags.Build, &flags.Assets, &flags.Dist); err != nil {
 		return fmt.Errorf("unable to fix .cache build assets: %w", err)
 	}
-	// check node + yarn
-	if err := checkNode(flags); err != nil {
-		return err
+	// determine workspace root
+	var err error
+	if flags.workspace, err = findWorkspace(flags.Wd); err != nil {
+		return fmt.Errorf("unable to determine workspace: %w", err)
 	}
-	if err := os.Setenv("PATH", filepath.Dir(flags.NodeBin)+":"+os.Getenv("PATH")); err != nil {
-		return err
+	// ensure assets and dist directories exists
+	for _, d := range []struct{ n, v string }{
+		{"assets", flags.Assets},
+	} {
+		_, err := filepath.Rel(flags.Wd, d.v)
+		if err != nil || !isParentDir(flags.Wd, d.v) {
+			return fmt.Errorf("%s path must be subdirectory of working directory", d.n)
+		}
 	}
-	if err := checkYarn(flags); err != nil {
-		return err
+	for _, d := range []struct{ n, v string }{
+		{"dist", flags.Dist},
+	} {
+		_, err := filepath.Rel(flags.Assets, d.v)
+		if err != nil || !isParentDir(flags.Assets, d.v) {
+			return fmt.Errorf("%s path must be subdirectory of assets directory", d.n)
+		}
+	}
+	// check runtime
+	flags.versions = make(map[string]string)
+	if flags.noNode {
+		if err := resolvePackageManager(flags); err != nil {
+			return err
+		}
+		if err := setupFiles(flags); err != nil {
+			return fmt.Errorf("unable to setup files: %w", err)
+		}
+		return nil
+	}
+	switch flags.Runtime {
+	case "node":
+		// check node + yarn
+		if err := checkNode(flags); err != nil {
+			return err
+		}
+		flags.path = append(flags.path, filepath.Dir(flags.NodeBin))
+		// yarn is not needed when node packages are managed by the project
+		if flags.NoInstall && !flags.auditing {
+			break
+		}
+		if err := checkYarn(flags); err != nil {
+			return err
+		}
+	case "bun":
+		if err := checkBun(flags); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid runtime %q", flags.Runtime)
+	}
+	// yarn berry, bun, and workspaces cannot relocate node_modules, so it
+	// always lives in the working directory (or the workspace root, where
+	// packages are hoisted)
+	nodeModules := filepath.Join(packageRoot(flags), nodeModulesDir)
+	switch {
+	case modulesParams(flags) == nil && flags.NodeModules == "":
+		flags.NodeModules = nodeModules
+	case modulesParams(flags) == nil && flags.NodeModules != nodeModules:
+		return fmt.Errorf("%s does not support a node_modules path other than %s", flags.YarnBin, nodeModules)
+	case flags.NodeModules == "":
+		flags.NodeModules = filepath.Join(flags.Cache, nodeModulesDir)
+	}
+	if flags.NodeModulesBin == "" {
+		flags.NodeModulesBin = filepath.Join(flags.NodeModules, nodeModulesBinDir)
 	}
 	// determine if node_modules and yarn.lock is present
 	var nodeModulesPresent, yarnLockPresent bool
 	if _, err := os.Stat(flags.NodeModules); err == nil {
 		nodeModulesPresent = true
 	}
-	if _, err := os.Stat(filepath.Join(flags.Wd, "yarn.lock")); err == nil {
-		yarnLockPresent = true
+	lockFiles := []string{"yarn.lock"}
+	if flags.bun {
+		lockFiles = []string{"bun.lockb", "bun.lock"}
+	}
+	for _, n := range lockFiles {
+		if _, err := os.Stat(filepath.Join(packageRoot(flags), n)); err == nil {
+			yarnLockPresent = true
+		}
+	}
+	if flags.NoInstall && !nodeModulesPresent {
+		return fmt.Errorf("%s does not exist: install node packages before using -no-install", flags.NodeModules)
 	}
 	// check dirs node_modules + node_modules/.bin
 	if err := checkDirs(flags, &flags.NodeModules, &flags.NodeModulesBin); err != nil {
@@ -206,45 +556,86 @@ func checkSetup(flags *Flags) error {
 		return fmt.Errorf("unable to setup files: %w", err)
 	}
 	// do pure lockfile install
-	if !nodeModulesPresent && yarnLockPresent {
-		if err := run(flags, flags.YarnBin, "install", "--pure-lockfile", "--no-bin-links", "--modules-folder="+flags.NodeModules); err != nil {
-			return errors.New("unable to install locked deps: please fix manually")
+	if !flags.NoInstall && !nodeModulesPresent && yarnLockPresent {
+		params := append([]string{"install", "--pure-lockfile"}, modulesParams(flags)...)
+		switch {
+		case flags.bun:
+			params = []string{"install", "--frozen-lockfile"}
+		case flags.yarnBerry:
+			params = []string{"install", "--immutable"}
 		}
-	}
-	// ensure assets and dist directories exists
-	for _, d := range []struct{ n, v string }{
-		{"assets", flags.Assets},
-	} {
-		_, err := filepath.Rel(flags.Wd, d.v)
-		if err != nil || !isParentDir(flags.Wd, d.v) {
-			return fmt.Errorf("%s path must be subdirectory of working directory", d.n)
+		if err := timed(flags, "install locked deps", func() error {
+			return run(flags, flags.YarnBin, yarnParams(flags, params...)...)
+		}); err != nil {
+			return fmt.Errorf("unable to install locked deps: please fix manually: %w", err)
 		}
 	}
-	for _, d := range []struct{ n, v string }{
-		{"dist", flags.Dist},
-	} {
-		_, err := filepath.Rel(flags.Assets, d.v)
-		if err != nil || !isParentDir(flags.Assets, d.v) {
-			return fmt.Errorf("%s path must be subdirectory of assets directory", d.n)
-		}
+	if flags.NoInstall {
+		return nil
 	}
 	// run yarn install
-	if err := runSilent(flags, flags.YarnBin, "install", "--no-bin-links", "--modules-folder="+flags.NodeModules); err != nil {
-		return errors.New("yarn is out of sync: please fix manually")
+	params := append([]string{"install"}, modulesParams(flags)...)
+	// refetch all packages when vendoring, so the offline mirror is complete
+	if flags.vendoring && !flags.yarnBerry {
+		params = append(params, "--force")
+	}
+	if err := timed(flags, "install deps", func() error {
+		return runSilent(flags, flags.YarnBin, yarnParams(flags, params...)...)
+	}); err != nil {
+		return fmt.Errorf("yarn is out of sync: please fix manually: %w", err)
 	}
 	// run yarn upgrade
 	if flags.YarnUpgrade {
-		params := []string{"upgrade", "--no-bin-links", "--modules-folder=" + flags.NodeModules}
-		if flags.YarnLatest {
+		params := append([]string{"upgrade"}, modulesParams(flags)...)
+		switch {
+		case flags.bun && flags.YarnLatest:
+			params = []string{"update", "--latest"}
+		case flags.bun:
+			params = []string{"update"}
+		case flags.yarnBerry && flags.YarnLatest:
+			params = []string{"up", "*"}
+		case flags.yarnBerry:
+			params = []string{"up", "--recursive", "*"}
+		case flags.YarnLatest:
 			params = append(params, "--latest")
 		}
-		if err := runSilent(flags, flags.YarnBin, params...); err != nil {
+		if err := timed(flags, "upgrade deps", func() error {
+			return runSilent(flags, flags.YarnBin, params...)
+		}); err != nil {
 			return fmt.Errorf("unable to run yarn upgrade: %w", err)
 		}
 	}
 	return nil
 }
 
+// nodeNeeded determines if a step of the build needs node. Node is always
+// needed by the node html minifier, otherwise the scripts are loaded (as with
+// a dry run) to determine if any script has node dependencies or plugins.
+func nodeNeeded(flags *Flags) (bool, error) {
+	if flags.HtmlMinifier != "go" || flags.auditing || flags.vendoring || !fileExists(flags.Script) {
+		return true, nil
+	}
+	f := *flags
+	f.Logger, _ = NewLogger(ioutil.Discard, LogQuiet, "")
+	if err := resolvePackageManager(&f); err != nil {
+		return false, err
+	}
+	s, err := LoadScript(&f)
+	if err != nil {
+		return false, fmt.Errorf("unable to load script %s: %w", f.Script, err)
+	}
+	roots, err := loadRoots(&f)
+	if err != nil {
+		return false, err
+	}
+	for _, z := range append([]*Script{s}, roots...) {
+		if z.nodeNeeded() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // checkDirs creates required directories and ensures directories are
 // subdirectories of the working directory.
 func checkDirs(flags *Flags, dirs ...*string) error {
@@ -267,14 +658,21 @@ func checkDirs(flags *Flags, dirs ...*string) error {
 
 // checkNode checks that node is available and the correct version.
 //
-// If node is not available, then the latest version is downloaded to the cache
-// dir and used instead.
+// When node is not set, a node on PATH satisfying the constraint is used,
+// otherwise the requested version is downloaded to the cache dir and used
+// instead.
 func checkNode(flags *Flags) error {
 	if flags.Node == "" {
-		var err error
-		if flags.Node, flags.NodeBin, err = installNode(flags); err != nil {
+		want, err := requestedNodeVersion(flags)
+		if err != nil {
 			return err
 		}
+		var ok bool
+		if flags.Node, flags.NodeBin, ok = systemTool(flags, "node", flags.NodeConstraint, systemWant(flags, "node", want)); !ok {
+			if flags.Node, flags.NodeBin, err = installNode(flags); err != nil {
+				return err
+			}
+		}
 	}
 	node, err := realpath.Realpath(flags.Node)
 	if err != nil {
@@ -293,21 +691,31 @@ func checkNode(flags *Flags) error {
 	if err != nil {
 		return fmt.Errorf("unable to determine node version: %w", err)
 	}
-	if !compareSemver(nodeVer, nodeConstraint) {
-		return fmt.Errorf("%s version must be %s, currently: %s", flags.NodeBin, nodeConstraint, nodeVer)
+	if !compareSemver(nodeVer, flags.NodeConstraint) {
+		return fmt.Errorf("%s version must be %s, currently: %s: use -node-version or -node to select a matching node, or change -node-constraint", flags.NodeBin, flags.NodeConstraint, nodeVer)
 	}
+	flags.versions["node"] = strings.TrimPrefix(nodeVer, "v")
 	return nil
 }
 
 // checkYarn checks that yarn is available and the correct version.
 //
-// If yarn is not available, then the latest version is downloaded to the cache
-// dir and used instead.
+// When yarn is not set, a yarn on PATH satisfying the constraint is used,
+// otherwise the latest version is downloaded to the cache dir and used
+// instead.
 func checkYarn(flags *Flags) error {
 	if flags.Yarn == "" {
-		var err error
-		if flags.Yarn, flags.YarnBin, err = installYarn(flags); err != nil {
-			return err
+		// use corepack when the project declares a yarn berry package manager
+		install, constraint := installYarn, flags.YarnConstraint
+		if isBerryPackageManager(flags) {
+			install, constraint = installYarnCorepack, berryConstraint
+		}
+		var ok bool
+		if flags.Yarn, flags.YarnBin, ok = systemTool(flags, "yarn", constraint, systemWant(flags, "yarn", flags.YarnVersion)); !ok {
+			var err error
+			if flags.Yarn, flags.YarnBin, err = install(flags); err != nil {
+				return err
+			}
 		}
 	}
 	yarn, err := realpath.Realpath(flags.Yarn)
@@ -327,8 +735,61 @@ func checkYarn(flags *Flags) error {
 	if err != nil {
 		return fmt.Errorf("unable to determine yarn version: %w", err)
 	}
-	if !compareSemver(strings.TrimPrefix(yarnVer, "v"), yarnConstraint) {
-		return fmt.Errorf("%s version must be %s, currently: %s", flags.YarnBin, yarnConstraint, yarnVer)
+	yarnVer = strings.TrimPrefix(yarnVer, "v")
+	if !compareSemver(yarnVer, flags.YarnConstraint) {
+		return fmt.Errorf("%s version must be %s, currently: %s: use -yarn-version or -yarn to select a matching yarn (or declare a packageManager in package.json), or change -yarn-constraint", flags.YarnBin, flags.YarnConstraint, yarnVer)
+	}
+	flags.yarnBerry = compareSemver(yarnVer, berryConstraint)
+	flags.versions["yarn"] = yarnVer
+	return nil
+}
+
+// checkBun checks that bun is available and the correct version, and sets it
+// as the package manager and node runtime.
+//
+// When bun is not set, a bun on PATH satisfying the constraint is used,
+// otherwise the latest version is downloaded to the cache dir and used
+// instead.
+func checkBun(flags *Flags) error {
+	switch {
+	case flags.vendoring || flags.Vendored:
+		return errors.New("vendoring is not supported with bun")
+	case flags.Bun == "":
+		var ok bool
+		if flags.Bun, flags.BunBin, ok = systemTool(flags, "bun", flags.BunConstraint, systemWant(flags, "bun", flags.BunVersion)); !ok {
+			var err error
+			if flags.Bun, flags.BunBin, err = installBun(flags); err != nil {
+				return err
+			}
+		}
+	}
+	bun, err := realpath.Realpath(flags.Bun)
+	if err != nil {
+		return err
+	}
+	flags.Bun = bun
+	if flags.BunBin == "" {
+		if runtime.GOOS == "windows" {
+			flags.BunBin = filepath.Join(flags.Bun, "bin", "bun.exe")
+		} else {
+			flags.BunBin = filepath.Join(flags.Bun, "bin", "bun")
+		}
+	}
+	// check bun version
+	bunVer, err := runCombined(flags, flags.BunBin, "--version")
+	if err != nil {
+		return fmt.Errorf("unable to determine bun version: %w", err)
+	}
+	if !compareSemver(strings.TrimPrefix(bunVer, "v"), flags.BunConstraint) {
+		return fmt.Errorf("%s version must be %s, currently: %s: use -bun-version or -bun to select a matching bun, or change -bun-constraint", flags.BunBin, flags.BunConstraint, bunVer)
+	}
+	flags.versions["bun"] = strings.TrimPrefix(bunVer, "v")
+	// bun acts as node when invoked as node, so provide a node for scripts
+	// and tools
+	if flags.NodeBin, err = bunNodeShim(flags); err != nil {
+		return fmt.Errorf("unable to create node shim for bun: %w", err)
 	}
+	flags.Node, flags.Yarn, flags.YarnBin, flags.bun = filepath.Dir(flags.NodeBin), flags.Bun, flags.BunBin, true
+	flags.path = append(flags.path, flags.Node)
 	return nil
 }
@@ -0,0 +1,77 @@
+package gen
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssetgenWithoutNode(t *testing.T) {
+	dir := t.TempDir()
+	for n, s := range map[string]string{
+		"go.mod":                     "module x\n\ngo 1.16\n",
+		"assets/assets.anko":         "",
+		"assets/templates/app.html":  "{% func App(s string) %}\n<p>\n  {%s s %}\n</p>\n{% endfunc %}\n",
+		"assets/templates/page.html": "{% func Page() %}<div>  <?x ?>  </div>{% endfunc %}\n",
+	} {
+		n = filepath.Join(dir, filepath.FromSlash(n))
+		if err := os.MkdirAll(filepath.Dir(n), 0755); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if err := ioutil.WriteFile(n, []byte(s), 0644); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	flags := NewFlags(dir)
+	fs := flags.FlagSet("assetgen", flag.ContinueOnError)
+	if err := fs.Parse([]string{"-html-minifier=go", "-node=/nonexistent", "-yarn=/nonexistent"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	flags.Logger, _ = NewLogger(ioutil.Discard, LogQuiet, "")
+	if err := Assetgen(flags); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !flags.noNode {
+		t.Errorf("expected node not to be set up")
+	}
+	if fileExists(filepath.Join(dir, "package.json")) {
+		t.Errorf("expected no package.json")
+	}
+	for _, n := range []string{"assets/assets.go", "assets/dist/manifest.json", "assets/templates/app.html.go"} {
+		if !fileExists(filepath.Join(dir, filepath.FromSlash(n))) {
+			t.Errorf("expected %s to exist", n)
+		}
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "assets", "templates", "app.html.go"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if s := string(buf); strings.Contains(s, "<p>\n") {
+		t.Errorf("expected minified template, got:\n%s", s)
+	}
+}
+
+func TestScriptNodeNeeded(t *testing.T) {
+	tests := []struct {
+		deps  []dep
+		steps []string
+		exp   bool
+	}{
+		{nil, nil, false},
+		{nil, []string{"templates", "css urls"}, false},
+		{[]dep{{"html-minifier", ""}}, []string{"templates"}, true},
+		{nil, []string{"plugin(my-plugin)"}, true},
+	}
+	for i, test := range tests {
+		s := &Script{nodeDeps: test.deps}
+		for _, n := range test.steps {
+			s.exec = append(s.exec, step{name: n})
+		}
+		if b := s.nodeNeeded(); b != test.exp {
+			t.Errorf("test %d expected %t, got: %t", i, test.exp, b)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package gen
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+func init() {
+	// registered here, as both refer to commands
+	commands["help"] = command{
+		"show commands, flags, and script functions",
+		func(flags *Flags, _ []string) error {
+			printHelp(os.Stdout, "", newHelpFlagSet())
+			return nil
+		},
+	}
+	commands["completion"] = command{
+		"generate shell completion (bash, zsh, fish)",
+		func(flags *Flags, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("completion requires a shell (bash, zsh, fish)")
+			}
+			return printCompletion(os.Stdout, args[0])
+		},
+	}
+}
+
+// flagValues are the allowed values for flags.
+var flagValues = map[string][]string{
+	"log-level":     {"quiet", "warn", "normal", "verbose", "debug"},
+	"log-format":    {"text", "json"},
+	"node-libc":     {"auto", "glibc", "musl"},
+	"runtime":       {"node", "bun"},
+	"env":           {productionEnv, developmentEnv},
+	"symlinks":      {symlinksFollow, symlinksSkip, symlinksError},
+	"ipc-transport": {"auto", "unix", "pipe", "tcp"},
+	"html-minifier": {"node", "go"},
+	"package-json":  {"create", "merge", "none"},
+	"audit-level":   auditSeverities,
+	"error-format":  {"text", "unix", "json"},
+	"embed":         {"all", "assets", "manifest"},
+}
+
+// commandArgs returns the allowed args for the named command.
+func commandArgs(name string) []string {
+	switch name {
+	case "init":
+		names, _ := starterNames()
+		return names
+	case "clean":
+		return cleanTargets
+	case "completion":
+		return []string{"bash", "zsh", "fish"}
+	}
+	return nil
+}
+
+// commandNames returns the sorted command names.
+func commandNames() []string {
+	var names []string
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newHelpFlagSet returns a flag set with the default flag values.
+func newHelpFlagSet() *flag.FlagSet {
+	return NewFlags("").FlagSet("assetgen", flag.ContinueOnError)
+}
+
+// printHelp prints the usage, commands, flags, and script functions. When
+// name is not empty, the usage is for the named command.
+func printHelp(w io.Writer, name string, fs *flag.FlagSet) {
+	if name == "" {
+		name = "[command]"
+	}
+	fmt.Fprintf(w, "usage: assetgen %s [flags]\n\n", name)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMMANDS:")
+	for _, n := range commandNames() {
+		fmt.Fprintf(tw, "  %s\t%s\n", n, commands[n].desc)
+	}
+	fmt.Fprintln(tw, "\nFLAGS:")
+	fs.VisitAll(func(f *flag.Flag) {
+		typ, usage := flag.UnquoteUsage(f)
+		if typ != "" {
+			typ = " " + typ
+		}
+		if f.DefValue != "" && f.DefValue != "false" {
+			usage += fmt.Sprintf(" (default: %s)", f.DefValue)
+		}
+		fmt.Fprintf(tw, "  -%s%s\t%s\n", f.Name, typ, usage)
+	})
+	fmt.Fprintln(tw, "\nSCRIPT FUNCTIONS:")
+	for _, z := range new(Script).funcs() {
+		fmt.Fprintf(tw, "  %s\n", funcSignature(z.n, z.v))
+	}
+	tw.Flush()
+}
+
+// funcSignature returns the signature of the script function v.
+func funcSignature(name string, v interface{}) string {
+	return name + strings.TrimPrefix(reflect.TypeOf(v).String(), "func")
+}
+
+// printCompletion prints the completion script for the shell.
+func printCompletion(w io.Writer, shell string) error {
+	fs := newHelpFlagSet()
+	var flags, boolFlags []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			boolFlags = append(boolFlags, f.Name)
+		}
+		flags = append(flags, f.Name)
+	})
+	var names []string
+	for n := range flagValues {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	buf := new(bytes.Buffer)
+	switch shell {
+	case "bash", "zsh":
+		if shell == "zsh" {
+			fmt.Fprintln(buf, "autoload -U +X bashcompinit && bashcompinit")
+		}
+		fmt.Fprintln(buf, "_assetgen() {")
+		fmt.Fprintln(buf, `  local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+		fmt.Fprintln(buf, `  case "$prev" in`)
+		for _, n := range names {
+			fmt.Fprintf(buf, "    -%s|--%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return;;\n", n, n, strings.Join(flagValues[n], " "))
+		}
+		for _, n := range commandNames() {
+			if args := commandArgs(n); args != nil {
+				fmt.Fprintf(buf, "    %s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return;;\n", n, strings.Join(args, " "))
+			}
+		}
+		var valueFlags []string
+		for _, n := range flags {
+			if _, ok := flagValues[n]; !ok && !contains(boolFlags, n) {
+				valueFlags = append(valueFlags, "-"+n, "--"+n)
+			}
+		}
+		fmt.Fprintf(buf, "    %s) COMPREPLY=($(compgen -f -- \"$cur\")); return;;\n", strings.Join(valueFlags, "|"))
+		fmt.Fprintln(buf, "  esac")
+		fmt.Fprintln(buf, `  if [[ "$cur" == -* ]]; then`)
+		fmt.Fprintf(buf, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", "-"+strings.Join(flags, " -"))
+		fmt.Fprintln(buf, "  elif [[ $COMP_CWORD -eq 1 ]]; then")
+		fmt.Fprintf(buf, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+		fmt.Fprintln(buf, "  fi")
+		fmt.Fprintln(buf, "}")
+		fmt.Fprintln(buf, "complete -F _assetgen assetgen")
+	case "fish":
+		fmt.Fprintln(buf, "complete -c assetgen -f")
+		for _, n := range commandNames() {
+			fmt.Fprintf(buf, "complete -c assetgen -n __fish_use_subcommand -a %s -d %s\n", n, fishQuote(commands[n].desc))
+			if args := commandArgs(n); args != nil {
+				fmt.Fprintf(buf, "complete -c assetgen -n '__fish_seen_subcommand_from %s' -a %s\n", n, fishQuote(strings.Join(args, " ")))
+			}
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			_, usage := flag.UnquoteUsage(f)
+			switch v, ok := flagValues[f.Name]; {
+			case ok:
+				fmt.Fprintf(buf, "complete -c assetgen -o %s -x -a %s -d %s\n", f.Name, fishQuote(strings.Join(v, " ")), fishQuote(usage))
+			case contains(boolFlags, f.Name):
+				fmt.Fprintf(buf, "complete -c assetgen -o %s -d %s\n", f.Name, fishQuote(usage))
+			default:
+				fmt.Fprintf(buf, "complete -c assetgen -o %s -r -F -d %s\n", f.Name, fishQuote(usage))
+			}
+		})
+	default:
+		return fmt.Errorf("unknown shell %q", shell)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// fishQuote quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// contains determines if v contains s.
+func contains(v []string, s string) bool {
+	for _, z := range v {
+		if z == s {
+			return true
+		}
+	}
+	return false
+}
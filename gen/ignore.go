@@ -0,0 +1,181 @@
+package gen
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// ignore file names.
+const (
+	gitignoreFile      = ".gitignore"
+	assetgenignoreFile = ".assetgenignore"
+)
+
+// ignorePattern is a gitignore style pattern, relative to a base directory.
+type ignorePattern struct {
+	base    string
+	globs   []glob.Glob
+	neg     bool
+	dirOnly bool
+}
+
+// parseIgnorePattern parses a gitignore style pattern relative to base,
+// returning nil for blank lines and comments.
+func parseIgnorePattern(base, pattern string) (*ignorePattern, error) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil, nil
+	}
+	p := &ignorePattern{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		p.neg, pattern = true, pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		p.dirOnly, pattern = true, strings.TrimRight(pattern, "/")
+	}
+	// patterns without a leading or middle slash match at any depth
+	pats := []string{strings.TrimPrefix(pattern, "/")}
+	if !strings.Contains(pattern, "/") {
+		pats = append(pats, "**/"+pattern)
+	}
+	for _, pat := range pats {
+		g, err := glob.Compile(pat, '/')
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+		}
+		p.globs = append(p.globs, g)
+	}
+	return p, nil
+}
+
+// match determines if the pattern matches the path n.
+func (p *ignorePattern) match(n string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(p.base, n)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, g := range p.globs {
+		if g.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadIgnoreFiles loads the patterns in the .gitignore and .assetgenignore
+// files in each of the directories.
+func loadIgnoreFiles(dirs ...string) ([]*ignorePattern, error) {
+	var patterns []*ignorePattern
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		for _, name := range []string{gitignoreFile, assetgenignoreFile} {
+			buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+			switch {
+			case err != nil && os.IsNotExist(err):
+				continue
+			case err != nil:
+				return nil, err
+			}
+			s := bufio.NewScanner(bytes.NewReader(buf))
+			for s.Scan() {
+				p, err := parseIgnorePattern(dir, s.Text())
+				switch {
+				case err != nil:
+					return nil, fmt.Errorf("%s: %w", filepath.Join(dir, name), err)
+				case p != nil:
+					patterns = append(patterns, p)
+				}
+			}
+		}
+	}
+	return patterns, nil
+}
+
+// ignore is the script handler to add gitignore style patterns, relative to
+// the script's directory, for files that should not be packed or processed.
+func (s *Script) ignore(patterns ...string) error {
+	for _, pattern := range patterns {
+		p, err := parseIgnorePattern(s.dir, pattern)
+		switch {
+		case err != nil:
+			return err
+		case p != nil:
+			s.ignores = append(s.ignores, p)
+		}
+	}
+	return nil
+}
+
+// ignored determines if the walked path n is ignored. As with git, the last
+// matching pattern wins, and the ignore files of nested directories take
+// precedence over those of their parents.
+func (s *Script) ignored(n string, fi os.FileInfo) bool {
+	var ignored bool
+	for _, patterns := range append([][]*ignorePattern{s.ignores}, s.nestedIgnoresFor(n)...) {
+		for _, p := range patterns {
+			if p.match(n, fi.IsDir()) {
+				ignored = !p.neg
+			}
+		}
+	}
+	return ignored
+}
+
+// nestedIgnoresFor returns the patterns in the ignore files of each directory
+// between the working or assets directory and the parent of the walked path
+// n, loading the ignore files the first time a directory is seen.
+func (s *Script) nestedIgnoresFor(n string) [][]*ignorePattern {
+	dir := filepath.Dir(n)
+	var root string
+	for _, d := range []string{s.flags.Wd, s.flags.Assets} {
+		if rel, err := filepath.Rel(d, dir); d != "" && err == nil && !strings.HasPrefix(rel, "..") && len(d) > len(root) {
+			root = d
+		}
+	}
+	if root == "" || root == dir {
+		return nil
+	}
+	rel, _ := filepath.Rel(root, dir)
+	s.ignoresMu.Lock()
+	defer s.ignoresMu.Unlock()
+	if s.nestedIgnores == nil {
+		s.nestedIgnores = make(map[string][]*ignorePattern)
+	}
+	var nested [][]*ignorePattern
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		root = filepath.Join(root, name)
+		patterns, ok := s.nestedIgnores[root]
+		if !ok {
+			var err error
+			if patterns, err = loadIgnoreFiles(root); err != nil {
+				warnf(s.flags, "unable to load ignore files: %v", err)
+			}
+			s.nestedIgnores[root] = patterns
+		}
+		nested = append(nested, patterns)
+	}
+	return nested
+}
+
+// skipDir returns filepath.SkipDir when fi is a directory.
+func skipDir(fi os.FileInfo) error {
+	if fi.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package gen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnorePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		n       string
+		isDir   bool
+		exp     bool
+	}{
+		{"*.png", "/a/b.png", false, true},
+		{"*.png", "/a/c/b.png", false, true},
+		{"*.png", "/a/b.jpg", false, false},
+		{"/b.png", "/a/b.png", false, true},
+		{"/b.png", "/a/c/b.png", false, false},
+		{"c/*.png", "/a/c/b.png", false, true},
+		{"c/*.png", "/a/d/c/b.png", false, false},
+		{"**/c/*.png", "/a/d/c/b.png", false, true},
+		{"tmp/", "/a/tmp", true, true},
+		{"tmp/", "/a/tmp", false, false},
+		{"tmp/", "/a/c/tmp", true, true},
+		{"*.png", "/b.png", false, false},
+		{"*.png", "/other/b.png", false, false},
+		{"!*.png", "/a/b.png", false, true},
+	}
+	for i, test := range tests {
+		p, err := parseIgnorePattern(filepath.FromSlash("/a"), test.pattern)
+		if err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		if m := p.match(filepath.FromSlash(test.n), test.isDir); m != test.exp {
+			t.Errorf("test %d expected %q to match %s %t, got: %t", i, test.pattern, test.n, test.exp, m)
+		}
+	}
+	for i, s := range []string{"", "  ", "# comment"} {
+		if p, err := parseIgnorePattern("/a", s); p != nil || err != nil {
+			t.Errorf("test %d expected nil pattern, got: %v %v", i, p, err)
+		}
+	}
+	if _, err := parseIgnorePattern("/a", "[a"); err == nil {
+		t.Errorf("expected error for invalid pattern")
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	dir := t.TempDir()
+	assets := filepath.Join(dir, "assets")
+	for n, s := range map[string]string{
+		".gitignore":                    "*.log\n",
+		"assets/.assetgenignore":        "*.tmp\n/top.txt\n",
+		"assets/top.txt":                "",
+		"assets/a/top.txt":              "",
+		"assets/a/.gitignore":           "!keep.log\n*.txt\nb/\n",
+		"assets/a/keep.log":             "",
+		"assets/a/x.log":                "",
+		"assets/a/x.tmp":                "",
+		"assets/a/x.css":                "",
+		"assets/a/b/x.css":              "",
+		"assets/a/c/.assetgenignore":    "!*.txt\n",
+		"assets/a/c/x.txt":              "",
+		"assets/a/c/x.log":              "",
+		"assets/other/x.txt":            "",
+		"assets/other/keep.log":         "",
+		"assets/other/d/.gitignore":     "*.css\n",
+		"assets/other/d/x.css":          "",
+		"assets/other/d/e/x.css":        "",
+		"assets/other/d/e/.gitignore":   "!x.css\n",
+		"assets/other/d/e/f/x.css":      "",
+		"assets/other/d/e/f/.gitignore": "",
+	} {
+		name := filepath.Join(dir, filepath.FromSlash(n))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if err := ioutil.WriteFile(name, []byte(s), 0o644); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	flags := &Flags{Wd: dir, Assets: assets}
+	flags.Logger, _ = NewLogger(ioutil.Discard, LogQuiet, "")
+	ignores, err := loadIgnoreFiles(flags.Wd, flags.Assets)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	s := &Script{flags: flags, dir: assets, ignores: ignores}
+	if err := s.ignore("other/*.txt"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		n   string
+		exp bool
+	}{
+		{"top.txt", true},
+		{"a/top.txt", true},
+		{"a/keep.log", false},
+		{"a/x.log", true},
+		{"a/x.tmp", true},
+		{"a/x.css", false},
+		{"a/b", true},
+		{"a/c/x.txt", false},
+		{"a/c/x.log", true},
+		{"other/x.txt", true},
+		{"other/keep.log", true},
+		{"other/d/x.css", true},
+		{"other/d/e/x.css", false},
+		{"other/d/e/f/x.css", false},
+	}
+	for i, test := range tests {
+		n := filepath.Join(assets, filepath.FromSlash(test.n))
+		fi, err := os.Stat(n)
+		if err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		if ignored := s.ignored(n, fi); ignored != test.exp {
+			t.Errorf("test %d expected %s ignored %t, got: %t", i, test.n, test.exp, ignored)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package gen
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultStarter is the default starter for the init command.
+const defaultStarter = "plain"
+
+// Init scaffolds a working assets tree (script, sass and js entrypoints, and
+// an example template) in the assets directory from the named starter.
+//
+// Existing files are not overwritten.
+func Init(flags *Flags, name string) error {
+	if name == "" {
+		name = defaultStarter
+	}
+	names, err := starterNames()
+	if err != nil {
+		return err
+	}
+	if i := sort.SearchStrings(names, name); i == len(names) || names[i] != name {
+		return fmt.Errorf("unknown starter %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	// resolve paths
+	if flags.Assets == "" {
+		flags.Assets = filepath.Join(flags.Wd, assetsDir)
+	}
+	if flags.Script == "" {
+		flags.Script = filepath.Join(flags.Assets, scriptName)
+	}
+	for _, p := range []*string{&flags.Assets, &flags.Script} {
+		if !filepath.IsAbs(*p) {
+			*p = filepath.Join(flags.Wd, *p)
+		}
+	}
+	if fileExists(flags.Script) {
+		return fmt.Errorf("%s already exists", flags.Script)
+	}
+	// write files
+	files := map[string]string{
+		filepath.Join(flags.Assets, ".gitignore"): tplf("gitignore"),
+	}
+	root := path.Join("starters", name)
+	err = fs.WalkDir(starters, root, func(n string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case d.IsDir():
+			return nil
+		}
+		buf, err := starters.ReadFile(n)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(flags.Assets, filepath.FromSlash(strings.TrimPrefix(n, root+"/")))
+		if n == path.Join(root, scriptName) {
+			out = flags.Script
+		}
+		files[out] = string(buf)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not load starter %s: %w", name, err)
+	}
+	var created []string
+	for n := range files {
+		created = append(created, n)
+	}
+	sort.Strings(created)
+	for _, n := range created {
+		if fileExists(n) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(n), 0755); err != nil {
+			return fmt.Errorf("could not create %s: %w", filepath.Dir(n), err)
+		}
+		if err := writeCond(n, files[n]); err != nil {
+			return fmt.Errorf("could not write %s: %w", n, err)
+		}
+		fmt.Fprintf(os.Stdout, "CREATED: %s\n", n)
+	}
+	return nil
+}
+
+// starterNames returns the sorted names of the available starters.
+func starterNames() ([]string, error) {
+	entries, err := starters.ReadDir("starters")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -10,35 +10,48 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/Masterminds/semver"
 	"github.com/kenshaw/assetgen/gen/sigs"
 	"github.com/kenshaw/assetgen/pack"
-	"golang.org/x/crypto/openpgp"
+	"github.com/yookoala/realpath"
 )
 
 // installNode installs node to the cache directory.
 func installNode(flags *Flags) (string, string, error) {
+	// determine platform
+	platform, ext, musl, err := nodePlatform(flags)
+	if err != nil {
+		return "", "", err
+	}
+	dist := nodeDist{url: flags.NodeMirror, name: "node", key: sigs.NodeJsPub, sig: ".sig", verify: sigs.VerifyPGP}
+	if musl {
+		if flags.NodeMirror == nodeDistURL {
+			dist.url = nodeMuslDistURL
+		}
+		dist.name, dist.verify = "node-unofficial", nil
+	}
+	if flags.NodeMirrorKey != "" {
+		if dist.key, err = ioutil.ReadFile(flags.NodeMirrorKey); err != nil {
+			return "", "", fmt.Errorf("could not read node mirror key: %w", err)
+		}
+		dist.sig, dist.verify = mirrorVerifier(dist.key)
+	}
 	// get version
-	v, err := getNodeLtsVersion(flags)
+	v, err := getNodeVersion(flags, dist)
 	if err != nil {
 		return "", "", err
 	}
-	// env variables
-	platform, ext := runtime.GOOS, ".tar.gz"
-	switch runtime.GOOS {
-	case "linux", "darwin":
-	case "windows":
-		platform, ext = "win", ".zip"
-	default:
-		return "", "", fmt.Errorf("unsupported os: %s", runtime.GOOS)
+	if err := flags.lock.resolve(flags, "node", v, ""); err != nil {
+		return "", "", err
 	}
-	platform += "-x64"
 	// build paths
 	nodePath := filepath.Join(flags.Cache, "node", v, platform)
 	binPath := filepath.Join(nodePath, "bin", "node")
@@ -53,7 +66,10 @@ func installNode(flags *Flags) (string, string, error) {
 		return "", "", fmt.Errorf("could not stat %q: %w", binPath, err)
 	case fi.IsDir():
 		return "", "", fmt.Errorf("%q is in invalid state: manually remove to try again", nodePath)
-	case runtime.GOOS == "windows" || fi.Mode()|0111 != 0:
+	case runtime.GOOS == "windows" || fi.Mode()&0111 != 0:
+		if err := flags.lock.resolvePlatform(flags, "node", platform, v, ""); err != nil {
+			return "", "", err
+		}
 		return nodePath, binPath, nil
 	}
 	// remove existing directory
@@ -61,10 +77,13 @@ func installNode(flags *Flags) (string, string, error) {
 		return "", "", fmt.Errorf("could not remove %q: %w", nodePath, err)
 	}
 	// retrieve archive
-	buf, err := getNodeAndVerify(flags, v, platform, ext)
+	buf, err := getNodeAndVerify(flags, dist, v, platform, ext)
 	if err != nil {
 		return "", "", fmt.Errorf("could not retrieve node %s (%s): %w", v, platform, err)
 	}
+	if err := flags.lock.resolvePlatform(flags, "node", platform, v, sha256hex(buf)); err != nil {
+		return "", "", err
+	}
 	// extract archive
 	if err := extractArchive(nodePath, buf, ext, fmt.Sprintf("node-%s-%s", v, platform)+"/"); err != nil {
 		return "", "", fmt.Errorf("unable to extract node %s (%s): %w", v, platform, err)
@@ -72,6 +91,86 @@ func installNode(flags *Flags) (string, string, error) {
 	return nodePath, binPath, nil
 }
 
+// nodeDist describes a node distribution source.
+type nodeDist struct {
+	// url is the distribution base url.
+	url string
+	// name is the cache directory name.
+	name string
+	// key is the public key the distribution's SHASUMS256.txt is signed
+	// with.
+	key []byte
+	// sig is the extension of the SHASUMS256.txt signature file.
+	sig string
+	// verify verifies the SHASUMS256.txt signature, or is nil when the
+	// distribution does not publish signatures.
+	verify func(key, signed, sig []byte) error
+}
+
+// mirrorVerifier returns the signature file extension and the verify func for
+// the public key of a node mirror, by the key's format: an armored PGP
+// keyring, a PEM encoded cosign public key, or a minisign public key.
+func mirrorVerifier(key []byte) (string, func(key, signed, sig []byte) error) {
+	switch key = bytes.TrimSpace(key); {
+	case bytes.HasPrefix(key, []byte("-----BEGIN PGP")):
+		return ".sig", sigs.VerifyPGP
+	case bytes.HasPrefix(key, []byte("-----BEGIN")):
+		return ".sig", sigs.VerifyCosign
+	}
+	return ".minisig", sigs.VerifyMinisign
+}
+
+// nodePlatform returns the node distribution platform and archive extension
+// for the current os and architecture, and whether the musl build is needed.
+func nodePlatform(flags *Flags) (string, string, bool, error) {
+	platform, ext := runtime.GOOS, ".tar.gz"
+	switch runtime.GOOS {
+	case "linux", "darwin":
+	case "windows":
+		platform, ext = "win", ".zip"
+	default:
+		return "", "", false, fmt.Errorf("unsupported os: %s", runtime.GOOS)
+	}
+	switch runtime.GOARCH {
+	case "amd64":
+		platform += "-x64"
+	case "arm64":
+		platform += "-arm64"
+	case "arm":
+		platform += "-armv7l"
+	case "386":
+		platform += "-x86"
+	case "ppc64le", "s390x":
+		platform += "-" + runtime.GOARCH
+	default:
+		return "", "", false, fmt.Errorf("unsupported arch: %s", runtime.GOARCH)
+	}
+	var musl bool
+	switch flags.NodeLibc {
+	case "", "auto":
+		musl = runtime.GOOS == "linux" && isMusl()
+	case "glibc":
+	case "musl":
+		if runtime.GOOS != "linux" {
+			return "", "", false, fmt.Errorf("musl is not supported on %s", runtime.GOOS)
+		}
+		musl = true
+	default:
+		return "", "", false, fmt.Errorf("invalid node libc %q", flags.NodeLibc)
+	}
+	if musl {
+		platform += "-musl"
+	}
+	return platform, ext, musl, nil
+}
+
+// isMusl determines if the system's libc is musl, by checking for the musl
+// dynamic loader.
+func isMusl() bool {
+	m, err := filepath.Glob("/lib/ld-musl-*.so.1")
+	return err == nil && len(m) != 0
+}
+
 // ltsString is a type that handles unmarshaling the lts version in node's
 // versions file.
 type ltsString string
@@ -84,16 +183,21 @@ func (v *ltsString) UnmarshalJSON(buf []byte) error {
 	return nil
 }
 
-// getNodeLtsVersion reads the available node versions and returns the most
+// getNodeVersion reads the available node versions and returns the most
+// recent release matching the requested node version, defaulting to the most
 // recent lts release.
-func getNodeLtsVersion(flags *Flags) (string, error) {
+func getNodeVersion(flags *Flags, dist nodeDist) (string, error) {
 	type nodeVersion struct {
 		Version string
 		Files   []string
 		Lts     ltsString
 	}
+	want, err := requestedNodeVersion(flags)
+	if err != nil {
+		return "", err
+	}
 	// load available node versions
-	verBuf, err := getAndCache(flags, nodeDistURL+"/index.json", flags.Ttl, false, "node", "versions.json")
+	verBuf, err := getAndCache(flags, dist.url+"/index.json", flags.Ttl, false, dist.name, "versions.json")
 	if err != nil {
 		return "", fmt.Errorf("could not retrieve available node versions: %w", err)
 	}
@@ -115,40 +219,101 @@ func getNodeLtsVersion(flags *Flags) (string, error) {
 		vers[v.String()], vs[i] = nv, v
 	}
 	sort.Sort(semver.Collection(vs))
-	// find latest lts
+	// find latest matching, preferring the locked version
+	locked, found := flags.lock.locked(flags, "node"), ""
 	for i := len(vs) - 1; i >= 0; i-- {
-		if v := vers[vs[i].String()]; v.Lts != "" {
-			return v.Version, nil
+		v := vers[vs[i].String()]
+		var match bool
+		switch {
+		case want == "" || want == "lts/*" || want == "lts":
+			match = v.Lts != ""
+		case strings.HasPrefix(want, "lts/"):
+			match = strings.EqualFold(string(v.Lts), strings.TrimPrefix(want, "lts/"))
+		default:
+			match = matchVersionPrefix(vs[i], want)
+		}
+		match = match && compareSemver(vs[i].String(), flags.NodeConstraint)
+		switch {
+		case match && v.Version == locked:
+			return locked, nil
+		case match && found == "":
+			found = v.Version
+		}
+	}
+	if found != "" {
+		return found, nil
+	}
+	if want == "" {
+		return "", fmt.Errorf("could not find a lts node version matching %s", flags.NodeConstraint)
+	}
+	return "", fmt.Errorf("could not find a node version matching %q and %s", want, flags.NodeConstraint)
+}
+
+// requestedNodeVersion returns the node version requested by flags, or the
+// version in the working directory's .nvmrc or .node-version file.
+func requestedNodeVersion(flags *Flags) (string, error) {
+	if flags.NodeVersion != "" {
+		return flags.NodeVersion, nil
+	}
+	for _, n := range []string{".nvmrc", ".node-version"} {
+		buf, err := ioutil.ReadFile(filepath.Join(flags.Wd, n))
+		switch {
+		case err != nil && os.IsNotExist(err):
+			continue
+		case err != nil:
+			return "", fmt.Errorf("could not read %s: %w", n, err)
+		}
+		if v := strings.TrimSpace(string(buf)); v != "" {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// matchVersionPrefix determines if v matches the (possibly partial) version
+// want, such as 18, 18.19, or v18.19.0.
+func matchVersionPrefix(v *semver.Version, want string) bool {
+	parts := strings.Split(strings.TrimPrefix(want, "v"), ".")
+	if len(parts) > 3 {
+		return false
+	}
+	for i, n := range []int64{v.Major(), v.Minor(), v.Patch()}[:len(parts)] {
+		if parts[i] != strconv.FormatInt(n, 10) {
+			return false
 		}
 	}
-	return "", errors.New("could not find a lts node version")
+	return v.Prerelease() == ""
 }
 
 // getNodeAndVerify retrieves the node.js binary distribution for the specified
 // version, platform, and file extension and verifies its hash in the
 // SHASUMS256.txt file.
-func getNodeAndVerify(flags *Flags, version, platform, ext string) ([]byte, error) {
+//
+// The SHASUMS256.txt signature is verified when the distribution is signed
+// (see -node-mirror-key).
+func getNodeAndVerify(flags *Flags, dist nodeDist, version, platform, ext string) ([]byte, error) {
 	fn := fmt.Sprintf("node-%v-%s%s", version, platform, ext)
-	urlbase := nodeDistURL + "/" + version
+	urlbase := dist.url + "/" + version
 	// grab signature files
-	txt, err := getAndCache(flags, urlbase+"/SHASUMS256.txt", 0, false, "node", version, "SHASUMS256.txt")
-	if err != nil {
-		return nil, err
-	}
-	sig, err := getAndCache(flags, urlbase+"/SHASUMS256.txt.sig", 0, false, "node", version, "SHASUMS256.txt.sig")
-	if err != nil {
-		return nil, err
-	}
-	// verify signature
-	kr, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(sigs.NodeJsPub))
+	txt, err := getAndCache(flags, urlbase+"/SHASUMS256.txt", 0, false, dist.name, version, "SHASUMS256.txt")
 	if err != nil {
 		return nil, err
 	}
-	if _, err := openpgp.CheckDetachedSignature(kr, bytes.NewReader(txt), bytes.NewReader(sig)); err != nil {
-		return nil, fmt.Errorf("could not verify signature: %w", err)
+	if dist.verify != nil {
+		n := "SHASUMS256.txt" + dist.sig
+		sig, err := getAndCache(flags, urlbase+"/"+n, 0, false, dist.name, version, n)
+		if err != nil {
+			return nil, err
+		}
+		// verify signature
+		if err := dist.verify(dist.key, txt, sig); err != nil {
+			return nil, fmt.Errorf("could not verify signature: %w", err)
+		}
+	} else {
+		warnf(flags, "%s does not publish signatures: only verifying hash of %s", dist.url, fn)
 	}
 	// get node
-	buf, err := getAndCache(flags, urlbase+"/"+fn, 0, false, "node", fn)
+	buf, err := getAndCache(flags, urlbase+"/"+fn, 0, false, dist.name, fn)
 	if err != nil {
 		return nil, err
 	}
@@ -176,12 +341,16 @@ var semverRE = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+$`)
 
 // installYarn installs yarn to the cache directory.
 func installYarn(flags *Flags) (string, string, error) {
-	v, assets, err := githubLatestAssets(flags, "yarnpkg/yarn", "yarn")
+	var tag string
+	if pin := flags.lock.pinned(flags, "yarn", flags.YarnVersion); pin != "" {
+		tag = "v" + strings.TrimPrefix(pin, "v")
+	}
+	v, assets, err := githubReleaseAssets(flags, "yarnpkg/yarn", "yarn", tag)
 	if err != nil {
 		return "", "", err
 	}
 	if !semverRE.MatchString(v) {
-		return "", "", fmt.Errorf("cannot retrieve latest yarn release: invalid release tag %s", v)
+		return "", "", fmt.Errorf("cannot retrieve yarn release: invalid release tag %s", v)
 	}
 	if !strings.HasPrefix(v, "v") {
 		v = "v" + v
@@ -200,7 +369,10 @@ func installYarn(flags *Flags) (string, string, error) {
 		return "", "", fmt.Errorf("could not stat %q: %w", binPath, err)
 	case fi.IsDir():
 		return "", "", fmt.Errorf("%q is in invalid state: manually remove to try again", yarnPath)
-	case runtime.GOOS == "windows" || fi.Mode()|0111 != 0:
+	case runtime.GOOS == "windows" || fi.Mode()&0111 != 0:
+		if err := flags.lock.resolve(flags, "yarn", v, ""); err != nil {
+			return "", "", err
+		}
 		return yarnPath, binPath, nil
 	}
 	// remove existing directory
@@ -212,6 +384,9 @@ func installYarn(flags *Flags) (string, string, error) {
 	if err != nil {
 		return "", "", fmt.Errorf("could not retrieve yarn %s: %w", v, err)
 	}
+	if err := flags.lock.resolve(flags, "yarn", v, sha256hex(buf)); err != nil {
+		return "", "", err
+	}
 	// create dir
 	if err := os.MkdirAll(yarnPath, 0755); err != nil {
 		return "", "", fmt.Errorf("could not create yarn %s directory: %w", v, err)
@@ -245,22 +420,153 @@ func getYarnAndVerify(flags *Flags, version string, assets []githubAsset) ([]byt
 		}
 	}
 	// verify signature
-	kr, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(sigs.YarnPub))
+	if err := sigs.VerifyPGP(sigs.YarnPub, buf, asc); err != nil {
+		return nil, fmt.Errorf("could not verify signature: %w", err)
+	}
+	return buf, nil
+}
+
+// installBun installs bun to the cache directory.
+func installBun(flags *Flags) (string, string, error) {
+	// determine platform
+	platform := runtime.GOOS
+	switch runtime.GOOS {
+	case "linux", "darwin", "windows":
+	default:
+		return "", "", fmt.Errorf("unsupported os: %s", runtime.GOOS)
+	}
+	switch runtime.GOARCH {
+	case "amd64":
+		platform += "-x64"
+	case "arm64":
+		platform += "-aarch64"
+	default:
+		return "", "", fmt.Errorf("unsupported arch: %s", runtime.GOARCH)
+	}
+	if runtime.GOOS == "linux" && (flags.NodeLibc == "musl" || (flags.NodeLibc == "auto" && isMusl())) {
+		platform += "-musl"
+	}
+	var tag string
+	if pin := flags.lock.pinned(flags, "bun", flags.BunVersion); pin != "" {
+		tag = "bun-v" + strings.TrimPrefix(pin, "v")
+	}
+	v, assets, err := githubReleaseAssets(flags, "oven-sh/bun", "bun", tag)
 	if err != nil {
-		return nil, err
+		return "", "", err
+	}
+	v = "v" + strings.TrimPrefix(strings.TrimPrefix(v, "Bun "), "v")
+	if !semverRE.MatchString(v) {
+		return "", "", fmt.Errorf("cannot retrieve bun release: invalid release name %s", v)
+	}
+	// build paths
+	bunPath := filepath.Join(flags.Cache, "bun", v)
+	binPath := filepath.Join(bunPath, "bin", "bun")
+	if runtime.GOOS == "windows" {
+		binPath += ".exe"
+	}
+	// stat bun path
+	fi, err := os.Stat(binPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return "", "", fmt.Errorf("could not stat %q: %w", binPath, err)
+	case fi.IsDir():
+		return "", "", fmt.Errorf("%q is in invalid state: manually remove to try again", bunPath)
+	case runtime.GOOS == "windows" || fi.Mode()&0111 != 0:
+		if err := flags.lock.resolvePlatform(flags, "bun", platform, v, ""); err != nil {
+			return "", "", err
+		}
+		return bunPath, binPath, nil
+	}
+	// remove existing directory
+	if err := os.RemoveAll(bunPath); err != nil {
+		return "", "", fmt.Errorf("could not remove %q: %w", bunPath, err)
+	}
+	// retrieve archive
+	n := "bun-" + platform
+	buf, err := getBunAndVerify(flags, v, n, assets)
+	if err != nil {
+		return "", "", fmt.Errorf("could not retrieve bun %s: %w", v, err)
+	}
+	if err := flags.lock.resolvePlatform(flags, "bun", platform, v, sha256hex(buf)); err != nil {
+		return "", "", err
+	}
+	// extract archive
+	if err := os.MkdirAll(filepath.Join(bunPath, "bin"), 0755); err != nil {
+		return "", "", fmt.Errorf("could not create bun %s directory: %w", v, err)
 	}
+	if err := extractZip(filepath.Join(bunPath, "bin"), buf, n); err != nil {
+		return "", "", fmt.Errorf("unable to extract bun %s: %w", v, err)
+	}
+	return bunPath, binPath, nil
+}
 
-	if _, err := openpgp.CheckArmoredDetachedSignature(kr, bytes.NewReader(buf), bytes.NewReader(asc)); err != nil {
-		return nil, fmt.Errorf("could not verify signature: %w", err)
+// getBunAndVerify retrieves the named bun release archive for the specified
+// version, and verifies it against the release's SHASUMS256.txt.
+func getBunAndVerify(flags *Flags, version, n string, assets []githubAsset) ([]byte, error) {
+	var buf, shasums []byte
+	for _, a := range assets {
+		switch a.Name {
+		case n + ".zip":
+			var err error
+			if buf, err = getAndCache(flags, a.BrowserDownloadURL, 0, false, "bun", version, a.Name); err != nil {
+				return nil, err
+			}
+		case "SHASUMS256.txt":
+			var err error
+			if shasums, err = getAndCache(flags, a.BrowserDownloadURL, 0, false, "bun", version, a.Name); err != nil {
+				return nil, err
+			}
+		}
 	}
-	return buf, nil
+	switch {
+	case buf == nil:
+		return nil, fmt.Errorf("release is missing %s.zip", n)
+	case shasums == nil:
+		return nil, errors.New("release is missing SHASUMS256.txt")
+	}
+	// verify hash
+	hash := sha256hex(buf)
+	scanner := bufio.NewScanner(bytes.NewReader(shasums))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[1] == n+".zip" {
+			if fields[0] != hash {
+				return nil, fmt.Errorf("%s.zip hash %s does not match %s", n, hash, fields[0])
+			}
+			return buf, nil
+		}
+	}
+	return nil, fmt.Errorf("SHASUMS256.txt is missing %s.zip", n)
+}
+
+// bunNodeShim creates a node link to the bun executable in the cache
+// directory, returning its path.
+func bunNodeShim(flags *Flags) (string, error) {
+	dir := filepath.Join(flags.Cache, "bun", "node")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	n := filepath.Join(dir, "node")
+	if runtime.GOOS == "windows" {
+		// symlinks require privileges on windows
+		n += ".exe"
+		return n, copyFile(n, flags.BunBin)
+	}
+	if err := os.Remove(n); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return n, os.Symlink(flags.BunBin, n)
 }
 
 var webfontRE = regexp.MustCompile(`\.(woff|woff2|ttf|svg|eot)$`)
 
 // installFontAwesome installs font awesome files.
-func installFontAwesome(flags *Flags, dist *pack.Pack) error {
-	v, assets, err := githubLatestAssets(flags, "FortAwesome/Font-Awesome", "fontawesome")
+//
+// When icons is not nil, the scss and webfonts are subset to only the named
+// icons.
+func installFontAwesome(flags *Flags, dist *pack.Pack, icons map[string]bool) error {
+	tag := flags.lock.pinned(flags, "fontawesome", flags.FontAwesomeVersion)
+	v, assets, err := githubReleaseAssets(flags, "FortAwesome/Font-Awesome", "fontawesome", tag)
 	if err != nil {
 		return err
 	}
@@ -288,6 +594,9 @@ func installFontAwesome(flags *Flags, dist *pack.Pack) error {
 	if err != nil {
 		return err
 	}
+	if err := flags.lock.resolve(flags, "fontawesome", v, sha256hex(buf)); err != nil {
+		return err
+	}
 	// remove and create build/fontawesome
 	dir := filepath.Join(flags.Build, "fontawesome")
 	if err := os.RemoveAll(dir); err != nil {
@@ -301,6 +610,8 @@ func installFontAwesome(flags *Flags, dist *pack.Pack) error {
 		return err
 	}
 	// extract and process
+	var webfonts []*zip.File
+	var codepoints []string
 	for _, z := range r.File {
 		switch {
 		case strings.HasPrefix(z.Name, n+"/scss/") && strings.HasSuffix(z.Name, ".scss"):
@@ -313,6 +624,11 @@ func installFontAwesome(flags *Flags, dist *pack.Pack) error {
 				return err
 			}
 			sbuf = bytes.Replace(sbuf, []byte("url("), []byte("asset("), -1)
+			if icons != nil {
+				var cps []string
+				sbuf, cps = subsetFontAwesomeScss(sbuf, icons)
+				codepoints = append(codepoints, cps...)
+			}
 			// prefix filename
 			bn := filepath.Base(z.Name)
 			if !strings.HasPrefix(bn, "_") && bn != "fontawesome.scss" {
@@ -326,21 +642,207 @@ func installFontAwesome(flags *Flags, dist *pack.Pack) error {
 				return err
 			}
 		case strings.HasPrefix(z.Name, n+"/webfonts/") && webfontRE.MatchString(z.Name):
-			fr, err := z.Open()
-			if err != nil {
-				return err
-			}
-			wbuf, err := ioutil.ReadAll(fr)
-			if err != nil {
-				return err
-			}
-			if err := dist.PackBytes("/webfonts/"+filepath.Base(z.Name), wbuf); err != nil {
-				return err
-			}
-			if err := fr.Close(); err != nil {
-				return err
+			webfonts = append(webfonts, z)
+		}
+	}
+	// write subset.js
+	if icons != nil {
+		if err := ioutil.WriteFile(filepath.Join(flags.Build, subsetJs), []byte(tplf(subsetJs)), 0644); err != nil {
+			return fmt.Errorf("could not write %s: %w", subsetJs, err)
+		}
+	}
+	// pack webfonts
+	for _, z := range webfonts {
+		fr, err := z.Open()
+		if err != nil {
+			return err
+		}
+		wbuf, err := ioutil.ReadAll(fr)
+		if err != nil {
+			return err
+		}
+		if err := fr.Close(); err != nil {
+			return err
+		}
+		if icons != nil && subsetFontRE.MatchString(z.Name) {
+			if wbuf, err = subsetFont(flags, filepath.Join(dir, "webfonts"), filepath.Base(z.Name), wbuf, codepoints); err != nil {
+				return fmt.Errorf("could not subset %s: %w", filepath.Base(z.Name), err)
 			}
 		}
+		if err := dist.PackBytes("/webfonts/"+filepath.Base(z.Name), wbuf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	// faVarRE matches fontawesome icon variable definitions.
+	faVarRE = regexp.MustCompile(`^\$fa-var-([a-z0-9-]+):\s*\\([0-9a-fA-F]+);`)
+	// faIconLineRE matches fontawesome icon map entries (v6+) and icon class
+	// definitions (v5).
+	faIconLineRE = regexp.MustCompile(`^\s*(?:"([a-z0-9-]+)":\s*\$fa-var-|\.#\{\$fa-css-prefix\}-([a-z0-9-]+):before)`)
+	// subsetFontRE matches the webfont formats that can be subset.
+	subsetFontRE = regexp.MustCompile(`\.(woff|woff2|ttf)$`)
+)
+
+// subsetFontAwesomeScss removes the icon map entries and icon class
+// definitions not in icons from a fontawesome scss file, returning the
+// modified file and the codepoints of any defined icons.
+func subsetFontAwesomeScss(buf []byte, icons map[string]bool) ([]byte, []string) {
+	var codepoints []string
+	lines := bytes.Split(buf, []byte("\n"))
+	out := lines[:0]
+	for _, line := range lines {
+		if m := faVarRE.FindSubmatch(line); m != nil && icons[string(m[1])] {
+			codepoints = append(codepoints, string(m[2]))
+		}
+		if m := faIconLineRE.FindSubmatch(line); m != nil && !icons[string(m[1])+string(m[2])] {
+			continue
+		}
+		out = append(out, line)
+	}
+	return bytes.Join(out, []byte("\n")), codepoints
+}
+
+// subsetFont subsets the font in buf to the passed codepoints using
+// subset.js, returning the subset font.
+func subsetFont(flags *Flags, dir, name string, buf []byte, codepoints []string) ([]byte, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	in, out := filepath.Join(dir, name), filepath.Join(dir, "subset."+name)
+	if err := ioutil.WriteFile(in, buf, 0644); err != nil {
+		return nil, err
+	}
+	if err := runSilent(flags, flags.NodeBin, filepath.Join(flags.Build, subsetJs), in, out, strings.Join(codepoints, ",")); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(out)
+}
+
+// isBerryPackageManager determines if the working directory's (or workspace
+// root's) package.json declares a yarn berry (v2+) packageManager.
+func isBerryPackageManager(flags *Flags) bool {
+	buf, err := ioutil.ReadFile(filepath.Join(packageRoot(flags), "package.json"))
+	if err != nil {
+		return false
+	}
+	var v struct {
+		PackageManager string `json:"packageManager"`
+	}
+	if err := json.Unmarshal(buf, &v); err != nil || !strings.HasPrefix(v.PackageManager, "yarn@") {
+		return false
+	}
+	ver := strings.TrimPrefix(v.PackageManager, "yarn@")
+	if i := strings.Index(ver, "+"); i != -1 {
+		ver = ver[:i]
+	}
+	if _, err := semver.NewVersion(ver); err != nil {
+		return false
+	}
+	return compareSemver(ver, berryConstraint)
+}
+
+// installYarnCorepack installs the yarn shim to the cache directory using the
+// corepack bundled with node, which resolves the version declared in the
+// working directory's package.json.
+func installYarnCorepack(flags *Flags) (string, string, error) {
+	corepackBin := filepath.Join(filepath.Dir(flags.NodeBin), "corepack")
+	yarnPath := filepath.Join(flags.Cache, "corepack")
+	binPath := filepath.Join(yarnPath, "yarn")
+	if runtime.GOOS == "windows" {
+		corepackBin += ".cmd"
+		binPath += ".cmd"
+	}
+	if !fileExists(corepackBin) {
+		return "", "", fmt.Errorf("node at %s does not include corepack", flags.Node)
+	}
+	if err := os.MkdirAll(yarnPath, 0755); err != nil {
+		return "", "", fmt.Errorf("could not create corepack directory: %w", err)
+	}
+	if err := run(flags, corepackBin, "enable", "--install-directory="+yarnPath, "yarn"); err != nil {
+		return "", "", fmt.Errorf("could not enable corepack yarn: %w", err)
+	}
+	return yarnPath, binPath, nil
+}
+
+// systemTool looks for the named tool on PATH, returning its directory and
+// binary when its version satisfies constraint and the wanted version.
+func systemTool(flags *Flags, name, constraint, want string) (string, string, bool) {
+	if flags.ForceDownload {
+		return "", "", false
+	}
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		return "", "", false
+	}
+	out, err := runCombined(flags, bin, "--version")
+	if err != nil {
+		return "", "", false
+	}
+	v, err := semver.NewVersion(strings.TrimPrefix(out, "v"))
+	switch {
+	case err != nil,
+		!compareSemver(v.String(), constraint),
+		want != "" && !matchVersionPrefix(v, want):
+		return "", "", false
+	}
+	// bin is expected to be in <dir>/bin, excepting node on windows
+	p, err := realpath.Realpath(bin)
+	if err != nil {
+		return "", "", false
+	}
+	dir := filepath.Dir(filepath.Dir(p))
+	if name == "node" && runtime.GOOS == "windows" {
+		dir = filepath.Dir(p)
+	}
+	// lock the version (system tools are not hashed, as their binaries differ
+	// across installs)
+	if err := flags.lock.resolve(flags, name, "v"+v.String(), ""); err != nil {
+		debugf(flags, "not using %s: %v", bin, err)
+		return "", "", false
+	}
+	infof(flags, "USING: %s (%s)", bin, v)
+	return dir, bin, true
+}
+
+// resolveConstraints resolves the semver constraints for node, yarn, and bun
+// from flags, the working directory's package.json engines, or the defaults.
+func resolveConstraints(flags *Flags) error {
+	var v struct {
+		Engines map[string]string `json:"engines"`
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(flags.Wd, "package.json")); err == nil {
+		_ = json.Unmarshal(buf, &v)
+	}
+	for _, z := range []struct {
+		name string
+		v    *string
+		def  string
+	}{
+		{"node", &flags.NodeConstraint, nodeConstraint},
+		{"yarn", &flags.YarnConstraint, yarnConstraint},
+		{"bun", &flags.BunConstraint, bunConstraint},
+	} {
+		if *z.v == "" {
+			*z.v = v.Engines[z.name]
+		}
+		if *z.v == "" {
+			*z.v = z.def
+		}
+		if _, err := semver.NewConstraint(*z.v); err != nil {
+			return fmt.Errorf("invalid %s constraint %q: %w", z.name, *z.v, err)
+		}
 	}
 	return nil
 }
+
+// systemWant returns the version a system tool must match: the explicitly
+// requested version, or the locked version for frozen builds.
+func systemWant(flags *Flags, name, requested string) string {
+	if requested == "" && flags.Frozen {
+		return flags.lock.locked(flags, name)
+	}
+	return requested
+}
@@ -0,0 +1,31 @@
+package gen
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kenshaw/assetgen/gen/sigs"
+)
+
+func TestMirrorVerifier(t *testing.T) {
+	tests := []struct {
+		key    string
+		sig    string
+		verify func(key, signed, sig []byte) error
+	}{
+		{"-----BEGIN PGP PUBLIC KEY BLOCK-----\n", ".sig", sigs.VerifyPGP},
+		{"\n  -----BEGIN PGP PUBLIC KEY BLOCK-----\n", ".sig", sigs.VerifyPGP},
+		{"-----BEGIN PUBLIC KEY-----\n", ".sig", sigs.VerifyCosign},
+		{"untrusted comment: minisign public key\nRWQ=\n", ".minisig", sigs.VerifyMinisign},
+		{"RWQ=", ".minisig", sigs.VerifyMinisign},
+	}
+	for i, test := range tests {
+		sig, verify := mirrorVerifier([]byte(test.key))
+		if sig != test.sig {
+			t.Errorf("test %d expected %q, got: %q", i, test.sig, sig)
+		}
+		if reflect.ValueOf(verify).Pointer() != reflect.ValueOf(test.verify).Pointer() {
+			t.Errorf("test %d expected a different verify func", i)
+		}
+	}
+}
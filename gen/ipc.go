@@ -2,17 +2,23 @@ package gen
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
-	"os/signal"
-	"strings"
-	"syscall"
+	"path/filepath"
+	"runtime"
+	"sync"
 )
 
 // IpcCallbackMap is a map of IPC callback handlers.
@@ -20,119 +26,321 @@ type IpcCallbackMap map[string]func(...interface{}) (interface{}, error)
 
 // IpcServer handles IPC based callbacks for child processes.
 type IpcServer struct {
-	sock string
-	m    IpcCallbackMap
-	logf func(string, ...interface{})
+	dir       string
+	transport string
+	addr      string
+	token     string
+	m         IpcCallbackMap
+	logf      func(string, ...interface{})
+	l         net.Listener
+	mu        sync.Mutex
 }
 
 // NewIpcServer creates a IPC server with the provided options and callback
 // map. Handles simple IPC calls for "list-functions" and "call" that will
 // provide the child process the ability to speak to the parent process.
+//
+// Each message must include the server's token (see Token), which is passed
+// to child processes as ASSETGEN_TOKEN.
+//
+// Messages are json, either newline delimited, or prefixed with their length
+// as a big endian uint32 for payloads containing large or binary data. The
+// framing is determined by the first message sent on a connection, and
+// responses use the same framing.
+//
+// The server listens on a unix socket, or a named pipe on Windows, unless
+// another transport is specified with WithIpcTransport.
 func NewIpcServer(m IpcCallbackMap, opts ...IpcServerOption) (*IpcServer, error) {
-	sock, err := ioutil.TempDir("", "assetgen-ipc-callback")
+	dir, err := ioutil.TempDir("", "assetgen-ipc-callback")
 	if err != nil {
 		return nil, err
 	}
-	sock += "/control.sock"
 	s := &IpcServer{
-		sock: sock,
-		m:    m,
+		dir: dir,
+		m:   m,
 	}
 	// apply opts
 	for _, o := range opts {
 		if err := o(s); err != nil {
+			os.RemoveAll(dir)
 			return nil, err
 		}
 	}
+	// determine address
+	switch s.transport {
+	case "", "auto":
+		s.transport = "unix"
+		if runtime.GOOS == "windows" {
+			s.transport = "pipe"
+		}
+	}
+	switch s.transport {
+	case "unix":
+		s.addr = filepath.Join(dir, "control.sock")
+	case "pipe":
+		s.addr = `\\.\pipe\` + filepath.Base(dir)
+	case "tcp":
+		s.addr = "127.0.0.1:0"
+	default:
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("invalid ipc transport %q", s.transport)
+	}
+	// generate token
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s.token = hex.EncodeToString(buf)
 	if s.logf == nil {
 		s.logf = log.Printf
 	}
 	return s, nil
 }
 
-// SocketPath returns the socket path for the server.
+// SocketPath returns the socket path for the server, or the tcp://host:port
+// address when listening on TCP.
+//
+// The TCP port is only known after the server has been started.
 func (s *IpcServer) SocketPath() string {
-	return s.sock
+	if s.transport == "tcp" {
+		return "tcp://" + s.addr
+	}
+	return s.addr
+}
+
+// Token returns the per-run secret that clients must send with each message.
+func (s *IpcServer) Token() string {
+	return s.token
+}
+
+// Close closes the server's listener, and removes the server's temporary
+// files.
+func (s *IpcServer) Close() error {
+	s.mu.Lock()
+	l := s.l
+	s.mu.Unlock()
+	if l != nil {
+		l.Close()
+	}
+	return os.RemoveAll(s.dir)
 }
 
 // Run runs the server.
 func (s *IpcServer) Run(ctxt context.Context) error {
-	ctxt, cancel := context.WithCancel(ctxt)
-	l, err := net.Listen("unix", s.sock)
+	var l net.Listener
+	var err error
+	switch s.transport {
+	case "pipe":
+		l, err = listenPipe(s.addr)
+	default:
+		l, err = net.Listen(s.transport, s.addr)
+	}
 	if err != nil {
 		return err
 	}
-	// sig handler
+	s.addr = l.Addr().String()
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+	// close the listener when the context is done, unblocking accept
 	go func() {
-		defer cancel()
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-		s.logf("caught signal: %s", <-sig)
+		<-ctxt.Done()
+		if err := ctxt.Err(); err != context.Canceled {
+			s.logf("error: %v", err)
+		}
+		l.Close()
 	}()
 	go func() {
 		defer l.Close()
 		for {
-			select {
-			default:
-				conn, err := l.Accept()
-				if err != nil {
-					s.logf("error: %w", err)
-					return
-				}
-				go s.handle(ctxt, conn)
-			case <-ctxt.Done():
-				if err := ctxt.Err(); err != nil && err != context.Canceled {
-					s.logf("error: %w", ctxt.Err())
+			conn, err := l.Accept()
+			if err != nil {
+				if ctxt.Err() == nil && !errors.Is(err, net.ErrClosed) {
+					s.logf("error: %v", err)
 				}
 				return
 			}
+			go s.handle(ctxt, conn)
 		}
 	}()
 	return nil
 }
 
 // handle handles incoming client connections.
+//
+// Connections are persistent, and each message is dispatched concurrently,
+// with the response carrying the message's id.
 func (s *IpcServer) handle(ctxt context.Context, conn net.Conn) error {
 	defer conn.Close()
-	sn := bufio.NewScanner(conn)
-	for {
+	// close the connection when the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
 		select {
 		case <-ctxt.Done():
-			return ctxt.Err()
-		default:
-			for sn.Scan() {
-				// decode
-				var v IpcMsg
-				if err := json.NewDecoder(strings.NewReader(sn.Text())).Decode(&v); err != nil {
-					s.logf("error decoding msg: %w", err)
-					return err
-				}
-				// handle request
-				ret := make(map[string]interface{}, 1)
-				switch v.Type {
-				case "list-functions":
-					var funcs []string
-					for fn := range s.m {
-						funcs = append(funcs, fn)
-					}
-					ret["result"] = funcs
-				case "call":
-					res, err := s.doCall(v)
-					if err != nil {
-						ret["error"] = err.Error()
-					} else {
-						ret["result"] = res
-					}
-				default:
-					ret["error"] = "unknown request type"
+			conn.Close()
+		case <-done:
+		}
+	}()
+	r := bufio.NewReader(conn)
+	// determine framing, skipping leading whitespace (the first byte of a
+	// valid length prefix is never whitespace, as messages are limited to
+	// ipcMaxMsgSize)
+	var b []byte
+	for {
+		var err error
+		b, err = r.Peek(1)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			if ctxt.Err() == nil {
+				s.logf("error reading from socket: %v", err)
+			}
+			return err
+		}
+		if !isIpcSpace(b[0]) {
+			break
+		}
+		r.ReadByte()
+	}
+	framed := b[0] != '{'
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	reply := func(ret map[string]interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := writeIpcMsg(conn, ret, framed); err != nil {
+			s.logf("error writing to socket: %v", err)
+		}
+	}
+	for {
+		buf, err := readIpcMsg(r, framed)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			if ctxt.Err() == nil {
+				s.logf("error reading from socket: %v", err)
+			}
+			return err
+		}
+		// decode
+		var v IpcMsg
+		if err := json.Unmarshal(buf, &v); err != nil {
+			s.logf("error decoding msg: %v", err)
+			return err
+		}
+		// check token
+		if subtle.ConstantTimeCompare([]byte(v.Token), []byte(s.token)) != 1 {
+			s.logf("rejected unauthenticated ipc call")
+			reply(map[string]interface{}{"id": v.ID, "error": "invalid token"})
+			return errors.New("invalid token")
+		}
+		// dispatch
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply(s.dispatch(v))
+		}()
+	}
+}
+
+// readIpcMsg reads a message from r, either length prefixed (when framed), or
+// newline delimited, reading at most ipcMaxMsgSize bytes.
+//
+// The message buffer grows as the message is read, rather than being
+// allocated from the (unauthenticated) length prefix.
+func readIpcMsg(r *bufio.Reader, framed bool) ([]byte, error) {
+	if !framed {
+		for {
+			var buf bytes.Buffer
+			for {
+				line, err := r.ReadSlice('\n')
+				buf.Write(line)
+				switch {
+				case buf.Len() > ipcMaxMsgSize:
+					return nil, fmt.Errorf("message exceeds %d bytes", ipcMaxMsgSize)
+				case err == bufio.ErrBufferFull:
+					continue
+				case err == io.EOF && len(bytes.TrimSpace(buf.Bytes())) != 0:
+					return nil, io.ErrUnexpectedEOF
+				case err != nil:
+					return nil, err
 				}
-				return json.NewEncoder(conn).Encode(ret)
+				break
 			}
-			if err := sn.Err(); err != nil && err != io.EOF {
-				s.logf("error reading from socket: %w", err)
+			// skip blank lines
+			if len(bytes.TrimSpace(buf.Bytes())) != 0 {
+				return buf.Bytes(), nil
 			}
 		}
 	}
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	if n > ipcMaxMsgSize {
+		return nil, fmt.Errorf("message length %d exceeds %d bytes", n, ipcMaxMsgSize)
+	}
+	var buf bytes.Buffer
+	switch c, err := buf.ReadFrom(io.LimitReader(r, int64(n))); {
+	case err != nil:
+		return nil, err
+	case c != int64(n):
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
+
+// isIpcSpace returns true when c is json whitespace.
+func isIpcSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// writeIpcMsg writes v as json to w, either length prefixed (when framed), or
+// newline delimited.
+func writeIpcMsg(w io.Writer, v interface{}, framed bool) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !framed {
+		_, err := w.Write(append(buf, '\n'))
+		return err
+	}
+	if len(buf) > ipcMaxMsgSize {
+		return fmt.Errorf("message exceeds %d bytes", ipcMaxMsgSize)
+	}
+	hdr := make([]byte, 4, 4+len(buf))
+	binary.BigEndian.PutUint32(hdr, uint32(len(buf)))
+	_, err = w.Write(append(hdr, buf...))
+	return err
+}
+
+// dispatch handles a request, returning the response.
+func (s *IpcServer) dispatch(v IpcMsg) map[string]interface{} {
+	ret := map[string]interface{}{"id": v.ID}
+	switch v.Type {
+	case "list-functions":
+		var funcs []string
+		for fn := range s.m {
+			funcs = append(funcs, fn)
+		}
+		ret["result"] = funcs
+	case "call":
+		res, err := s.doCall(v)
+		if err != nil {
+			ret["error"] = err.Error()
+		} else {
+			ret["result"] = res
+		}
+	default:
+		ret["error"] = "unknown request type"
+	}
+	return ret
 }
 
 // doCall passes calls to the callback map.
@@ -155,9 +363,31 @@ func (s *IpcServer) doCall(v IpcMsg) (interface{}, error) {
 // IpcMsg is a simple envelope for messages passed between the executing
 // javascript and the server.
 type IpcMsg struct {
+	ID     int64                  `json:"id"`
 	Type   string                 `json:"type"`
+	Token  string                 `json:"token,omitempty"`
 	Params map[string]interface{} `json:"params"`
 }
 
+// ipcMaxMsgSize is the maximum size of a IPC message.
+const ipcMaxMsgSize = 64 * 1024 * 1024
+
 // IpcServerOption is a IPC server option.
 type IpcServerOption func(*IpcServer) error
+
+// WithIpcTransport is a IPC server option to set the transport, one of "unix",
+// "pipe" (Windows named pipes), "tcp" (a random localhost port), or "auto".
+func WithIpcTransport(transport string) IpcServerOption {
+	return func(s *IpcServer) error {
+		s.transport = transport
+		return nil
+	}
+}
+
+// WithIpcLogf is a IPC server option to set the log func.
+func WithIpcLogf(logf func(string, ...interface{})) IpcServerOption {
+	return func(s *IpcServer) error {
+		s.logf = logf
+		return nil
+	}
+}
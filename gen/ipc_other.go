@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package gen
+
+import (
+	"errors"
+	"net"
+)
+
+// listenPipe listens on the named pipe.
+func listenPipe(string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only supported on windows")
+}
@@ -0,0 +1,197 @@
+package gen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewIpcServerCleanup(t *testing.T) {
+	tmp := os.Getenv("TMPDIR")
+	defer os.Setenv("TMPDIR", tmp)
+	tests := []struct {
+		opts []IpcServerOption
+		ok   bool
+	}{
+		{[]IpcServerOption{WithIpcTransport("tcp")}, true},
+		{[]IpcServerOption{WithIpcTransport("invalid")}, false},
+		{[]IpcServerOption{func(*IpcServer) error { return errors.New("invalid option") }}, false},
+	}
+	for i, test := range tests {
+		dir := t.TempDir()
+		os.Setenv("TMPDIR", dir)
+		s, err := NewIpcServer(nil, test.opts...)
+		switch {
+		case test.ok && err != nil:
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		case !test.ok && err == nil:
+			t.Fatalf("test %d expected error", i)
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		if test.ok {
+			if len(files) != 1 {
+				t.Errorf("test %d expected temp dir, got: %d files", i, len(files))
+			}
+			if err := s.Close(); err != nil {
+				t.Fatalf("test %d expected no error, got: %v", i, err)
+			}
+			if files, _ = ioutil.ReadDir(dir); len(files) != 0 {
+				t.Errorf("test %d expected temp dir removed on close, got: %d files", i, len(files))
+			}
+			continue
+		}
+		if len(files) != 0 {
+			t.Errorf("test %d expected temp dir removed, got: %d files", i, len(files))
+		}
+	}
+}
+
+func TestIpcMsg(t *testing.T) {
+	framed := func(s string) string {
+		hdr := make([]byte, 4)
+		binary.BigEndian.PutUint32(hdr, uint32(len(s)))
+		return string(hdr) + s
+	}
+	tests := []struct {
+		framed bool
+		s      string
+		exp    []string
+		err    error
+	}{
+		{false, "{\"a\":1}\n", []string{`{"a":1}` + "\n"}, io.EOF},
+		{false, "{\"a\":1}\n\n  \n{\"b\":2}\n", []string{`{"a":1}` + "\n", `{"b":2}` + "\n"}, io.EOF},
+		{false, "{\"a\":1}", nil, io.ErrUnexpectedEOF},
+		{false, "", nil, io.EOF},
+		{false, "\n\n", nil, io.EOF},
+		{false, "{\"a\":\"" + strings.Repeat("x", 8192) + "\"}\n", []string{`{"a":"` + strings.Repeat("x", 8192) + `"}` + "\n"}, io.EOF},
+		{true, framed(`{"a":1}`), []string{`{"a":1}`}, io.EOF},
+		{true, framed(`{"a":1}`) + framed("{\"b\":\n2}"), []string{`{"a":1}`, "{\"b\":\n2}"}, io.EOF},
+		{true, framed(`{"a":1}`)[:6], nil, io.ErrUnexpectedEOF},
+		{true, "\x00\x00", nil, io.ErrUnexpectedEOF},
+		{true, "\xff\xff\xff\xff", nil, errors.New("message length 4294967295 exceeds 67108864 bytes")},
+	}
+	for i, test := range tests {
+		r := bufio.NewReader(strings.NewReader(test.s))
+		var msgs []string
+		var err error
+		for {
+			var buf []byte
+			if buf, err = readIpcMsg(r, test.framed); err != nil {
+				break
+			}
+			msgs = append(msgs, string(buf))
+		}
+		if !reflect.DeepEqual(msgs, test.exp) {
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, msgs)
+		}
+		if err != test.err && err.Error() != test.err.Error() {
+			t.Errorf("test %d expected error %v, got: %v", i, test.err, err)
+		}
+	}
+	// round trip
+	for _, framed := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		exp := map[string]interface{}{"id": 1.0, "result": "a\nb"}
+		if err := writeIpcMsg(buf, exp, framed); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		b, err := readIpcMsg(bufio.NewReader(buf), framed)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if !reflect.DeepEqual(v, exp) {
+			t.Errorf("framed %t expected %v, got: %v", framed, exp, v)
+		}
+	}
+}
+
+func TestIpcServer(t *testing.T) {
+	s, err := NewIpcServer(IpcCallbackMap{
+		"add": func(v ...interface{}) (interface{}, error) {
+			var sum float64
+			for _, x := range v {
+				sum += x.(float64)
+			}
+			return sum, nil
+		},
+	}, WithIpcTransport("tcp"), WithIpcLogf(func(string, ...interface{}) {}))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Close()
+	ctxt, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctxt); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	call := func(framed bool, msgs ...IpcMsg) []map[string]interface{} {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(s.SocketPath(), "tcp://"))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var res []map[string]interface{}
+		for _, msg := range msgs {
+			if err := writeIpcMsg(conn, msg, framed); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			buf, err := readIpcMsg(r, framed)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			var v map[string]interface{}
+			if err := json.Unmarshal(buf, &v); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			res = append(res, v)
+		}
+		return res
+	}
+	token := s.Token()
+	tests := []struct {
+		msg IpcMsg
+		exp map[string]interface{}
+	}{
+		{IpcMsg{ID: 1, Type: "list-functions", Token: token}, map[string]interface{}{"id": 1.0, "result": []interface{}{"add"}}},
+		{IpcMsg{ID: 2, Type: "call", Token: token, Params: map[string]interface{}{"name": "add", "args": []interface{}{1, 2}}}, map[string]interface{}{"id": 2.0, "result": 3.0}},
+		{IpcMsg{ID: 3, Type: "call", Token: token, Params: map[string]interface{}{"name": "missing", "args": []interface{}{}}}, map[string]interface{}{"id": 3.0, "error": "invalid func name"}},
+		{IpcMsg{ID: 4, Type: "call", Token: token, Params: map[string]interface{}{"name": "add"}}, map[string]interface{}{"id": 4.0, "error": "missing args in call"}},
+		{IpcMsg{ID: 5, Type: "other", Token: token}, map[string]interface{}{"id": 5.0, "error": "unknown request type"}},
+		{IpcMsg{ID: 6, Type: "list-functions", Token: "invalid"}, map[string]interface{}{"id": 6.0, "error": "invalid token"}},
+	}
+	for _, framed := range []bool{false, true} {
+		for i, test := range tests {
+			if res := call(framed, test.msg); !reflect.DeepEqual(res[0], test.exp) {
+				t.Errorf("framed %t test %d expected %v, got: %v", framed, i, test.exp, res[0])
+			}
+		}
+		// persistent connection
+		var msgs []IpcMsg
+		for _, test := range tests[:5] {
+			msgs = append(msgs, test.msg)
+		}
+		for i, res := range call(framed, msgs...) {
+			if !reflect.DeepEqual(res, tests[i].exp) {
+				t.Errorf("framed %t persistent %d expected %v, got: %v", framed, i, tests[i].exp, res)
+			}
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package gen
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// listenPipe listens on the named pipe.
+func listenPipe(name string) (net.Listener, error) {
+	return winio.ListenPipe(name, nil)
+}
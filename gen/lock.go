@@ -0,0 +1,178 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// lock holds the tool versions resolved during a build, written to the
+// working directory as assetgen.lock.
+type lock struct {
+	Tools map[string]lockEntry `json:"tools"`
+	// used are the names of the tools resolved during the build, and their
+	// platform, for tools with platform specific archives.
+	used map[string]string
+	sync.Mutex
+}
+
+// lockEntry is a resolved tool version.
+type lockEntry struct {
+	Version string `json:"version"`
+	// Hash is the sha256 hash of the tool's retrieved archive, or of its
+	// installed package.
+	Hash string `json:"hash,omitempty"`
+	// Hashes are the sha256 hashes of the tool's retrieved archive for each
+	// platform, for tools with platform specific archives.
+	Hashes map[string]string `json:"hashes,omitempty"`
+}
+
+// lockPlatformTools are the tools with platform specific archives, previously
+// locked as <tool>-<platform>.
+var lockPlatformTools = []string{"node", "bun"}
+
+// loadLock loads the lock file from the working directory.
+func loadLock(flags *Flags) (*lock, error) {
+	l := &lock{
+		Tools: make(map[string]lockEntry),
+		used:  make(map[string]string),
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(flags.Wd, lockFile))
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return l, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, l); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %w", lockFile, err)
+	}
+	if l.Tools == nil {
+		l.Tools = make(map[string]lockEntry)
+	}
+	// fold platform entries into their tool's entry
+	for n, e := range l.Tools {
+		for _, t := range lockPlatformTools {
+			tool, ok := l.Tools[t]
+			if !strings.HasPrefix(n, t+"-") || !ok {
+				continue
+			}
+			if tool.Version == e.Version && e.Hash != "" {
+				if tool.Hashes == nil {
+					tool.Hashes = make(map[string]string)
+				}
+				tool.Hashes[strings.TrimPrefix(n, t+"-")] = e.Hash
+				l.Tools[t] = tool
+			}
+			delete(l.Tools, n)
+		}
+	}
+	return l, nil
+}
+
+// pinned returns the version to use for the named tool, using the explicitly
+// requested version, or the locked version when not upgrading.
+func (l *lock) pinned(flags *Flags, name, version string) string {
+	l.Lock()
+	defer l.Unlock()
+	if version != "" || (flags.YarnUpgrade && !flags.Frozen) {
+		return version
+	}
+	return l.Tools[name].Version
+}
+
+// locked returns the locked version for the named tool, or an empty string
+// when upgrading.
+func (l *lock) locked(flags *Flags, name string) string {
+	return l.pinned(flags, name, "")
+}
+
+// resolve records the resolved version and hash for the named tool. When hash
+// is empty (ie, the tool was previously extracted), the locked hash is kept for
+// the same version.
+//
+// When flags.Frozen is set, an error is returned if the resolved version or
+// hash differs from the lock.
+func (l *lock) resolve(flags *Flags, name, version, hash string) error {
+	return l.resolvePlatform(flags, name, "", version, hash)
+}
+
+// resolvePlatform records the resolved version and the platform's archive
+// hash for the named tool. The hashes of other platforms are kept for the
+// same version.
+//
+// When flags.Frozen is set, an error is returned if the resolved version
+// differs from the lock, or the hash differs from the platform's locked hash.
+// Platforms without a locked hash are accepted, as the lock may have been
+// written on another platform.
+func (l *lock) resolvePlatform(flags *Flags, name, platform, version, hash string) error {
+	l.Lock()
+	defer l.Unlock()
+	prev, ok := l.Tools[name]
+	prevHash := prev.Hash
+	if platform != "" {
+		prevHash = prev.Hashes[platform]
+	}
+	if hash == "" && prev.Version == version {
+		hash = prevHash
+	}
+	if flags.Frozen {
+		switch {
+		case !ok:
+			return fmt.Errorf("%s is not locked in %s", name, lockFile)
+		case prev.Version != version:
+			return fmt.Errorf("%s resolved to %s, but %s is locked in %s", name, version, prev.Version, lockFile)
+		case prevHash != "" && hash != "" && prevHash != hash:
+			return fmt.Errorf("%s %s hash %s does not match %s in %s", name, version, hash, prevHash, lockFile)
+		}
+	}
+	e := lockEntry{Version: version}
+	if prev.Version == version {
+		e = prev
+	}
+	switch {
+	case platform == "":
+		e.Hash = hash
+	case hash != "":
+		hashes := make(map[string]string, len(e.Hashes)+1)
+		for k, v := range e.Hashes {
+			hashes[k] = v
+		}
+		hashes[platform] = hash
+		e.Hashes = hashes
+	}
+	l.Tools[name] = e
+	if _, ok := l.used[name]; !ok || platform != "" {
+		l.used[name] = platform
+	}
+	return nil
+}
+
+// hash returns the locked hash for the named tool, or for the platform when
+// the tool has platform specific archives.
+func (e lockEntry) hash(platform string) string {
+	if h, ok := e.Hashes[platform]; ok {
+		return h
+	}
+	return e.Hash
+}
+
+// write writes the lock file to the working directory.
+//
+// The lock file is not modified when flags.Frozen is set.
+func (l *lock) write(flags *Flags) error {
+	if flags.Frozen {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	buf, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(flags.Wd, lockFile), append(buf, '\n'), 0644)
+}
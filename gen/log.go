@@ -0,0 +1,132 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is a log verbosity level.
+type LogLevel int
+
+// Log levels.
+const (
+	// LogQuiet logs nothing.
+	LogQuiet LogLevel = iota
+	// LogWarn logs only warnings.
+	LogWarn
+	// LogNormal logs warnings and informational messages.
+	LogNormal
+	// LogVerbose additionally logs executed commands.
+	LogVerbose
+	// LogDebug additionally logs debugging messages.
+	LogDebug
+)
+
+// ParseLogLevel parses a log level.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "quiet":
+		return LogQuiet, nil
+	case "warn":
+		return LogWarn, nil
+	case "normal":
+		return LogNormal, nil
+	case "verbose":
+		return LogVerbose, nil
+	case "debug":
+		return LogDebug, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q", s)
+}
+
+// String satisfies the fmt.Stringer interface.
+func (level LogLevel) String() string {
+	switch level {
+	case LogQuiet:
+		return "quiet"
+	case LogWarn:
+		return "warn"
+	case LogNormal:
+		return "normal"
+	case LogVerbose:
+		return "verbose"
+	case LogDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(level))
+}
+
+// Logger is the interface for a leveled logger.
+//
+// Messages are logged with the minimum level at which they should be shown,
+// so warnings are logged at LogWarn.
+type Logger interface {
+	Logf(level LogLevel, s string, v ...interface{})
+}
+
+// NewLogger creates a logger writing messages at or below level to w, using
+// the text or json format.
+func NewLogger(w io.Writer, level LogLevel, format string) (Logger, error) {
+	switch format {
+	case "", "text":
+		return &textLogger{
+			l:     log.New(w, "", log.LstdFlags),
+			level: level,
+		}, nil
+	case "json":
+		return &jsonLogger{
+			enc:   json.NewEncoder(w),
+			level: level,
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", format)
+}
+
+// textLogger is a leveled logger writing plain text.
+type textLogger struct {
+	l     *log.Logger
+	level LogLevel
+}
+
+// Logf satisfies the Logger interface.
+func (l *textLogger) Logf(level LogLevel, s string, v ...interface{}) {
+	if level > l.level {
+		return
+	}
+	if level == LogWarn {
+		s = "WARNING: " + s
+	}
+	l.l.Printf(s, v...)
+}
+
+// jsonLogger is a leveled logger writing a json object per message.
+type jsonLogger struct {
+	enc   *json.Encoder
+	level LogLevel
+	sync.Mutex
+}
+
+// Logf satisfies the Logger interface.
+func (l *jsonLogger) Logf(level LogLevel, s string, v ...interface{}) {
+	if level > l.level {
+		return
+	}
+	typ := map[LogLevel]string{
+		LogWarn:    "warn",
+		LogNormal:  "info",
+		LogVerbose: "command",
+		LogDebug:   "debug",
+	}[level]
+	l.Lock()
+	defer l.Unlock()
+	_ = l.enc.Encode(struct {
+		Time  time.Time `json:"time"`
+		Level string    `json:"level"`
+		Msg   string    `json:"msg"`
+	}{time.Now(), typ, fmt.Sprintf(s, v...)})
+}
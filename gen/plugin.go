@@ -0,0 +1,95 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// pluginsDir is the build directory for plugin step manifests.
+const pluginsDir = "plugins"
+
+// pluginStep is the step manifest passed to a plugin.
+type pluginStep struct {
+	Name      string   `json:"name"`
+	Args      []string `json:"args"`
+	Wd        string   `json:"wd"`
+	Assets    string   `json:"assets"`
+	Build     string   `json:"build"`
+	Dist      string   `json:"dist"`
+	Env       string   `json:"env"`
+	UrlPrefix string   `json:"urlPrefix"`
+}
+
+// plugin is the script handler to add a step running the external plugin
+// executable name with args. Names containing a path separator are relative
+// to the script's directory, otherwise the executable is searched for in the
+// node_modules/.bin directories and PATH.
+//
+// Plugins speak the IPC protocol on the socket passed as ASSETGEN_SOCK (with
+// ASSETGEN_TOKEN), and can pack files with the pack-file and pack-bytes
+// calls. The step manifest (the build's directories and settings) is written
+// as json to the file passed as ASSETGEN_STEP.
+func (s *Script) plugin(name string, args ...string) error {
+	bin := name
+	if strings.ContainsAny(name, `/\`) {
+		bin = filepath.Join(s.dir, filepath.FromSlash(name))
+		if fi, err := os.Stat(bin); err != nil || fi.IsDir() {
+			return fmt.Errorf("plugin %s is not an executable", bin)
+		}
+	}
+	st := pluginStep{
+		Name:      strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)),
+		Args:      args,
+		Wd:        s.flags.Wd,
+		Assets:    s.flags.Assets,
+		Dist:      s.flags.Dist,
+		Env:       s.flags.Env,
+		UrlPrefix: s.flags.UrlPrefix,
+	}
+	s.exec = append(s.exec, step{
+		name: "plugin(" + name + ")",
+		run: func(dist *pack.Pack) error {
+			st.Build = s.flags.Build
+			if d := s.distOf(dist); d != nil {
+				st.Dist, st.UrlPrefix = d.dist, d.urlPrefix
+			}
+			buf, err := json.MarshalIndent(st, "", "  ")
+			if err != nil {
+				return err
+			}
+			manifest := filepath.Join(s.flags.Build, pluginsDir, st.Name+".json")
+			if err := os.MkdirAll(filepath.Dir(manifest), 0755); err != nil {
+				return fmt.Errorf("could not create %s directory: %w", pluginsDir, err)
+			}
+			if err := ioutil.WriteFile(manifest, buf, 0644); err != nil {
+				return fmt.Errorf("could not write step manifest for plugin %s: %w", name, err)
+			}
+			return s.runPlugin(bin, manifest, args)
+		},
+		plan: func() ([]string, []string, error) {
+			return []string{formatCommand(bin, args...)}, nil, nil
+		},
+	})
+	return nil
+}
+
+// runPlugin runs the plugin bin with args, passing the step manifest as
+// ASSETGEN_STEP.
+func (s *Script) runPlugin(bin, manifest string, args []string) error {
+	commandf(s.flags, bin, args...)
+	stderr := newTailBuffer(stepErrorMaxOutput)
+	cmd := newCmd(s.flags, bin, args...)
+	cmd.Env = append(cmd.Env, "ASSETGEN_STEP="+manifest)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, io.MultiWriter(os.Stderr, stderr)
+	if err := cmd.Run(); err != nil {
+		return newStepError(bin, args, stderr.Bytes(), err)
+	}
+	return nil
+}
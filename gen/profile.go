@@ -0,0 +1,68 @@
+package gen
+
+import (
+	"fmt"
+)
+
+// profile is a build profile, toggling build behaviors.
+type profile struct {
+	// sourceMaps toggles inlining source maps in the packed js, and in the
+	// packed css when not minified.
+	sourceMaps bool
+	// minify toggles minifying js and css.
+	minify bool
+	// optimizeImages toggles optimizing images with imagemin.
+	optimizeImages bool
+	// debug toggles debug mode in the generated assets.go (ie, assets are
+	// not cached by clients).
+	debug bool
+}
+
+// defaultProfile returns the default build profile for the environment.
+func defaultProfile(env string) profile {
+	if env == productionEnv {
+		return profile{
+			minify:         true,
+			optimizeImages: true,
+		}
+	}
+	return profile{
+		sourceMaps: true,
+		debug:      true,
+	}
+}
+
+// env is the script handler returning the build environment (production or
+// development).
+func (s *Script) env() string {
+	return s.flags.Env
+}
+
+// setProfile is the script handler to override the build profile defaults
+// for the build environment, with a map of the options:
+//
+//	sourceMaps - inline source maps
+//	minify - minify js and css
+//	optimizeImages - optimize images
+//	debug - debug mode assets.go
+func (s *Script) setProfile(v map[interface{}]interface{}) error {
+	for k, v := range v {
+		z, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("invalid profile() option %v value type %T", k, v)
+		}
+		switch k {
+		case "sourceMaps":
+			s.prof.sourceMaps = z
+		case "minify":
+			s.prof.minify = z
+		case "optimizeImages":
+			s.prof.optimizeImages = z
+		case "debug":
+			s.prof.debug = z
+		default:
+			return fmt.Errorf("invalid profile() option %v", k)
+		}
+	}
+	return nil
+}
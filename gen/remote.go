@@ -0,0 +1,115 @@
+package gen
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// remoteDir is the cache directory for remote assets.
+const remoteDir = "remote"
+
+// remoteAsset is a remote asset.
+type remoteAsset struct {
+	urlstr string
+	name   string
+	sha256 string
+	ttl    time.Duration
+}
+
+// remote is the script handler to retrieve the remote asset urlstr and pack it
+// as name. Retrieved assets are cached for the -ttl duration, and can be
+// pinned to a checksum with the "sha256" option, in which case the cached
+// asset does not expire:
+//
+//	remote("https://cdn.example.com/lib.js", "js/lib.js", {"sha256": "..."})
+//
+// Other options are "ttl" (a duration, such as "24h"). Public S3 (s3://) and
+// GCS (gs://) urls are retrieved using their respective https endpoints.
+func (s *Script) remote(urlstr, name string, v ...interface{}) error {
+	a := remoteAsset{
+		name: "/" + strings.TrimLeft(path.Clean("/"+name), "/"),
+		ttl:  s.flags.Ttl,
+	}
+	var err error
+	if a.urlstr, err = remoteURL(urlstr); err != nil {
+		return err
+	}
+	var ttl bool
+	for _, z := range v {
+		opts, ok := z.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("unknown type passed to remote(): %T", z)
+		}
+		for k, v := range opts {
+			switch k {
+			case "sha256":
+				a.sha256 = strings.ToLower(forceString(v))
+			case "ttl":
+				if a.ttl, err = time.ParseDuration(forceString(v)); err != nil {
+					return fmt.Errorf("invalid remote() ttl %v: %w", v, err)
+				}
+				ttl = true
+			default:
+				return fmt.Errorf("invalid remote() option %v", k)
+			}
+		}
+	}
+	// pinned assets do not expire
+	if a.sha256 != "" && !ttl {
+		a.ttl = 0
+	}
+	s.exec = append(s.exec, step{
+		name: "remote(" + strings.TrimPrefix(a.name, "/") + ")",
+		run: func(dist *pack.Pack) error {
+			buf, err := s.getRemote(a)
+			if err != nil {
+				return err
+			}
+			return dist.PackBytes(a.name, buf)
+		},
+		plan: func() ([]string, []string, error) {
+			return []string{"retrieve " + a.urlstr}, []string{a.name}, nil
+		},
+	})
+	return nil
+}
+
+// getRemote retrieves the remote asset, verifying its checksum when pinned.
+func (s *Script) getRemote(a remoteAsset) ([]byte, error) {
+	key := fmt.Sprintf("%x", md5.Sum([]byte(a.urlstr)))
+	buf, err := getAndCache(s.flags, a.urlstr, a.ttl, false, remoteDir, key[:2], key+path.Ext(a.name))
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve %s: %w", a.urlstr, err)
+	}
+	if a.sha256 != "" {
+		if hash := sha256hex(buf); hash != a.sha256 {
+			return nil, fmt.Errorf("%s: sha256 checksum mismatch: expected %s, got %s", a.urlstr, a.sha256, hash)
+		}
+	}
+	return buf, nil
+}
+
+// remoteURL returns the retrieval url for urlstr, converting s3:// and gs://
+// urls to their https endpoints.
+func remoteURL(urlstr string) (string, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote() url %q: %w", urlstr, err)
+	}
+	switch {
+	case u.Host == "":
+	case u.Scheme == "http" || u.Scheme == "https":
+		return urlstr, nil
+	case u.Scheme == "s3":
+		return "https://" + u.Host + ".s3.amazonaws.com" + u.EscapedPath(), nil
+	case u.Scheme == "gs":
+		return "https://storage.googleapis.com/" + u.Host + u.EscapedPath(), nil
+	}
+	return "", fmt.Errorf("invalid remote() url %q", urlstr)
+}
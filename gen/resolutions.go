@@ -0,0 +1,121 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// setResolution is the script handler to pin the version of a transitive
+// node package dependency.
+func (s *Script) setResolution(name, ver string) error {
+	if name == "" || ver == "" {
+		return fmt.Errorf("invalid resolutions(%q, %q)", name, ver)
+	}
+	for i, d := range s.resolutions {
+		if d.name == name {
+			s.resolutions[i].ver = ver
+			return nil
+		}
+	}
+	s.resolutions = append(s.resolutions, dep{name, ver})
+	return nil
+}
+
+// resolutionsKey returns the package.json key for pinned transitive
+// dependencies for the package manager.
+func (s *Script) resolutionsKey() string {
+	if s.flags.bun {
+		return "overrides"
+	}
+	return "resolutions"
+}
+
+// writeResolutions merges the script's resolutions into package.json,
+// returning true when package.json was changed.
+func (s *Script) writeResolutions() (bool, error) {
+	if len(s.resolutions) == 0 {
+		return false, nil
+	}
+	// resolutions are only applied from the workspace root
+	name := filepath.Join(packageRoot(s.flags), "package.json")
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+	key := s.resolutionsKey()
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return false, errors.New("invalid package.json")
+	}
+	m := make(map[string]interface{})
+	if raw, ok := v[key]; ok {
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return false, fmt.Errorf("invalid package.json %s: %w", key, err)
+		}
+	}
+	var changed bool
+	for _, d := range s.resolutions {
+		if m[d.name] != d.ver {
+			m[d.name], changed = d.ver, true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if buf, err = setJSONKey(buf, key, m); err != nil {
+		return false, err
+	}
+	return true, ioutil.WriteFile(name, buf, 0644)
+}
+
+// setJSONKey sets the top-level key of the json object in buf to v, keeping
+// the order and formatting of the other keys.
+func setJSONKey(buf []byte, key string, v interface{}) ([]byte, error) {
+	val, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("not a json object")
+	}
+	var n int
+	for ; dec.More(); n++ {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start := dec.InputOffset()
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		if tok != key {
+			continue
+		}
+		// replace existing value
+		end := dec.InputOffset()
+		return concatBytes(buf[:start], []byte(": "), val, buf[end:]), nil
+	}
+	// append key
+	end := dec.InputOffset()
+	k, _ := json.Marshal(key)
+	sep := ","
+	if n == 0 {
+		sep = ""
+	}
+	return concatBytes(bytes.TrimRight(buf[:end], " \t\r\n"), []byte(sep+"\n  "), k, []byte(": "), val, []byte("\n"), bytes.TrimLeft(buf[end:], " \t\r\n")), nil
+}
+
+// concatBytes concatenates v.
+func concatBytes(v ...[]byte) []byte {
+	var buf []byte
+	for _, b := range v {
+		buf = append(buf, b...)
+	}
+	return buf
+}
@@ -0,0 +1,138 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// root is an additional assets directory.
+type root struct {
+	dir    string
+	prefix string
+}
+
+// parseRoots parses the additional assets directories in flags.Roots, a comma
+// separated list of dir[=prefix] entries. Directories may contain glob
+// patterns, and are relative to the working directory. When not specified,
+// the prefix is the directory's path relative to the working directory, less
+// any trailing assets component (ie, admin/assets has the prefix admin).
+func parseRoots(flags *Flags) ([]root, error) {
+	var roots []root
+	dirs, prefixes := make(map[string]bool), make(map[string]bool)
+	for _, z := range strings.Split(flags.Roots, ",") {
+		if z = strings.TrimSpace(z); z == "" {
+			continue
+		}
+		pat, prefix, explicit := z, "", false
+		if i := strings.Index(z, "="); i != -1 {
+			pat, prefix, explicit = z[:i], z[i+1:], true
+		}
+		if !filepath.IsAbs(pat) {
+			pat = filepath.Join(flags.Wd, pat)
+		}
+		matches, err := filepath.Glob(pat)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("invalid root %q: %w", z, err)
+		case len(matches) == 0:
+			return nil, fmt.Errorf("root %q does not match any directory", z)
+		case explicit && len(matches) != 1:
+			return nil, fmt.Errorf("root %q with a prefix must match a single directory", z)
+		}
+		for _, dir := range matches {
+			// skip files matched by globs
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				continue
+			}
+			switch {
+			case !isParentDir(flags.Wd, dir):
+				return nil, fmt.Errorf("root %s must be subdirectory of working directory", dir)
+			case dir == flags.Assets:
+				return nil, fmt.Errorf("root %s cannot be the assets directory", dir)
+			case dirs[dir]:
+				return nil, fmt.Errorf("root %s specified more than once", dir)
+			}
+			dirs[dir] = true
+			p := prefix
+			if !explicit {
+				rel, err := filepath.Rel(flags.Wd, dir)
+				if err != nil {
+					return nil, err
+				}
+				if filepath.Base(rel) == assetsDir && filepath.Dir(rel) != "." {
+					rel = filepath.Dir(rel)
+				}
+				p = filepath.ToSlash(rel)
+			}
+			p = strings.Trim(path.Clean("/"+p), "/")
+			if prefixes[p] {
+				return nil, fmt.Errorf("root %s has duplicate prefix %q", dir, p)
+			}
+			prefixes[p] = true
+			roots = append(roots, root{dir: dir, prefix: p})
+		}
+	}
+	return roots, nil
+}
+
+// rootFlags returns a copy of flags for building the additional assets root
+// r, with its own script, build directory and environment, and packing to the
+// prefix directory in the dist directory.
+func rootFlags(flags *Flags, i int, r root) *Flags {
+	f := *flags
+	f.Assets, f.Script = r.dir, filepath.Join(r.dir, scriptName)
+	f.Dist = filepath.Join(flags.Dist, filepath.FromSlash(r.prefix))
+	f.Build = filepath.Join(flags.Build, "roots", strconv.Itoa(i))
+	f.root = r.prefix
+	f.path = append([]string(nil), flags.path...)
+	f.env = append([]string(nil), flags.env...)
+	f.downloads, f.timings, f.deps, f.goDirs = nil, nil, nil, nil
+	return &f
+}
+
+// loadRoots loads the scripts for the additional assets directories.
+func loadRoots(flags *Flags) ([]*Script, error) {
+	var scripts []*Script
+	for i, r := range flags.roots {
+		f := rootFlags(flags, i, r)
+		s, err := LoadScript(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load script %s: %w", f.Script, err)
+		}
+		scripts = append(scripts, s)
+	}
+	return scripts, nil
+}
+
+// buildRoot runs the script for an additional assets root, merging the packed
+// files into dist.
+func buildRoot(flags *Flags, dist *pack.Pack, s *Script) error {
+	for _, dir := range []string{s.flags.Build, s.flags.Dist} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("unable to create %s: %w", dir, err)
+		}
+	}
+	sub, err := pack.NewBase(s.flags.Dist, packOptions(s.flags, pack.WithPrefix(s.flags.root))...)
+	if err != nil {
+		return fmt.Errorf("unable to create dist: %w", err)
+	}
+	err = s.run(sub)
+	// collect downloads, timings, deps, and generated packages
+	flags.downloads = append(flags.downloads, s.flags.downloads...)
+	flags.deps = append(flags.deps, s.flags.deps...)
+	flags.goDirs = append(flags.goDirs, s.flags.goDirs...)
+	for _, t := range s.flags.timings {
+		flags.timings = append(flags.timings, timing{name: s.flags.root + ": " + t.name, d: t.d})
+	}
+	if err != nil {
+		return err
+	}
+	dist.Merge(sub)
+	return nil
+}
@@ -0,0 +1,64 @@
+package gen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/realpath"
+)
+
+func TestParseRoots(t *testing.T) {
+	dir, err := realpath.Realpath(t.TempDir())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	wd := filepath.Join(dir, "wd")
+	for _, d := range []string{"assets", "admin/assets", "docs", "sites/a", "sites/b", "sites/b/assets"} {
+		if err := os.MkdirAll(filepath.Join(wd, filepath.FromSlash(d)), 0o755); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "outside"), 0o755); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(wd, "sites", "c"), nil, 0o644); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		roots string
+		exp   []root
+		err   string
+	}{
+		{"", nil, ""},
+		{" , ", nil, ""},
+		{"admin/assets", []root{{filepath.Join(wd, "admin", "assets"), "admin"}}, ""},
+		{"docs=/help/", []root{{filepath.Join(wd, "docs"), "help"}}, ""},
+		{"docs, admin/assets=a/b", []root{{filepath.Join(wd, "docs"), "docs"}, {filepath.Join(wd, "admin", "assets"), "a/b"}}, ""},
+		{"sites/*", []root{{filepath.Join(wd, "sites", "a"), "sites/a"}, {filepath.Join(wd, "sites", "b"), "sites/b"}}, ""},
+		{filepath.Join(wd, "docs"), []root{{filepath.Join(wd, "docs"), "docs"}}, ""},
+		{"missing", nil, "does not match any directory"},
+		{"sites/*=x", nil, "must match a single directory"},
+		{"[", nil, "invalid root"},
+		{"../outside", nil, "must be subdirectory of working directory"},
+		{"assets", nil, "cannot be the assets directory"},
+		{"docs,docs=x", nil, "specified more than once"},
+		{"docs,admin/assets=docs", nil, "duplicate prefix"},
+		{"sites/b/assets,sites/b=sites/b", nil, "duplicate prefix"},
+	}
+	for i, test := range tests {
+		flags := &Flags{Wd: wd, Assets: filepath.Join(wd, "assets"), Roots: test.roots}
+		roots, err := parseRoots(flags)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("test %d expected no error, got: %v", i, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("test %d expected error containing %q, got: %v", i, test.err, err)
+		case test.err == "" && !reflect.DeepEqual(roots, test.exp):
+			t.Errorf("test %d expected %v, got: %v", i, test.exp, roots)
+		}
+	}
+}
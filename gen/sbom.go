@@ -0,0 +1,259 @@
+package gen
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sbomComponent is a CycloneDX component.
+type sbomComponent struct {
+	Type    string        `json:"type"`
+	Ref     string        `json:"bom-ref,omitempty"`
+	Group   string        `json:"group,omitempty"`
+	Name    string        `json:"name"`
+	Version string        `json:"version,omitempty"`
+	Purl    string        `json:"purl,omitempty"`
+	Hashes  []sbomHash    `json:"hashes,omitempty"`
+	License []sbomLicense `json:"licenses,omitempty"`
+}
+
+// sbomHash is a CycloneDX hash.
+type sbomHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// sbomLicense is a CycloneDX license expression.
+type sbomLicense struct {
+	Expression string `json:"expression"`
+}
+
+// writeSbom writes a CycloneDX sbom for the tools and node packages used by
+// the build to the reports directory.
+//
+// The sbom does not include a timestamp or serial number, so that it only
+// changes when the tools or node packages change.
+func writeSbom(flags *Flags) error {
+	tools := sbomTools(flags)
+	pkgs, err := sbomPackages(flags)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(flags.Wd)
+	if buf, err := ioutil.ReadFile(filepath.Join(flags.Wd, "package.json")); err == nil {
+		var v struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(buf, &v); err == nil && v.Name != "" {
+			name = v.Name
+		}
+	}
+	bom := map[string]interface{}{
+		"bomFormat":   "CycloneDX",
+		"specVersion": "1.5",
+		"version":     1,
+		"metadata": map[string]interface{}{
+			"tools": map[string]interface{}{
+				"components": []sbomComponent{{Type: "application", Name: "assetgen", Version: buildVersion()}},
+			},
+			"component": sbomComponent{Type: "application", Name: name},
+		},
+		"components": append(tools, pkgs...),
+	}
+	buf, err := json.MarshalIndent(bom, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeChanged(filepath.Join(flags.reports, sbomFile), append(buf, '\n'))
+}
+
+// sbomTools returns the sbom components for the tools used by the build,
+// with the sha256 hash of the tool's retrieved archive, when available.
+func sbomTools(flags *Flags) []sbomComponent {
+	flags.lock.Lock()
+	defer flags.lock.Unlock()
+	versions := make(map[string]string)
+	for n, v := range flags.versions {
+		versions[n] = v
+	}
+	hashes := make(map[string]string)
+	for n, platform := range flags.lock.used {
+		e := flags.lock.Tools[n]
+		switch v, ok := versions[n]; {
+		case !ok:
+			versions[n] = strings.TrimPrefix(e.Version, "v")
+		case v != strings.TrimPrefix(e.Version, "v"):
+			continue
+		}
+		if h := e.hash(platform); h != "" {
+			hashes[n] = h
+		}
+	}
+	var components []sbomComponent
+	for n, v := range versions {
+		c := sbomComponent{
+			Type:    "application",
+			Ref:     "pkg:generic/" + n + "@" + v,
+			Name:    n,
+			Version: v,
+			Purl:    "pkg:generic/" + n + "@" + v,
+		}
+		if h := hashes[n]; h != "" {
+			c.Hashes = []sbomHash{{"SHA-256", h}}
+		}
+		components = append(components, c)
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+	return components
+}
+
+// sbomPackages returns the sbom components for the installed node packages,
+// with the sha512 hash of the package's tarball from yarn.lock, when
+// available.
+func sbomPackages(flags *Flags) ([]sbomComponent, error) {
+	integrity, err := yarnLockIntegrity(filepath.Join(packageRoot(flags), "yarn.lock"))
+	if err != nil {
+		return nil, err
+	}
+	if !fileExists(flags.NodeModules) {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var components []sbomComponent
+	err = filepath.Walk(flags.NodeModules, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() && strings.HasPrefix(fi.Name(), ".") && n != flags.NodeModules:
+			return filepath.SkipDir
+		case fi.IsDir() || fi.Name() != "package.json":
+			return nil
+		}
+		// only package.json directly in a package directory
+		dir := filepath.Dir(n)
+		parent := filepath.Dir(dir)
+		if strings.HasPrefix(filepath.Base(parent), "@") {
+			parent = filepath.Dir(parent)
+		}
+		if filepath.Base(parent) != nodeModulesDir {
+			return nil
+		}
+		buf, err := ioutil.ReadFile(n)
+		if err != nil {
+			return err
+		}
+		var v struct {
+			Name      string      `json:"name"`
+			Version   string      `json:"version"`
+			License   interface{} `json:"license"`
+			Integrity string      `json:"_integrity"`
+		}
+		if err := json.Unmarshal(buf, &v); err != nil || v.Name == "" || v.Version == "" {
+			return nil
+		}
+		purl := "pkg:npm/" + strings.Replace(v.Name, "@", "%40", 1) + "@" + v.Version
+		if seen[purl] {
+			return nil
+		}
+		seen[purl] = true
+		c := sbomComponent{
+			Type:    "library",
+			Ref:     purl,
+			Name:    v.Name,
+			Version: v.Version,
+			Purl:    purl,
+		}
+		if i := strings.Index(v.Name, "/"); strings.HasPrefix(v.Name, "@") && i != -1 {
+			c.Group, c.Name = v.Name[:i], v.Name[i+1:]
+		}
+		if s, ok := v.License.(string); ok && s != "" {
+			c.License = []sbomLicense{{s}}
+		}
+		if v.Integrity == "" {
+			v.Integrity = integrity[v.Name+"@"+v.Version]
+		}
+		if h := sbomIntegrityHash(v.Integrity); h != nil {
+			c.Hashes = []sbomHash{*h}
+		}
+		components = append(components, c)
+		return nil
+	})
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Purl < components[j].Purl
+	})
+	return components, nil
+}
+
+// yarnLockIntegrity parses the integrity of each package version in a yarn
+// classic yarn.lock, keyed by name@version.
+func yarnLockIntegrity(name string) (map[string]string, error) {
+	m := make(map[string]string)
+	buf, err := ioutil.ReadFile(name)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return m, nil
+	case err != nil:
+		return nil, err
+	}
+	var pkg, version string
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case !strings.HasPrefix(line, " "):
+			// "name@range", name@range:
+			spec := strings.Trim(strings.SplitN(strings.TrimSuffix(line, ":"), ",", 2)[0], `"`)
+			pkg, version = spec, ""
+			if i := strings.LastIndex(spec, "@"); i > 0 {
+				pkg = spec[:i]
+			}
+		case strings.HasPrefix(line, "  version "):
+			version = strings.Trim(strings.TrimPrefix(line, "  version "), `"`)
+		case strings.HasPrefix(line, "  integrity "):
+			m[pkg+"@"+version] = strings.TrimPrefix(line, "  integrity ")
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// sbomIntegrityHash converts a subresource integrity string (ie,
+// sha512-<base64>) to a sbom hash.
+func sbomIntegrityHash(integrity string) *sbomHash {
+	for _, i := range strings.Fields(integrity) {
+		alg, v := "", ""
+		switch {
+		case strings.HasPrefix(i, "sha512-"):
+			alg, v = "SHA-512", strings.TrimPrefix(i, "sha512-")
+		case strings.HasPrefix(i, "sha256-"):
+			alg, v = "SHA-256", strings.TrimPrefix(i, "sha256-")
+		default:
+			continue
+		}
+		buf, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			continue
+		}
+		return &sbomHash{alg, hex.EncodeToString(buf)}
+	}
+	return nil
+}
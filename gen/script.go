@@ -3,23 +3,31 @@ package gen
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io/ioutil"
-	"log"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/gobwas/glob"
 	"github.com/kenshaw/assetgen/pack"
 	"github.com/mattn/anko/env"
 	"github.com/mattn/anko/vm"
-	qtcparser "github.com/valyala/quicktemplate/parser"
 	"github.com/yookoala/realpath"
 	"golang.org/x/sync/errgroup"
 )
@@ -37,6 +45,20 @@ type jsdep struct {
 	path string
 }
 
+// step is a script step.
+type step struct {
+	// name is the name of the step.
+	name string
+	// run runs the step.
+	run func(*pack.Pack) error
+	// plan returns the commands the step would run, and the files it would
+	// pack, without running the step.
+	plan func() ([]string, []string, error)
+	// dist is the named dist the step packs to, or empty for the default
+	// dist.
+	dist string
+}
+
 // Script wraps an assetgen script.
 type Script struct {
 	flags *Flags
@@ -44,12 +66,82 @@ type Script struct {
 	logf func(string, ...interface{})
 	// nodeDeps are node package dependencies.
 	nodeDeps []dep
+	// resolutions are the pinned versions of transitive node package
+	// dependencies.
+	resolutions []dep
 	// sassIncludes are sass include directories.
 	sassIncludes []string
+	// sassFiles are the sass entrypoints declared by the script. When empty,
+	// the top-level .scss files in the sass directory are compiled.
+	sassFiles []sassEntry
+	// cssModules is the css modules scoped class name pattern, or empty when
+	// css modules are disabled.
+	cssModules string
+	// cssClasses are the scoped class names of the compiled css modules, by
+	// module name and original class name.
+	cssClasses map[string]map[string]string
+	// npmPkgs are the npm packages included with npmjs, whose sass
+	// entrypoints are added to the sass include directories.
+	npmPkgs []string
+	// faSubset toggles subsetting fontawesome to the used icons.
+	faSubset bool
+	// faIcons are additional fontawesome icons to include when subsetting.
+	faIcons []string
+	// prof is the build profile.
+	prof profile
+	// defines are the build variables.
+	defines []define
+	// inlineMax is the maximum size of assets inlined as data uris.
+	inlineMax int64
+	// fontFormats are the formats legacy fonts are converted to.
+	fontFormats []string
+	// webfontPkgs is set when webfonts are extracted from npm packages.
+	webfontPkgs bool
+	// webfontSubset toggles subsetting the webfonts from npm packages.
+	webfontSubset bool
+	// webfontRanges are the codepoints to subset the webfonts to.
+	webfontRanges []string
+	// webfontTemplates toggles subsetting the webfonts to the characters
+	// used in the templates.
+	webfontTemplates bool
+	// tplEngine is the template engine.
+	tplEngine string
+	// tplOut is the template output package directory.
+	tplOut string
+	// tplPkg is the template output package name.
+	tplPkg string
+	// htmlminOpts are the html-minifier options, or nil when template
+	// minification is disabled.
+	htmlminOpts map[string]interface{}
+	// htmlminSkip are the templates not to minify.
+	htmlminSkip []glob.Glob
+	// ignores are the patterns for files not to pack or process.
+	ignores []*ignorePattern
+	// nestedIgnores are the patterns in the ignore files of the directories
+	// below the working and assets directories, loaded as they are walked.
+	nestedIgnores map[string][]*ignorePattern
+	// ignoresMu protects nestedIgnores.
+	ignoresMu sync.Mutex
+	// callbacks are the IPC callbacks registered by the script.
+	callbacks IpcCallbackMap
+	// callbackMu serializes calls into the script's callbacks.
+	callbackMu sync.Mutex
+	// dists are the named dists declared by the script.
+	dists []*namedDist
+	// distName is the named dist declared steps are added to.
+	distName string
+	// distStart is the first step not yet assigned a dist.
+	distStart int
+	// cur is the pack of the running step, used by the callbacks.
+	cur *pack.Pack
+	// curMu protects cur.
+	curMu sync.Mutex
 	// pre are the pre setup steps to be executed in order.
 	pre []func() error
 	// exec is the steps to be executed, in order.
-	exec []func(*pack.Pack) error
+	exec []step
+	// dir is the directory of the script being executed.
+	dir string
 	// post are the post setup steps to be executed in order.
 	post []func() error
 }
@@ -64,28 +156,37 @@ func LoadScript(flags *Flags) (*Script, error) {
 	// create
 	s := &Script{
 		flags: flags,
-		logf:  log.Printf,
+		logf: func(s string, v ...interface{}) {
+			infof(flags, s, v...)
+		},
+		htmlminOpts: make(map[string]interface{}),
+		tplEngine:   engineQtc,
+		fontFormats: []string{"woff2"},
+		prof:        defaultProfile(flags.Env),
 	}
-	// create scripting runtime
-	a := env.NewEnv()
-	// define vals
-	for _, z := range []struct {
-		n string
-		v interface{}
-	}{
-		{"staticDir", s.staticDir},
-		{"sassIncludeNodeModules", s.sassIncludeNodeModules},
-		{"sassInclude", s.sassInclude},
-		{"npmjs", s.npmjs},
-		{"js", s.js},
-	} {
-		if err := a.Define(z.n, z.v); err != nil {
-			return nil, fmt.Errorf("unable to define %s: %w", z.n, err)
-		}
+	for k, v := range htmlminDefaults {
+		s.htmlminOpts[k] = v
+	}
+	if s.ignores, err = loadIgnoreFiles(flags.Wd, flags.Assets); err != nil {
+		return nil, fmt.Errorf("unable to load ignore files: %w", err)
 	}
 	// execute
-	if _, err := vm.Execute(a, nil, string(buf)); err != nil {
-		return nil, fmt.Errorf("unable to execute script %s: %w", flags.Script, err)
+	if err := s.execute(flags.Script, buf); err != nil {
+		return nil, err
+	}
+	// execute nested scripts
+	nested, err := s.nestedScripts()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nested {
+		buf, err := ioutil.ReadFile(n)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load script %s: %w", n, err)
+		}
+		if err := s.execute(n, buf); err != nil {
+			return nil, err
+		}
 	}
 	// add directory handling steps
 	for _, d := range []struct {
@@ -96,13 +197,17 @@ func LoadScript(flags *Flags) (*Script, error) {
 		{"images", s.addImages},
 		{"sass", s.addSass},
 		{"templates", s.addTemplates},
+		{"emails", s.addEmails},
 	} {
 		// skip adding step if directory not present
 		dir := filepath.Join(flags.Assets, d.n)
 		fi, err := os.Stat(dir)
 		switch {
 		case err != nil && os.IsNotExist(err):
-			continue
+			// declared sass entrypoints do not require the sass directory
+			if d.n != "sass" || len(s.sassFiles) == 0 {
+				continue
+			}
 		case err != nil:
 			return nil, fmt.Errorf("could not stat %s: %w", dir, err)
 		case !fi.IsDir():
@@ -110,12 +215,114 @@ func LoadScript(flags *Flags) (*Script, error) {
 		}
 		d.f(d.n, dir)
 	}
+	// add registered steps for the top-level assets directory
+	if flags.root == "" {
+		if err := s.addRegisteredSteps(); err != nil {
+			return nil, err
+		}
+	}
+	s.addCssURLs()
 	return s, nil
 }
 
+// execute executes the script at path with contents buf in a new scripting
+// runtime, scoped to the script's directory.
+func (s *Script) execute(path string, buf []byte) error {
+	s.dir = filepath.Dir(path)
+	defer func() {
+		s.dir = ""
+	}()
+	// create scripting runtime
+	a := env.NewEnv()
+	// define vals
+	for _, z := range s.funcs() {
+		if err := a.Define(z.n, z.v); err != nil {
+			return fmt.Errorf("unable to define %s: %w", z.n, err)
+		}
+	}
+	// execute
+	if _, err := vm.Execute(a, nil, string(buf)); err != nil {
+		return fmt.Errorf("unable to execute script %s: %w", path, err)
+	}
+	// assign the script's remaining steps, and reset to the default dist
+	s.assignDists()
+	s.distName = ""
+	return nil
+}
+
+// scriptFunc is a script handler defined in the scripting runtime.
+type scriptFunc struct {
+	n string
+	v interface{}
+}
+
+// funcs returns the script handlers defined in the scripting runtime.
+func (s *Script) funcs() []scriptFunc {
+	return []scriptFunc{
+		{"env", s.env},
+		{"profile", s.setProfile},
+		{"define", s.define},
+		{"inlineAssets", s.inlineAssets},
+		{"staticDir", s.staticDir},
+		{"sassIncludeNodeModules", s.sassIncludeNodeModules},
+		{"sassInclude", s.sassInclude},
+		{"sass", s.sass},
+		{"cssModules", s.setCssModules},
+		{"resolutions", s.setResolution},
+		{"dist", s.dist},
+		{"npmjs", s.npmjs},
+		{"js", s.js},
+		{"fontawesomeSubset", s.fontawesomeSubset},
+		{"templateEngine", s.templateEngine},
+		{"templateOutput", s.templateOutput},
+		{"htmlmin", s.htmlmin},
+		{"htmlminSkip", s.htmlminSkipTemplates},
+		{"ignore", s.ignore},
+		{"remote", s.remote},
+		{"fontFormats", s.setFontFormats},
+		{"webfonts", s.webfonts},
+		{"webfontsSubset", s.webfontsSubset},
+		{"callback", s.callback},
+		{"plugin", s.plugin},
+	}
+}
+
+// nestedScripts returns the scripts in subdirectories of the assets
+// directory, skipping the dist directory, hidden directories, node_modules,
+// and additional assets roots.
+func (s *Script) nestedScripts() ([]string, error) {
+	skip := map[string]bool{
+		s.flags.Dist:        true,
+		s.flags.NodeModules: true,
+	}
+	for _, r := range s.flags.roots {
+		skip[r.dir] = true
+	}
+	for _, d := range s.flags.staged {
+		skip[d.dir] = true
+	}
+	var scripts []string
+	err := walk(s.flags, s.flags.Assets, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() && n != s.flags.Assets && (skip[n] || strings.HasPrefix(fi.Name(), ".") || fi.Name() == nodeModulesDir):
+			return filepath.SkipDir
+		case fi.IsDir() || fi.Name() != scriptName || filepath.Dir(n) == s.flags.Assets:
+			return nil
+		}
+		scripts = append(scripts, n)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not find nested scripts: %w", err)
+	}
+	return scripts, nil
+}
+
 // get retrieves src.
 func (s *Script) get(src string) ([]byte, error) {
-	res, err := http.Get(src)
+	res, err := s.flags.client.Get(src)
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve %q: %w", src, err)
 	}
@@ -125,12 +332,19 @@ func (s *Script) get(src string) ([]byte, error) {
 
 // concat is the script handler to concat one or more files.
 func (s *Script) concat(params ...interface{}) {
-	s.exec = append(s.exec, func(dist *pack.Pack) error {
-		return nil
+	s.exec = append(s.exec, step{
+		name: "concat",
+		run: func(dist *pack.Pack) error {
+			return nil
+		},
+		plan: func() ([]string, []string, error) {
+			return nil, nil, nil
+		},
 	})
 }
 
-// npmjs is the script handler that wraps a npm js include.
+// npmjs is the script handler that wraps a npm js include. The package's
+// sass entrypoint, if any, is added to the sass include directories.
 func (s *Script) npmjs(name string, v ...string) jsdep {
 	var ver, path string
 	if i := strings.Index(name, "@"); i != -1 {
@@ -139,6 +353,10 @@ func (s *Script) npmjs(name string, v ...string) jsdep {
 	if len(v) != 0 {
 		path = v[0]
 	}
+	s.nodeDeps = append(s.nodeDeps, dep{name, ver})
+	if !contains(s.npmPkgs, name) {
+		s.npmPkgs = append(s.npmPkgs, name)
+	}
 	return jsdep{
 		name: name,
 		ver:  ver,
@@ -148,36 +366,69 @@ func (s *Script) npmjs(name string, v ...string) jsdep {
 
 var staticDirNameRE = regexp.MustCompile("^[A-Za-z0-9]+$")
 
-// staticDir adds a static directory to the assets.
+// staticDir adds a static directory to the assets. The directory is relative
+// to the script's directory.
 func (s *Script) staticDir(name string) {
-	s.exec = append(s.exec, func(dist *pack.Pack) error {
-		if !staticDirNameRE.MatchString(name) {
-			return fmt.Errorf("invalid static dir name %q", name)
-		}
-		dir := filepath.Join(s.flags.Assets, name)
-		fi, err := os.Stat(dir)
-		switch {
-		case err != nil:
-			return fmt.Errorf("could not open static dir %q", dir)
-		case !fi.IsDir():
-			return fmt.Errorf("%q is not a directory", dir)
-		}
-		return filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
-			switch {
-			case err != nil:
+	dir := s.dir
+	rel, err := filepath.Rel(s.flags.Assets, filepath.Join(dir, name))
+	if err != nil {
+		rel = name
+	}
+	s.exec = append(s.exec, step{
+		name: "staticDir(" + filepath.ToSlash(rel) + ")",
+		run: func(dist *pack.Pack) error {
+			files, err := s.staticDirFiles(dir, name)
+			if err != nil {
 				return err
-			case fi.IsDir():
-				return nil
 			}
-			p, err := filepath.Rel(s.flags.Assets, n)
-			if err != nil {
-				return fmt.Errorf("%q not located within the project: %w", fi.Name(), err)
+			for _, p := range files {
+				if err := dist.PackFile(p, filepath.Join(s.flags.Assets, p)); err != nil {
+					return err
+				}
 			}
-			return dist.PackFile(p, n)
-		})
+			return nil
+		},
+		plan: func() ([]string, []string, error) {
+			files, err := s.staticDirFiles(dir, name)
+			return nil, files, err
+		},
 	})
 }
 
+// staticDirFiles returns the files in the named static directory in parent,
+// relative to the assets directory.
+func (s *Script) staticDirFiles(parent, name string) ([]string, error) {
+	if !staticDirNameRE.MatchString(name) {
+		return nil, fmt.Errorf("invalid static dir name %q", name)
+	}
+	dir := filepath.Join(parent, name)
+	fi, err := os.Stat(dir)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("could not open static dir %q", dir)
+	case !fi.IsDir():
+		return nil, fmt.Errorf("%q is not a directory", dir)
+	}
+	var files []string
+	err = walk(s.flags, dir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case s.ignored(n, fi):
+			return skipDir(fi)
+		case fi.IsDir():
+			return nil
+		}
+		p, err := filepath.Rel(s.flags.Assets, n)
+		if err != nil {
+			return fmt.Errorf("%q not located within the project: %w", fi.Name(), err)
+		}
+		files = append(files, p)
+		return nil
+	})
+	return files, err
+}
+
 // sassIncludeNodeModules adds the node modules path to the sass include search
 // path.
 func (s *Script) sassIncludeNodeModules() {
@@ -199,100 +450,482 @@ func (s *Script) sassInclude(name string, paths ...string) {
 	}
 }
 
+// fontawesomeSubset is the script handler to subset fontawesome to only the
+// icons used in the templates, sass, and js directories, along with any
+// additionally named icons.
+func (s *Script) fontawesomeSubset(icons ...string) {
+	s.nodeDeps = append(s.nodeDeps, dep{"subset-font", ""})
+	s.faSubset = true
+	for _, icon := range icons {
+		s.faIcons = append(s.faIcons, strings.TrimPrefix(icon, "fa-"))
+	}
+}
+
+// htmlmin is the script handler to configure the html-minifier options used
+// for templates, passed as a map of html-minifier's (camel case) option names
+// to values, overriding the defaults. Passing false disables minification.
+func (s *Script) htmlmin(v interface{}) error {
+	switch x := v.(type) {
+	case bool:
+		if !x {
+			s.htmlminOpts = nil
+		} else if s.htmlminOpts == nil {
+			s.htmlminOpts = make(map[string]interface{})
+			for k, v := range htmlminDefaults {
+				s.htmlminOpts[k] = v
+			}
+		}
+	case map[interface{}]interface{}:
+		if s.htmlminOpts == nil {
+			return errors.New("htmlmin() options passed after disabling minification")
+		}
+		for k, v := range x {
+			name, ok := k.(string)
+			if !ok {
+				return fmt.Errorf("invalid htmlmin() option %v", k)
+			}
+			switch v.(type) {
+			case bool, int64, float64, string, []interface{}:
+			default:
+				return fmt.Errorf("invalid htmlmin() option %s value type %T", name, v)
+			}
+			s.htmlminOpts[name] = v
+		}
+		if s.flags.HtmlMinifier == "go" {
+			if _, err := htmlminFragmentsRE(s.htmlminOpts, templateTagREs[s.tplEngine]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown type passed to htmlmin(): %T", v)
+	}
+	return nil
+}
+
+// htmlminSkipTemplates is the script handler to skip minifying templates
+// matching the glob patterns, relative to the templates directory.
+func (s *Script) htmlminSkipTemplates(patterns ...string) error {
+	for _, pattern := range patterns {
+		pat, err := glob.Compile(pattern, '/')
+		if err != nil {
+			return fmt.Errorf("invalid htmlminSkip() pattern %q: %w", pattern, err)
+		}
+		s.htmlminSkip = append(s.htmlminSkip, pat)
+	}
+	return nil
+}
+
+// minifyTemplate minifies the template buf with the configured html minifier.
+func (s *Script) minifyTemplate(buf []byte) ([]byte, error) {
+	tagRE := templateTagREs[s.tplEngine]
+	if s.flags.HtmlMinifier == "go" {
+		return htmlminGo(s.htmlminOpts, tagRE, buf)
+	}
+	return htmlmin(s.flags, htmlminParams(s.htmlminOpts, tagRE), buf)
+}
+
+// skipHtmlmin determines if the template n in dir should not be minified.
+func (s *Script) skipHtmlmin(dir, n string) bool {
+	if s.htmlminOpts == nil {
+		return true
+	}
+	rel, err := filepath.Rel(dir, n)
+	if err != nil {
+		return false
+	}
+	for _, pat := range s.htmlminSkip {
+		if pat.Match(filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// callback is the script handler to register a IPC callback with the
+// signature (ie, "myfunc($x, $y: 0)"), making fn available to sass and other
+// child processes.
+func (s *Script) callback(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	typ := v.Type()
+	if typ.Kind() != reflect.Func || typ.NumIn() < 1 || typ.In(0) != reflect.TypeOf((*context.Context)(nil)).Elem() || typ.NumOut() != 2 {
+		return fmt.Errorf("callback %s must be a script function", name)
+	}
+	if s.callbacks == nil {
+		s.callbacks = make(IpcCallbackMap)
+	}
+	s.callbacks[name] = func(args ...interface{}) (interface{}, error) {
+		if !typ.IsVariadic() && len(args) != typ.NumIn()-1 {
+			return nil, fmt.Errorf("%s expects %d args, got: %d", name, typ.NumIn()-1, len(args))
+		}
+		// script funcs take their args wrapped in a reflect.Value, except
+		// when variadic
+		in := []reflect.Value{reflect.ValueOf(context.Background())}
+		for i := range args {
+			arg := reflect.ValueOf(&args[i]).Elem()
+			if !typ.IsVariadic() {
+				arg = reflect.ValueOf(arg)
+			}
+			in = append(in, arg)
+		}
+		// the script runtime is not safe for concurrent use
+		s.callbackMu.Lock()
+		defer s.callbackMu.Unlock()
+		out := v.Call(in)
+		if err, ok := out[1].Interface().(reflect.Value); ok && err.IsValid() && !err.IsNil() {
+			return nil, fmt.Errorf("%s: %v", name, err.Interface())
+		}
+		res, _ := out[0].Interface().(reflect.Value)
+		if !res.IsValid() {
+			return nil, nil
+		}
+		return jsonValue(res.Interface()), nil
+	}
+	return nil
+}
+
+// jsonValue converts maps with non-string keys (as created by scripts) in v
+// to maps that can be encoded as json.
+func jsonValue(v interface{}) interface{} {
+	switch z := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(z))
+		for k, v := range z {
+			m[fmt.Sprintf("%v", k)] = jsonValue(v)
+		}
+		return m
+	case []interface{}:
+		for i := range z {
+			z[i] = jsonValue(z[i])
+		}
+	}
+	return v
+}
+
+// faNameRE matches fontawesome class names.
+var faNameRE = regexp.MustCompile(`\bfa-([a-z0-9]+(?:-[a-z0-9]+)*)`)
+
+// usedFontAwesomeIcons returns the fontawesome names used in the templates,
+// sass, and js directories.
+func (s *Script) usedFontAwesomeIcons() (map[string]bool, error) {
+	icons := make(map[string]bool)
+	for _, icon := range s.faIcons {
+		icons[icon] = true
+	}
+	for _, d := range []string{templatesDir, sassDir, jsDir} {
+		dir := filepath.Join(s.flags.Assets, d)
+		if !fileExists(dir) {
+			continue
+		}
+		err := walk(s.flags, dir, func(n string, fi os.FileInfo, err error) error {
+			switch {
+			case err != nil:
+				return err
+			case fi.IsDir() || strings.HasSuffix(n, ".go"):
+				return nil
+			}
+			buf, err := ioutil.ReadFile(n)
+			if err != nil {
+				return err
+			}
+			for _, m := range faNameRE.FindAllSubmatch(buf, -1) {
+				icons[string(m[1])] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return icons, nil
+}
+
 // js is the script handler to generate a minified javascript file from one or
-// more files.
+// more files. Files are relative to the js directory for the top-level script,
+// and to the script's directory for nested scripts.
 func (s *Script) js(fn string, v ...interface{}) {
+	base := filepath.Join(s.flags.Assets, jsDir)
+	if s.dir != s.flags.Assets {
+		base = s.dir
+	}
 	for _, n := range []string{
 		"uglify-js",
 		"source-map",
 	} {
 		s.nodeDeps = append(s.nodeDeps, dep{n, ""})
 	}
-	// add node deps
-	for _, x := range v {
-		switch d := x.(type) {
-		case jsdep:
-			s.nodeDeps = append(s.nodeDeps, dep{d.name, d.ver})
-		}
-	}
-	s.exec = append(s.exec, func(dist *pack.Pack) error {
-		if len(v) < 1 {
-			return errors.New("js() must be passed at least one arg")
-		}
-		// process node deps
-		scripts := make([]jsdep, len(v))
-		for i := 0; i < len(v); i++ {
-			switch d := v[i].(type) {
-			case string:
-				n := filepath.Join(s.flags.Assets, jsDir, d)
-				_, err := os.Stat(n)
+	// build paths
+	dir := filepath.Join(s.flags.Build, jsDir)
+	outfile := filepath.Join(dir, fn)
+	ext := filepath.Ext(outfile)
+	uglyfile := strings.TrimSuffix(outfile, ext) + ".uglify" + ext
+	s.exec = append(s.exec, step{
+		name: "js(" + fn + ")",
+		run: func(dist *pack.Pack) error {
+			scripts, err := s.jsScripts(base, v, false)
+			if err != nil {
+				return err
+			}
+			// ensure directory exists
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("could not create js dir: %w", err)
+			}
+			// open out file
+			f, err := os.Create(outfile)
+			if err != nil {
+				return fmt.Errorf("could not open %q: %w", outfile, err)
+			}
+			// add all files, tracking their line counts
+			paths, lines := make([]string, len(scripts)), make([]int, len(scripts))
+			for i, d := range scripts {
+				paths[i] = filepath.Join(s.flags.Wd, d.path)
+				buf, err := ioutil.ReadFile(paths[i])
 				if err != nil {
-					return fmt.Errorf("could not find js %q", d)
+					return fmt.Errorf("could not read js %q: %w", fn, err)
 				}
-				scripts[i] = jsdep{path: n}
-			case jsdep:
-				p, err := s.findNodeModulesFile(d)
-				if err != nil {
-					return err
+				src := strings.TrimSuffix(string(buf), "\n") + "\n"
+				if _, err := f.WriteString(src); err != nil {
+					return fmt.Errorf("could not write %q to %q: %w", fn, outfile, err)
 				}
-				scripts[i] = jsdep{name: d.name, path: p}
-			default:
-				return fmt.Errorf("unknown type passed to js(): %T", v[i])
+				lines[i] = strings.Count(src, "\n")
 			}
-		}
-		// ensure scripts are contained within project
-		for i := 0; i < len(scripts); i++ {
-			var err error
-			if scripts[i].path, err = filepath.Rel(s.flags.Wd, scripts[i].path); err != nil {
-				return fmt.Errorf("js cannot be outside of project")
+			// close
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("could not close %q: %w", outfile, err)
 			}
-		}
-		// ensure directory exists
-		dir := filepath.Join(s.flags.Build, jsDir)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("could not create js dir: %w", err)
-		}
-		// open out file
-		outfile := filepath.Join(dir, fn)
-		f, err := os.Create(outfile)
-		if err != nil {
-			return fmt.Errorf("could not open %q: %w", outfile, err)
-		}
-		// add all files
-		for _, d := range scripts {
-			buf, err := ioutil.ReadFile(filepath.Join(s.flags.Wd, d.path))
+			// uglify
+			if err := run(s.flags, "uglifyjs", s.uglifyParams(outfile, uglyfile)...); err != nil {
+				return fmt.Errorf("could not uglify %q: %w", outfile, newUglifyError(err, paths, lines))
+			}
+			var sources []string
+			for _, d := range scripts {
+				sources = append(sources, d.path)
+			}
+			s.addDeps(s.manifestKey(jsDir+"/"+fn), sources...)
+			return dist.PackFile(jsDir+"/"+fn, uglyfile)
+		},
+		plan: func() ([]string, []string, error) {
+			scripts, err := s.jsScripts(base, v, true)
 			if err != nil {
-				return fmt.Errorf("could not read js %q: %w", fn, err)
+				return nil, nil, err
 			}
-			if _, err := f.WriteString(strings.TrimSuffix(string(buf), "\n") + "\n"); err != nil {
-				return fmt.Errorf("could not write %q to %q: %w", fn, outfile, err)
+			var cmds []string
+			for _, d := range scripts {
+				cmds = append(cmds, fmt.Sprintf("concat %s >> %s", d.path, outfile))
 			}
+			return append(cmds, formatCommand("uglifyjs", s.uglifyParams(outfile, uglyfile)...)), []string{jsDir + "/" + fn}, nil
+		},
+	})
+}
+
+// uglifyParams returns the uglifyjs params for the concatenated js outfile.
+func (s *Script) uglifyParams(outfile, uglyfile string) []string {
+	params := []string{"--source-map"}
+	if s.prof.sourceMaps {
+		params = append(params, "url=inline")
+	}
+	if s.prof.minify {
+		params = append(params, "--compress")
+	} else {
+		params = append(params, "--beautify")
+	}
+	params = append(params, s.definesParams()...)
+	return append(params, "--output", uglyfile, outfile)
+}
+
+// jsScripts resolves the js files in base and node deps passed to js() to
+// paths relative to the working directory. When planning, node deps that are
+// not yet installed resolve to their unmatched path.
+func (s *Script) jsScripts(base string, v []interface{}, planning bool) ([]jsdep, error) {
+	if len(v) < 1 {
+		return nil, errors.New("js() must be passed at least one arg")
+	}
+	// process node deps
+	scripts := make([]jsdep, len(v))
+	for i := 0; i < len(v); i++ {
+		switch d := v[i].(type) {
+		case string:
+			n := filepath.Join(base, d)
+			_, err := os.Stat(n)
+			if err != nil {
+				return nil, fmt.Errorf("could not find js %q", d)
+			}
+			scripts[i] = jsdep{path: n}
+		case jsdep:
+			if planning && !fileExists(filepath.Join(s.flags.NodeModules, d.name)) {
+				p := d.path
+				if p == "" {
+					p = d.name + ".js"
+				}
+				scripts[i] = jsdep{name: d.name, path: filepath.Join(s.flags.NodeModules, d.name, p)}
+				continue
+			}
+			p, err := s.findNodeModulesFile(d)
+			if err != nil {
+				return nil, err
+			}
+			scripts[i] = jsdep{name: d.name, path: p}
+		default:
+			return nil, fmt.Errorf("unknown type passed to js(): %T", v[i])
 		}
-		// close
-		if err := f.Close(); err != nil {
-			return fmt.Errorf("could not close %q: %w", outfile, err)
-		}
-		// uglify
-		ext := filepath.Ext(outfile)
-		uglyfile := strings.TrimSuffix(outfile, ext) + ".uglify" + ext
-		if err := run(s.flags,
-			"uglifyjs",
-			"--source-map",
-			"--compress",
-			"--output", uglyfile,
-			outfile,
-		); err != nil {
-			return fmt.Errorf("could not uglify %q: %w", outfile, err)
+	}
+	// ensure scripts are contained within project
+	for i := 0; i < len(scripts); i++ {
+		var err error
+		if scripts[i].path, err = filepath.Rel(s.flags.Wd, scripts[i].path); err != nil {
+			return nil, fmt.Errorf("js cannot be outside of project")
 		}
-		return dist.PackFile(jsDir+"/"+fn, uglyfile)
-	})
+	}
+	return scripts, nil
 }
 
 // addFonts configures a script step for packing static font files.
 //
 // This walks the fonts directory, and if there's a SCSS/CSS file, add it to
 // sass import path. All font files will be added to the manifest.
+//
+// Legacy .ttf and .otf fonts without a .woff2 variant are converted to .woff2
+// (and any other formats set with fontFormats), and the converted fonts are
+// packed alongside the originals.
 func (s *Script) addFonts(_, dir string) {
+	fonts, styles, convert, err := s.fontFiles(dir)
+	if err != nil {
+		// reported when the step runs
+		convert = nil
+	}
+	if styles {
+		s.sassIncludes = append(s.sassIncludes, dir)
+	}
+	if len(convert) != 0 {
+		s.nodeDeps = append(s.nodeDeps, dep{"fontverter", ""})
+	}
+	s.exec = append(s.exec, step{
+		name: "fonts",
+		run: func(dist *pack.Pack) error {
+			fonts, _, convert, err := s.fontFiles(dir)
+			if err != nil {
+				return err
+			}
+			for _, fn := range fonts {
+				if err := dist.PackFile(fontsDir+"/"+fn, filepath.Join(dir, fn)); err != nil {
+					return err
+				}
+			}
+			if len(convert) == 0 {
+				return nil
+			}
+			if err := ioutil.WriteFile(filepath.Join(s.flags.Build, fontconvertJs), []byte(tplf(fontconvertJs)), 0644); err != nil {
+				return fmt.Errorf("could not write %s: %w", fontconvertJs, err)
+			}
+			for _, fn := range convert {
+				for _, format := range s.fontConversions(dir, fn) {
+					out := s.convertedFont(fn, format)
+					if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+						return err
+					}
+					if err := runSilent(s.flags, s.flags.NodeBin, filepath.Join(s.flags.Build, fontconvertJs), filepath.Join(dir, fn), out, format); err != nil {
+						return fmt.Errorf("could not convert %s to %s: %w", fn, format, err)
+					}
+					if err := dist.PackFile(fontsDir+"/"+strings.TrimSuffix(fn, filepath.Ext(fn))+"."+format, out); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		plan: func() ([]string, []string, error) {
+			if err != nil {
+				return nil, nil, err
+			}
+			var cmds, files []string
+			for _, fn := range fonts {
+				files = append(files, fontsDir+"/"+fn)
+			}
+			for _, fn := range convert {
+				for _, format := range s.fontConversions(dir, fn) {
+					cmds = append(cmds, formatCommand("node", filepath.Join(s.flags.Build, fontconvertJs), filepath.Join(dir, fn), s.convertedFont(fn, format), format))
+					files = append(files, fontsDir+"/"+strings.TrimSuffix(fn, filepath.Ext(fn))+"."+format)
+				}
+			}
+			return cmds, files, nil
+		},
+	})
+}
+
+// fontFiles walks the fonts directory, returning the font files, whether
+// there are any stylesheets, and the legacy fonts to be converted.
+func (s *Script) fontFiles(dir string) ([]string, bool, []string, error) {
+	var fonts []string
+	var styles bool
+	exts := make(map[string]map[string]bool)
+	err := walk(s.flags, dir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case s.ignored(n, fi):
+			return skipDir(fi)
+		case fi.IsDir() || strings.HasPrefix(fi.Name(), "."):
+			return nil
+		case webfontStyleRE.MatchString(n):
+			styles = true
+			return nil
+		case !webfontFileRE.MatchString(n):
+			return nil
+		}
+		fn := strings.TrimPrefix(n, dir+string(os.PathSeparator))
+		fonts = append(fonts, fn)
+		ext := strings.ToLower(filepath.Ext(fn))
+		base := strings.TrimSuffix(fn, filepath.Ext(fn))
+		if exts[base] == nil {
+			exts[base] = make(map[string]bool)
+		}
+		exts[base][ext] = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, nil, err
+	}
+	var convert []string
+	for _, fn := range fonts {
+		ext := strings.ToLower(filepath.Ext(fn))
+		m := exts[strings.TrimSuffix(fn, filepath.Ext(fn))]
+		// prefer converting the .ttf, when there is also an .otf
+		if (ext == ".ttf" || ext == ".otf" && !m[".ttf"]) && !m[".woff2"] {
+			convert = append(convert, fn)
+		}
+	}
+	return fonts, styles, convert, nil
+}
+
+// fontConversions returns the formats the legacy font fn in dir is to be
+// converted to, skipping formats already present in dir.
+func (s *Script) fontConversions(dir, fn string) []string {
+	var formats []string
+	for _, format := range s.fontFormats {
+		if !fileExists(filepath.Join(dir, strings.TrimSuffix(fn, filepath.Ext(fn))+"."+format)) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// convertedFont returns the build path for the font fn converted to format.
+func (s *Script) convertedFont(fn, format string) string {
+	return filepath.Join(s.flags.Build, fontsDir, strings.TrimSuffix(fn, filepath.Ext(fn))+"."+format)
+}
+
+// setFontFormats is the script handler to set the formats legacy .ttf and .otf
+// fonts are converted to (woff2, woff). Defaults to woff2.
+func (s *Script) setFontFormats(formats ...string) error {
+	s.fontFormats = nil
+	for _, format := range formats {
+		switch format {
+		case "woff2", "woff":
+		default:
+			return fmt.Errorf("invalid font format %q", format)
+		}
+		s.fontFormats = append(s.fontFormats, format)
+	}
+	return nil
 }
 
 var imageExtRE = regexp.MustCompile(`(?i)\.(jpe?g|gif|png|svg|mp4|webm|json)$`)
@@ -305,6 +938,10 @@ var imageExtRE = regexp.MustCompile(`(?i)\.(jpe?g|gif|png|svg|mp4|webm|json)$`)
 //
 // Note: adds the appropriate dependency requirements to script's deps.
 func (s *Script) addImages(_, dir string) {
+	if !s.prof.optimizeImages {
+		s.addImagesUnoptimized(dir)
+		return
+	}
 	for _, n := range []string{
 		"imagemin-cli",
 		"imagemin-gifsicle",
@@ -314,93 +951,168 @@ func (s *Script) addImages(_, dir string) {
 	} {
 		s.nodeDeps = append(s.nodeDeps, dep{n, ""})
 	}
-	s.exec = append(s.exec, func(dist *pack.Pack) error {
-		// accumulate images
-		var all, changed []string
-		err := filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
-			switch {
-			case err != nil:
+	s.exec = append(s.exec, step{
+		name: "images",
+		run: func(dist *pack.Pack) error {
+			all, changed, err := s.imageFiles(dir, true)
+			if err != nil {
 				return err
-			case fi.IsDir() || !imageExtRE.MatchString(fi.Name()) || strings.HasPrefix(filepath.Base(n), "."):
-				return nil
 			}
-			// ensure directory exists
-			fn := strings.TrimPrefix(n, dir+"/")
-			cacheDir := filepath.Join(s.flags.Cache, "images", filepath.Dir(fn))
-			if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			ch := make(chan string, len(changed))
+			for _, fn := range changed {
+				ch <- fn
+			}
+			close(ch)
+			// start workers to optimize images
+			eg, ctxt := errgroup.WithContext(context.Background())
+			for i := 0; i < s.flags.Workers; i++ {
+				eg.Go(func() error {
+					for {
+						select {
+						case <-ctxt.Done():
+							return ctxt.Err()
+						case fn := <-ch:
+							if fn == "" {
+								return nil
+							}
+							out := filepath.Join(s.imagesCache(), fn)
+							in := filepath.Join(s.flags.Assets, "images", fn)
+							if err := s.optimizeImage(out, in); err != nil {
+								return err
+							}
+						}
+					}
+				})
+			}
+			if err := eg.Wait(); err != nil {
 				return err
 			}
-			outfile := filepath.Join(cacheDir, filepath.Base(fn))
-			// hash
-			hash, err := md5hash(n)
+			// pack the generated images
+			for _, fn := range all {
+				if err := dist.PackFile(imagesDir+"/"+fn, filepath.Join(s.imagesCache(), fn)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		plan: func() ([]string, []string, error) {
+			all, changed, err := s.imageFiles(dir, false)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
-			hashPath := outfile + ".md5"
-			var cached string
-			// read cached hash
-			_, err = os.Stat(hashPath)
-			switch {
-			case err != nil && !os.IsNotExist(err):
+			var cmds, files []string
+			for _, fn := range changed {
+				out := filepath.Join(s.imagesCache(), fn)
+				in := filepath.Join(s.flags.Assets, "images", fn)
+				cmds = append(cmds, formatCommand("imagemin", imageminParams(out, in)...))
+			}
+			for _, fn := range all {
+				files = append(files, imagesDir+"/"+fn)
+			}
+			return cmds, files, nil
+		},
+	})
+}
+
+// addImagesUnoptimized configures a script step for packing the image files
+// as is.
+func (s *Script) addImagesUnoptimized(dir string) {
+	s.exec = append(s.exec, step{
+		name: "images",
+		run: func(dist *pack.Pack) error {
+			all, _, err := s.imageFiles(dir, false)
+			if err != nil {
 				return err
-			case err != nil && os.IsNotExist(err):
-			case err == nil:
-				buf, err := ioutil.ReadFile(hashPath)
-				if err != nil {
+			}
+			for _, fn := range all {
+				if err := dist.PackFile(imagesDir+"/"+fn, filepath.Join(dir, fn)); err != nil {
 					return err
 				}
-				cached = string(buf)
 			}
-			all = append(all, fn)
-			if cached == "" || cached != hash || !fileExists(outfile) {
-				changed = append(changed, fn)
+			return nil
+		},
+		plan: func() ([]string, []string, error) {
+			all, _, err := s.imageFiles(dir, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			var files []string
+			for _, fn := range all {
+				files = append(files, imagesDir+"/"+fn)
 			}
-			return ioutil.WriteFile(hashPath, []byte(hash), 0644)
-		})
-		if err != nil {
+			return nil, files, nil
+		},
+	})
+}
+
+// imageFiles walks the images directory, returning all image files and those
+// whose optimized image is missing or out of date. When write is true, the
+// cache directories and content hashes are updated.
+func (s *Script) imageFiles(dir string, write bool) ([]string, []string, error) {
+	var all, changed []string
+	err := walk(s.flags, dir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
 			return err
+		case s.ignored(n, fi):
+			return skipDir(fi)
+		case fi.IsDir() || !imageExtRE.MatchString(fi.Name()) || strings.HasPrefix(filepath.Base(n), "."):
+			return nil
 		}
-		ch := make(chan string, len(changed))
-		for _, fn := range changed {
-			ch <- fn
-		}
-		close(ch)
-		// start workers to optimize images
-		eg, ctxt := errgroup.WithContext(context.Background())
-		for i := 0; i < s.flags.Workers; i++ {
-			eg.Go(func() error {
-				for {
-					select {
-					case <-ctxt.Done():
-						return ctxt.Err()
-					case fn := <-ch:
-						if fn == "" {
-							return nil
-						}
-						out := filepath.Join(s.flags.Cache, "images", fn)
-						in := filepath.Join(s.flags.Assets, "images", fn)
-						if err := s.optimizeImage(out, in); err != nil {
-							return err
-						}
-					}
-				}
-			})
-		}
-		if err := eg.Wait(); err != nil {
+		fn := strings.TrimPrefix(n, dir+"/")
+		cacheDir := filepath.Join(s.imagesCache(), filepath.Dir(fn))
+		outfile := filepath.Join(cacheDir, filepath.Base(fn))
+		// hash
+		hash, err := md5hash(n)
+		if err != nil {
 			return err
 		}
-		// pack the generated images
-		for _, fn := range all {
-			if err := dist.PackFile(imagesDir+"/"+fn, filepath.Join(s.flags.Cache, imagesDir, fn)); err != nil {
+		hashPath := outfile + ".md5"
+		var cached string
+		// read cached hash
+		_, err = os.Stat(hashPath)
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			return err
+		case err != nil && os.IsNotExist(err):
+		case err == nil:
+			buf, err := ioutil.ReadFile(hashPath)
+			if err != nil {
 				return err
 			}
+			cached = string(buf)
 		}
-		return nil
+		all = append(all, fn)
+		if cached == "" || cached != hash || !fileExists(outfile) {
+			changed = append(changed, fn)
+		}
+		if !write {
+			return nil
+		}
+		// ensure directory exists
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(hashPath, []byte(hash), 0644)
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return all, changed, nil
+}
+
+// imagesCache returns the cache directory for optimized images.
+func (s *Script) imagesCache() string {
+	return filepath.Join(s.flags.Cache, imagesDir, filepath.FromSlash(s.flags.root))
 }
 
 // optimizeImage optimizes a single image.
 func (s *Script) optimizeImage(out, in string) error {
+	return runSilent(s.flags, "imagemin", imageminParams(out, in)...)
+}
+
+// imageminParams returns the imagemin params for optimizing a single image.
+func imageminParams(out, in string) []string {
 	var plugin string
 	switch filepath.Ext(strings.ToLower(in))[1:] {
 	case "jpg", "jpeg":
@@ -412,7 +1124,7 @@ func (s *Script) optimizeImage(out, in string) error {
 	case "gif":
 		plugin = "--plugin=gifsicle"
 	}
-	return runSilent(s.flags, "imagemin", plugin, "--out-dir="+filepath.Dir(out), in)
+	return []string{plugin, "--out-dir=" + filepath.Dir(out), in}
 }
 
 // stripCssCommentsRE is a regexp to match css comments.
@@ -435,202 +1147,538 @@ func (s *Script) addSass(_, dir string) {
 	} {
 		s.nodeDeps = append(s.nodeDeps, dep{n, ""})
 	}
-	s.exec = append(s.exec, func(dist *pack.Pack) error {
-		// ensure build/assetgen exists
-		if err := os.MkdirAll(filepath.Join(s.flags.Build, "assetgen"), 0755); err != nil {
-			return fmt.Errorf("could not create assetgen directory: %w", err)
-		}
-		// if tailwind.config.js doesn't exist, generate it
-		tailwindJs := filepath.Join(s.flags.Assets, "sass", "tailwind.config.js")
-		if !fileExists(tailwindJs) {
-			if err := run(s.flags, "tailwindcss", "init", tailwindJs, "--full"); err != nil {
-				return fmt.Errorf("could not generate tailwind css config: %w", err)
-			}
-		}
-		// write sass.js, postcss.config.js, and _assetgen.scss to build dir
-		if err := ioutil.WriteFile(
-			filepath.Join(s.flags.Build, sassJs),
-			[]byte(tplf(sassJs)),
-			0644,
-		); err != nil {
-			return fmt.Errorf("could not write %s: %w", sassJs, err)
-		}
-		if err := ioutil.WriteFile(
-			filepath.Join(s.flags.Build, postcssJs),
-			[]byte(tplf(postcssJs, tailwindJs, filepath.Join(s.flags.Assets, templatesDir))),
-			0644,
-		); err != nil {
-			return fmt.Errorf("could not write %s: %w", postcssJs, err)
-		}
-		if err := ioutil.WriteFile(
-			filepath.Join(s.flags.Build, "assetgen", assetgenScss),
-			[]byte(tplf(assetgenScss)),
-			0644,
-		); err != nil {
-			return fmt.Errorf("could not write: %s: %w", assetgenScss, err)
-		}
-		// write fontawesome to build dir
-		if err := installFontAwesome(s.flags, dist); err != nil {
-			return fmt.Errorf("could not install fontawesome: %w", err)
-		}
-		// FIXME: other than for debugging purposes, is it necessary to write
-		// FIXME: the manifest to disk?
-		// write temporary manifest
-		manifest, err := dist.ManifestBytes()
+	s.exec = append(s.exec, step{
+		name: "sass",
+		run: func(dist *pack.Pack) error {
+			// ensure build/assetgen exists
+			if err := os.MkdirAll(filepath.Join(s.flags.Build, "assetgen"), 0755); err != nil {
+				return fmt.Errorf("could not create assetgen directory: %w", err)
+			}
+			entries, err := s.sassEntries(dir)
+			if err != nil {
+				return err
+			}
+			// lock tailwindcss version
+			ver, err := nodeModuleVersion(s.flags, "tailwindcss")
+			if err != nil {
+				return fmt.Errorf("could not determine tailwindcss version: %w", err)
+			}
+			hash, err := nodeModuleHash(s.flags, "tailwindcss")
+			if err != nil {
+				return err
+			}
+			if err := s.flags.lock.resolve(s.flags, "tailwindcss", ver, hash); err != nil {
+				return err
+			}
+			// if tailwind.config.js doesn't exist, generate it
+			tailwindJs := filepath.Join(s.flags.Assets, "sass", "tailwind.config.js")
+			if sassTailwind(entries) && !fileExists(tailwindJs) {
+				if err := os.MkdirAll(filepath.Dir(tailwindJs), 0755); err != nil {
+					return fmt.Errorf("could not create sass directory: %w", err)
+				}
+				if err := run(s.flags, "tailwindcss", "init", tailwindJs, "--full"); err != nil {
+					return fmt.Errorf("could not generate tailwind css config: %w", err)
+				}
+			}
+			// write sass.js, postcss.config.js, and _assetgen.scss to build dir
+			if err := ioutil.WriteFile(
+				filepath.Join(s.flags.Build, sassJs),
+				[]byte(tplf(sassJs)),
+				0644,
+			); err != nil {
+				return fmt.Errorf("could not write %s: %w", sassJs, err)
+			}
+			if err := ioutil.WriteFile(
+				filepath.Join(s.flags.Build, postcssJs),
+				[]byte(tplf(postcssJs, s.cssModules, fmt.Sprintf("require('tailwindcss')(%q),", tailwindJs), filepath.Join(s.flags.Assets, templatesDir))),
+				0644,
+			); err != nil {
+				return fmt.Errorf("could not write %s: %w", postcssJs, err)
+			}
+			if err := os.MkdirAll(filepath.Join(s.flags.Build, noTailwindDir), 0755); err != nil {
+				return fmt.Errorf("could not create %s directory: %w", noTailwindDir, err)
+			}
+			if err := ioutil.WriteFile(
+				filepath.Join(s.flags.Build, noTailwindDir, postcssJs),
+				[]byte(tplf(postcssJs, s.cssModules, "", filepath.Join(s.flags.Assets, templatesDir))),
+				0644,
+			); err != nil {
+				return fmt.Errorf("could not write %s: %w", postcssJs, err)
+			}
+			if err := ioutil.WriteFile(
+				filepath.Join(s.flags.Build, "assetgen", assetgenScss),
+				[]byte(tplf(assetgenScss)+s.definesScss()),
+				0644,
+			); err != nil {
+				return fmt.Errorf("could not write: %s: %w", assetgenScss, err)
+			}
+			// write fontawesome to build dir
+			var icons map[string]bool
+			if s.faSubset {
+				var err error
+				if icons, err = s.usedFontAwesomeIcons(); err != nil {
+					return fmt.Errorf("could not determine used fontawesome icons: %w", err)
+				}
+			}
+			if err := installFontAwesome(s.flags, dist, icons); err != nil {
+				return fmt.Errorf("could not install fontawesome: %w", err)
+			}
+			// FIXME: other than for debugging purposes, is it necessary to write
+			// FIXME: the manifest to disk?
+			// write temporary manifest
+			manifest, err := dist.ManifestBytes()
+			if err != nil {
+				return fmt.Errorf("could not generate manifest: %w", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(s.flags.Build, "manifest.json"), manifest, 0644); err != nil {
+				return fmt.Errorf("could not write manifest.json: %w", err)
+			}
+			defer s.setPack(dist)
+			for _, e := range entries {
+				p, err := s.distPack(dist, e.dist)
+				if err != nil {
+					return err
+				}
+				s.setPack(p)
+				if err := timed(s.flags, "sass("+e.name+".css)", func() error {
+					return s.compileSass(p, e)
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		plan: func() ([]string, []string, error) {
+			entries, err := s.sassEntries(dir)
+			if err != nil {
+				return nil, nil, err
+			}
+			var cmds, files []string
+			if tailwindJs := filepath.Join(s.flags.Assets, "sass", "tailwind.config.js"); sassTailwind(entries) && !fileExists(tailwindJs) {
+				cmds = append(cmds, formatCommand("tailwindcss", "init", tailwindJs, "--full"))
+			}
+			cmds = append(cmds, strings.TrimSpace("retrieve fontawesome "+s.flags.FontAwesomeVersion))
+			for _, e := range entries {
+				cmds = append(
+					cmds,
+					formatCommand("node-sass", append(s.nodeSassParams(e), e.path, s.sassOut(e, ".css"))...),
+					formatCommand("postcss", s.postcssParams(e)...),
+				)
+				if s.prof.minify {
+					cmds = append(cmds, formatCommand("cleancss", s.cleancssParams(e)...))
+				}
+				files = append(files, cssDir+"/"+e.name+".css")
+			}
+			return cmds, files, nil
+		},
+	})
+}
+
+// sassEntry is a sass entrypoint.
+type sassEntry struct {
+	// path is the path to the .scss file.
+	path string
+	// name is the name of the generated css, relative to the css directory
+	// and without the .css extension.
+	name string
+	// noTailwind disables tailwind css for the entrypoint.
+	noTailwind bool
+	// includes are additional sass include directories for the entrypoint.
+	includes []string
+	// dist is the named dist the css is packed to, or empty for the default
+	// dist.
+	dist string
+}
+
+// sass is the script handler to declare a sass entrypoint, replacing the
+// default of compiling the top-level .scss files in the sass directory. The
+// file is relative to the sass directory for the top-level script, and to the
+// script's directory for nested scripts, and may be in a subdirectory:
+//
+//	sass("app.scss", {"name": "main.css"})
+//
+// Options are "name" (the generated css, relative to the css directory),
+// "noTailwind" (disables tailwind css), and "includes" (additional sass
+// include directories, relative to the script's directory).
+func (s *Script) sass(fn string, v ...interface{}) error {
+	base := filepath.Join(s.flags.Assets, sassDir)
+	if s.dir != s.flags.Assets {
+		base = s.dir
+	}
+	e := sassEntry{
+		path: filepath.Join(base, filepath.FromSlash(fn)),
+		name: strings.TrimSuffix(strings.TrimLeft(path.Clean("/"+filepath.ToSlash(fn)), "/"), ".scss"),
+		dist: s.distName,
+	}
+	for _, z := range v {
+		opts, ok := z.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("unknown type passed to sass(): %T", z)
+		}
+		for k, v := range opts {
+			switch k {
+			case "name":
+				e.name = strings.TrimSuffix(strings.TrimLeft(path.Clean("/"+forceString(v)), "/"), ".css")
+			case "noTailwind":
+				b, ok := v.(bool)
+				if !ok {
+					return fmt.Errorf("invalid sass() option %v value type %T", k, v)
+				}
+				e.noTailwind = b
+			case "includes":
+				var dirs []interface{}
+				switch x := v.(type) {
+				case string:
+					dirs = []interface{}{x}
+				case []interface{}:
+					dirs = x
+				default:
+					return fmt.Errorf("invalid sass() option %v value type %T", k, v)
+				}
+				for _, d := range dirs {
+					e.includes = append(e.includes, filepath.Join(s.dir, filepath.FromSlash(forceString(d))))
+				}
+			default:
+				return fmt.Errorf("invalid sass() option %v", k)
+			}
+		}
+	}
+	if e.name == "" {
+		return fmt.Errorf("invalid sass() name for %q", fn)
+	}
+	for _, z := range s.sassFiles {
+		if z.name == e.name && z.dist == e.dist {
+			return fmt.Errorf("sass() %s.css already declared", e.name)
+		}
+	}
+	s.sassFiles = append(s.sassFiles, e)
+	return nil
+}
+
+// sassTailwind determines if any of the sass entrypoints use tailwind css.
+func sassTailwind(entries []sassEntry) bool {
+	for _, e := range entries {
+		if !e.noTailwind {
+			return true
+		}
+	}
+	return false
+}
+
+// sassEntries returns the sass entrypoints declared by the script, or when
+// none were declared, the top-level .scss files not starting with _ or . in
+// dir.
+func (s *Script) sassEntries(dir string) ([]sassEntry, error) {
+	if len(s.sassFiles) != 0 {
+		for _, e := range s.sassFiles {
+			if !fileExists(e.path) {
+				return nil, fmt.Errorf("could not find sass entrypoint %s", e.path)
+			}
+		}
+		return s.sassFiles, nil
+	}
+	var entries []sassEntry
+	err := walk(s.flags, dir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() || filepath.Dir(n) != dir || !strings.HasSuffix(n, "scss"):
+			return nil
+		}
+		base := filepath.Base(n)
+		if strings.HasPrefix(base, "_") || strings.HasPrefix(base, ".") {
+			return nil
+		}
+		entries = append(entries, sassEntry{
+			path: n,
+			name: strings.TrimSuffix(base, ".scss"),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// sassOut returns the path in the build directory of the css generated for
+// the sass entrypoint, with the suffix.
+func (s *Script) sassOut(e sassEntry, suffix string) string {
+	return filepath.Join(s.flags.Build, cssDir, filepath.FromSlash(e.name)+suffix)
+}
+
+// nodeSassParams returns the node-sass params for the sass entrypoint.
+func (s *Script) nodeSassParams(e sassEntry) []string {
+	params := []string{
+		"--quiet",
+		"--source-comments",
+		"--source-map-embed",
+		//"--source-map-contents",
+		//"--source-map=" + filepath.Join(s.flags.Build, cssDir,  fn + ".css.map"),
+		//"--source-map-root=" + s.flags.Wd,
+		"--functions=" + filepath.Join(s.flags.Build, sassJs),
+		"--include-path=" + filepath.Join(s.flags.Build, "assetgen"),
+		"--include-path=" + filepath.Join(s.flags.Build, "fontawesome"),
+	}
+	if s.webfontPkgs {
+		params = append(params, "--include-path="+filepath.Join(s.flags.Build, webfontsDir))
+	}
+	for _, z := range append(s.sassIncludes, e.includes...) {
+		params = append(params, "--include-path="+z)
+	}
+	for _, name := range s.npmPkgs {
+		dir, err := nodeModuleSassDir(s.flags, name)
+		switch {
+		case err != nil:
+			warnf(s.flags, "could not determine sass entrypoint for %s: %v", name, err)
+		case dir != "" && !contains(s.sassIncludes, dir):
+			params = append(params, "--include-path="+dir)
+		}
+	}
+	return params
+}
+
+// nodeModuleSassDir returns the directory of the named node module's sass
+// entrypoint, as given by the sass or style field in its package.json. An
+// empty string is returned when the module is not installed, or does not have
+// a sass entrypoint.
+func nodeModuleSassDir(flags *Flags, name string) (string, error) {
+	for _, dir := range nodeModulesDirs(flags) {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, name, "package.json"))
 		if err != nil {
-			return fmt.Errorf("could not generate manifest: %w", err)
+			continue
 		}
-		if err := ioutil.WriteFile(filepath.Join(s.flags.Build, "manifest.json"), manifest, 0644); err != nil {
-			return fmt.Errorf("could not write manifest.json: %w", err)
+		var v struct {
+			Sass  string `json:"sass"`
+			Style string `json:"style"`
 		}
-		return filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
-			switch {
-			case err != nil:
-				return err
-			case fi.IsDir() || filepath.Dir(n) != dir || !strings.HasSuffix(n, "scss"):
-				return nil
-			}
-			base := filepath.Base(n)
-			if strings.HasPrefix(base, "_") || strings.HasPrefix(base, ".") {
-				return nil
-			}
-			// build node-sass params
-			fn := strings.TrimSuffix(base, ".scss")
-			params := []string{
-				"--quiet",
-				"--source-comments",
-				"--source-map-embed",
-				//"--source-map-contents",
-				//"--source-map=" + filepath.Join(s.flags.Build, cssDir,  fn + ".css.map"),
-				//"--source-map-root=" + s.flags.Wd,
-				"--functions=" + filepath.Join(s.flags.Build, sassJs),
-				"--output=" + filepath.Join(s.flags.Build, cssDir),
-				"--include-path=" + filepath.Join(s.flags.Build, "assetgen"),
-				"--include-path=" + filepath.Join(s.flags.Build, "fontawesome"),
-			}
-			for _, z := range s.sassIncludes {
-				params = append(params, "--include-path="+z)
-			}
-			// run node-sass
-			if err := run(s.flags, "node-sass", append(params, n)...); err != nil {
-				return fmt.Errorf("could not run node-sass: %w", err)
-			}
-			postCss := filepath.Join(s.flags.Build, cssDir, fn+".postcss.css")
-			cleanCss := filepath.Join(s.flags.Build, cssDir, fn+".cleancss.css")
-			finalCss := filepath.Join(s.flags.Build, cssDir, fn+".final.css")
-			// postcss
-			if err := run(
-				s.flags,
-				"postcss",
-				"--config="+filepath.Join(s.flags.Build, postcssJs),
-				"--map",
-				"--output="+postCss,
-				filepath.Join(s.flags.Build, cssDir, fn+".css"),
-			); err != nil {
-				return fmt.Errorf("could not run postcss: %w", err)
-			}
-			// cleancss
-			if err := runSilent(
-				s.flags,
-				"cleancss",
-				"-O1", "specialComments:0",
-				"-O2",
-				"--inline", "all",
-				"--source-map",
-				"--output="+cleanCss,
-				postCss,
-			); err != nil {
-				return fmt.Errorf("could not run cleancss: %w", err)
-			}
-			// strip annoying comments
-			buf, err := ioutil.ReadFile(cleanCss)
-			if err != nil {
-				return fmt.Errorf("could not read cleancss: %w", err)
-			}
-			// write final css
-			buf = stripCssCommentsRE.ReplaceAll(buf, nil)
-			if err := ioutil.WriteFile(finalCss, buf, 0644); err != nil {
-				return fmt.Errorf("could not write final css: %w", err)
+		if err := json.Unmarshal(buf, &v); err != nil {
+			return "", fmt.Errorf("invalid package.json for %s: %w", name, err)
+		}
+		for _, z := range []string{v.Sass, v.Style} {
+			if ext := strings.ToLower(filepath.Ext(z)); ext == ".scss" || ext == ".sass" {
+				return filepath.Join(dir, name, filepath.Dir(filepath.FromSlash(z))), nil
 			}
-			return dist.PackFile(cssDir+"/"+fn+".css", finalCss)
-		})
-	})
+		}
+		return "", nil
+	}
+	return "", nil
+}
+
+// postcssParams returns the postcss params for the compiled sass entrypoint.
+func (s *Script) postcssParams(e sassEntry) []string {
+	// source map is inlined (and passed to cleancss when minifying)
+	sourceMap := "--map"
+	if !s.prof.minify && !s.prof.sourceMaps {
+		sourceMap = "--no-map"
+	}
+	config := filepath.Join(s.flags.Build, postcssJs)
+	if e.noTailwind {
+		config = filepath.Join(s.flags.Build, noTailwindDir, postcssJs)
+	}
+	return []string{
+		"--config=" + config,
+		sourceMap,
+		"--output=" + s.sassOut(e, ".postcss.css"),
+		s.sassOut(e, ".css"),
+	}
+}
+
+// cleancssParams returns the cleancss params for the compiled sass
+// entrypoint.
+func (s *Script) cleancssParams(e sassEntry) []string {
+	return []string{
+		"-O1", "specialComments:0",
+		"-O2",
+		"--inline", "all",
+		"--source-map",
+		"--output=" + s.sassOut(e, ".cleancss.css"),
+		s.sassOut(e, ".postcss.css"),
+	}
+}
+
+// compileSass compiles, prefixes, and minifies the sass entrypoint, adding
+// the generated css to dist.
+func (s *Script) compileSass(dist *pack.Pack, e sassEntry) error {
+	if err := os.MkdirAll(filepath.Dir(s.sassOut(e, ".css")), 0755); err != nil {
+		return fmt.Errorf("could not create css directory: %w", err)
+	}
+	// run node-sass, reporting the error location from its output
+	if err := run(s.flags, "node-sass", append(s.nodeSassParams(e), e.path, s.sassOut(e, ".css"))...); err != nil {
+		var stepErr *StepError
+		if errors.As(err, &stepErr) {
+			err = newSassError(stepErr)
+		}
+		return fmt.Errorf("could not run node-sass: %w", err)
+	}
+	// record imports from the embedded source map
+	sources, err := sourceMapSources(s.sassOut(e, ".css"))
+	if err != nil {
+		return err
+	}
+	s.addDeps(s.manifestKey(cssDir+"/"+e.name+".css"), append([]string{e.path}, sources...)...)
+	// postcss
+	if err := run(s.flags, "postcss", s.postcssParams(e)...); err != nil {
+		return fmt.Errorf("could not run postcss: %w", err)
+	}
+	if name, ok := s.cssModuleName(e); ok {
+		if err := s.loadCssModuleClasses(e, name); err != nil {
+			return err
+		}
+	}
+	// pack unminified css as is
+	if !s.prof.minify {
+		return dist.PackFile(cssDir+"/"+e.name+".css", s.sassOut(e, ".postcss.css"))
+	}
+	// cleancss
+	if err := runSilent(s.flags, "cleancss", s.cleancssParams(e)...); err != nil {
+		return fmt.Errorf("could not run cleancss: %w", err)
+	}
+	// strip annoying comments
+	cleanCss := s.sassOut(e, ".cleancss.css")
+	buf, err := ioutil.ReadFile(cleanCss)
+	if err != nil {
+		return fmt.Errorf("could not read cleancss: %w", err)
+	}
+	// write final css
+	finalCss := s.sassOut(e, ".final.css")
+	buf = stripCssCommentsRE.ReplaceAll(buf, nil)
+	if err := ioutil.WriteFile(finalCss, buf, 0644); err != nil {
+		return fmt.Errorf("could not write final css: %w", err)
+	}
+	return dist.PackFile(cssDir+"/"+e.name+".css", finalCss)
 }
 
 // addTemplates configures a script step for generating optimized template
-// output (ie, Go code) from quicktemplate'd HTML files.
+// output (ie, Go code) from the HTML files in the templates directory.
 //
 // This looks at the templates directory, and if there are any .html files,
 // minifies them and normalizes templated i18n translation calls (T) before
-// passing the template through the quicktemplate compiler (qtc).
+// passing the template through the template engine. By default, templates
+// are compiled with the quicktemplate compiler (qtc), and native quicktemplate
+// .qtpl files are passed through qtc without minification. See
+// templateEngine for the other template engines.
+//
+// Content hashes of the templates are cached, and only templates that have
+// changed since the last run are minified and recompiled.
 func (s *Script) addTemplates(_, dir string) {
 	// add htmlmin dependency
-	s.nodeDeps = append(s.nodeDeps, dep{"html-minifier", ""})
-	s.exec = append(s.exec, func(dist *pack.Pack) error {
-		wd, err := os.Getwd()
-		if err != nil {
-			return err
-		}
-		tMatchRE, tFixRE, space := regexp.MustCompile(s.flags.TFuncName+"\\(`[^`]+`"), regexp.MustCompile(`\s+`), []byte(" ")
-		err = filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
-			switch {
-			case err != nil:
-				return err
-			case fi.IsDir() || !strings.HasSuffix(n, ".html"):
-				return nil
-			}
-			// read and minimize
-			buf, err := ioutil.ReadFile(n)
-			if err != nil {
-				return err
-			}
-			min, err := htmlmin(s.flags, buf)
+	if s.htmlminOpts != nil && s.tplEngine != engineTempl && s.flags.HtmlMinifier == "node" {
+		s.nodeDeps = append(s.nodeDeps, dep{"html-minifier", ""})
+	}
+	s.exec = append(s.exec, step{
+		name: "templates",
+		run: func(dist *pack.Pack) error {
+			return s.compileTemplates(dir)
+		},
+		plan: func() ([]string, []string, error) {
+			all, changed, err := s.templateFiles(dir)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
-			// change to the directory (necessary for qtc's parser to work)
-			d := filepath.Dir(n)
-			if err := os.Chdir(d); err != nil {
-				return err
+			var cmds []string
+			if len(s.defines) != 0 {
+				out := dir
+				if s.tplOut != "" {
+					out = s.tplOut
+				}
+				cmds = append(cmds, "write "+filepath.Join(out, definesFile))
 			}
-			// generate go template
-			out := new(bytes.Buffer)
-			if err := qtcparser.Parse(out, bytes.NewReader(min), filepath.Base(n), filepath.Base(d)); err != nil {
-				return err
+			if s.cssModules != "" {
+				out := dir
+				if s.tplOut != "" {
+					out = s.tplOut
+				}
+				cmds = append(cmds, "write "+filepath.Join(out, cssModulesFile))
 			}
-			// fix T(``) strings
-			buf = tMatchRE.ReplaceAllFunc(out.Bytes(), func(b []byte) []byte {
-				return tFixRE.ReplaceAll(b, space)
-			})
-			return ioutil.WriteFile(n+".go", buf, 0644)
-		})
-		if err != nil {
-			defer func() {
-				if err := os.Chdir(wd); err != nil {
-					panic(err)
+			for _, n := range changed {
+				if s.minifyTemplates(dir, n) && s.flags.HtmlMinifier == "node" {
+					cmds = append(cmds, formatCommand("html-minifier", append(htmlminParams(s.htmlminOpts, templateTagREs[s.tplEngine]), "< "+n)...))
 				}
-			}()
-			return err
-		}
-		return os.Chdir(wd)
+				switch s.tplEngine {
+				case engineTempl:
+					cmds = append(cmds, formatCommand("templ", "generate", "-f", n))
+				case engineQtc:
+					cmds = append(cmds, formatCommand("qtc", "-file="+n, "> "+s.templateGoFile(dir, n)))
+				}
+			}
+			switch {
+			case len(all) != 0 && s.tplOut != "":
+				cmds = append(cmds, "write "+filepath.Join(s.tplOut, templatesFile))
+			case len(all) != 0 && s.tplEngine == engineHtml:
+				cmds = append(cmds, "write "+filepath.Join(dir, templatesFile))
+			}
+			return cmds, nil, nil
+		},
 	})
 }
 
 // ConfigDeps handles configuring dependencies.
 func (s *Script) ConfigDeps() error {
+	changed, err := s.writeResolutions()
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", s.resolutionsKey(), err)
+	}
+	params, err := s.addDepsParams()
+	switch {
+	case err != nil:
+		return err
+	case params == nil && changed:
+		// reinstall to apply the changed resolutions
+		params = yarnParams(s.flags, append([]string{"install"}, modulesParams(s.flags)...)...)
+	case params == nil:
+		return nil
+	}
+	return run(s.flags, s.flags.YarnBin, params...)
+}
+
+// checkDeps checks that the script's node dependencies are installed.
+func (s *Script) checkDeps() error {
+	var missing []string
+	for _, d := range s.nodeDeps {
+		if _, err := nodeModuleVersion(s.flags, d.name); err != nil {
+			missing = append(missing, d.name)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("node packages not installed: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// nodeNeeded determines if the script has steps needing node: steps with node
+// dependencies, or plugins (which can be node packages).
+func (s *Script) nodeNeeded() bool {
+	if len(s.nodeDeps) != 0 {
+		return true
+	}
+	for _, st := range s.exec {
+		if strings.HasPrefix(st.name, "plugin(") {
+			return true
+		}
+	}
+	return false
+}
+
+// addDepsParams returns the package manager params to add the script's node
+// dependencies missing from package.json, or nil when there are none.
+func (s *Script) addDepsParams() ([]string, error) {
 	// load package.json
 	buf, err := ioutil.ReadFile(filepath.Join(s.flags.Wd, "package.json"))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var v struct {
 		Deps map[string]string `json:"dependencies"`
 	}
 	if err := json.Unmarshal(buf, &v); err != nil {
-		return errors.New("invalid package.json")
+		return nil, errors.New("invalid package.json")
 	}
 	// build params
-	params := []string{"add", "--no-progress", "--silent", "--no-bin-links", "--modules-folder=" + s.flags.NodeModules}
+	params := append([]string{"add"}, modulesParams(s.flags)...)
+	if !s.flags.yarnBerry && !s.flags.bun {
+		params = append(params, "--no-progress", "--silent")
+	}
 	var add bool
 	for _, d := range s.nodeDeps {
 		if _, ok := v.Deps[d.name]; ok {
@@ -643,15 +1691,45 @@ func (s *Script) ConfigDeps() error {
 		params, add = append(params, pkg), true
 	}
 	if !add {
-		return nil
+		return nil, nil
 	}
-	return run(s.flags, s.flags.YarnBin, params...)
+	return yarnParams(s.flags, params...), nil
+}
+
+// run starts the callback server, and executes the script.
+func (s *Script) run(dist *pack.Pack) error {
+	ctxt, cancel := context.WithCancel(s.flags.ctx)
+	// start callback server
+	cbs, err := s.startCallbackServer(ctxt, dist)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("could not start callback server: %w", err)
+	}
+	defer func() {
+		cancel()
+		if err := cbs.Close(); err != nil {
+			warnf(s.flags, "could not remove %s: %w", cbs.SocketPath(), err)
+		}
+	}()
+	// set ASSETGEN_SOCK and ASSETGEN_TOKEN for child processes
+	s.flags.env = append(s.flags.env, "ASSETGEN_SOCK="+cbs.SocketPath(), "ASSETGEN_TOKEN="+cbs.Token())
+	// run script
+	if err := s.Execute(dist); err != nil {
+		return fmt.Errorf("could not run script: %w", err)
+	}
+	return nil
 }
 
 // Execute executes the script.
 func (s *Script) Execute(dist *pack.Pack) error {
-	for _, f := range s.exec {
-		if err := f(dist); err != nil {
+	defer s.setPack(nil)
+	for _, st := range s.exec {
+		p, err := s.distPack(dist, st.dist)
+		if err != nil {
+			return err
+		}
+		s.setPack(p)
+		if err := timed(s.flags, st.name, func() error { return st.run(p) }); err != nil {
 			return err
 		}
 	}
@@ -659,8 +1737,8 @@ func (s *Script) Execute(dist *pack.Pack) error {
 }
 
 // startCallbackServer creates and starts the IPC callback server.
-func (s *Script) startCallbackServer(ctxt context.Context, dist *pack.Pack) (string, error) {
-	cbs, err := NewIpcServer(map[string]func(...interface{}) (interface{}, error){
+func (s *Script) startCallbackServer(ctxt context.Context, dist *pack.Pack) (*IpcServer, error) {
+	m := IpcCallbackMap{
 		// asset($url) converts the passed url to a static path.
 		"asset($url)": func(v ...interface{}) (interface{}, error) {
 			// check args
@@ -671,29 +1749,168 @@ func (s *Script) startCallbackServer(ctxt context.Context, dist *pack.Pack) (str
 			if !ok {
 				return nil, errors.New("$url must be a string")
 			}
-			// fix webfonts path (fontawesome)
-			if strings.HasPrefix(z, "../webfonts/") {
-				z = z[2:]
+			return s.assetURL(s.curPack(dist), z)
+		},
+		// inline($path) returns the contents of the asset.
+		"inline($path)": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 1 {
+				return nil, errors.New("invalid number of args")
+			}
+			z, ok := v[0].(string)
+			if !ok {
+				return nil, errors.New("$path must be a string")
+			}
+			buf, _, err := s.readAsset(z)
+			if err != nil {
+				return nil, err
+			}
+			return string(buf), nil
+		},
+		// datauri($path, $maxsize) converts the asset to a base64 data uri,
+		// or a static path when the asset is larger than $maxsize (when
+		// $maxsize is greater than 0).
+		"datauri($path, $maxsize: 0)": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 2 {
+				return nil, errors.New("invalid number of args")
+			}
+			z, ok := v[0].(string)
+			if !ok {
+				return nil, errors.New("$path must be a string")
+			}
+			maxsize, ok := v[1].(float64)
+			if !ok {
+				return nil, errors.New("$maxsize must be a number")
+			}
+			buf, n, err := s.readAsset(z)
+			if err != nil {
+				return nil, err
+			}
+			if maxsize > 0 && float64(len(buf)) > maxsize {
+				return s.assetURL(s.curPack(dist), z)
+			}
+			return dataURI(buf, n), nil
+		},
+		// imagesize($path) returns the width and height of the image.
+		"imagesize($path)": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 1 {
+				return nil, errors.New("invalid number of args")
+			}
+			z, ok := v[0].(string)
+			if !ok {
+				return nil, errors.New("$path must be a string")
+			}
+			buf, _, err := s.readAsset(z)
+			if err != nil {
+				return nil, err
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(buf))
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode image %s: %w", z, err)
+			}
+			return map[string]int{
+				"width":  cfg.Width,
+				"height": cfg.Height,
+			}, nil
+		},
+		// asset-hash($path) returns the content hash of the packed asset.
+		"asset-hash($path)": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 1 {
+				return nil, errors.New("invalid number of args")
+			}
+			z, ok := v[0].(string)
+			if !ok {
+				return nil, errors.New("$path must be a string")
+			}
+			return s.assetHash(s.curPack(dist), z), nil
+		},
+		// build-id() returns a hash of the files packed before the call,
+		// which changes whenever one of the files changes.
+		"build-id()": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 0 {
+				return nil, errors.New("invalid number of args")
+			}
+			return s.curPack(dist).ID(), nil
+		},
+		// pack-file($name, $path) packs the file at $path (relative to the
+		// working directory) as the asset $name.
+		"pack-file($name, $path)": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 2 {
+				return nil, errors.New("invalid number of args")
+			}
+			name, ok := v[0].(string)
+			if !ok {
+				return nil, errors.New("$name must be a string")
+			}
+			p, ok := v[1].(string)
+			if !ok {
+				return nil, errors.New("$path must be a string")
+			}
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(s.flags.Wd, p)
+			}
+			return nil, s.curPack(dist).PackFile(name, p)
+		},
+		// pack-bytes($name, $data) packs the base64 encoded $data as the
+		// asset $name.
+		"pack-bytes($name, $data)": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 2 {
+				return nil, errors.New("invalid number of args")
+			}
+			name, ok := v[0].(string)
+			if !ok {
+				return nil, errors.New("$name must be a string")
 			}
-			// save query string
-			var qstr string
-			if i := strings.LastIndex(z, "?"); i != -1 {
-				qstr, z = z[i:], z[:i]
-			} else if i := strings.LastIndex(z, "#"); i != -1 {
-				qstr, z = z[i:], z[:i]
+			z, ok := v[1].(string)
+			if !ok {
+				return nil, errors.New("$data must be a string")
 			}
-			// grab manifest
-			m, err := dist.Manifest()
+			buf, err := base64.StdEncoding.DecodeString(z)
 			if err != nil {
-				return nil, fmt.Errorf("unable to load manifest: %w", err)
+				return nil, fmt.Errorf("$data must be base64 encoded: %w", err)
+			}
+			return nil, s.curPack(dist).PackBytes(name, buf)
+		},
+		// pack-string($name, $contents) packs $contents as the asset $name.
+		"pack-string($name, $contents)": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 2 {
+				return nil, errors.New("invalid number of args")
+			}
+			name, ok := v[0].(string)
+			if !ok {
+				return nil, errors.New("$name must be a string")
+			}
+			z, ok := v[1].(string)
+			if !ok {
+				return nil, errors.New("$contents must be a string")
+			}
+			return nil, s.curPack(dist).PackString(name, z)
+		},
+		// manifest() returns the manifest of the files packed before the
+		// call, mapping the asset names to their hashed names.
+		"manifest()": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 0 {
+				return nil, errors.New("invalid number of args")
+			}
+			return s.curPack(dist).Manifest()
+		},
+		// manifest-name($name) returns the hashed name of the packed asset
+		// $name, or null when not packed.
+		"manifest-name($name)": func(v ...interface{}) (interface{}, error) {
+			if len(v) != 1 {
+				return nil, errors.New("invalid number of args")
 			}
-			// find asset name
-			n, ok := m["/"+strings.TrimPrefix(z, "/")]
+			name, ok := v[0].(string)
 			if !ok {
-				warnf(s.flags, "no asset %q in manifest", z)
-				n = fmt.Sprintf("__INV:%s%s__", z, qstr)
+				return nil, errors.New("$name must be a string")
 			}
-			return fmt.Sprintf("url('/_/%s%s')", n, qstr), nil
+			m, err := s.curPack(dist).Manifest()
+			if err != nil {
+				return nil, err
+			}
+			if n, ok := m["/"+strings.TrimLeft(name, "/")]; ok {
+				return n, nil
+			}
+			return nil, nil
 		},
 		// googlefont($font) downloads the google font.
 		"googlefont($font)": func(v ...interface{}) (interface{}, error) {
@@ -704,14 +1921,194 @@ func (s *Script) startCallbackServer(ctxt context.Context, dist *pack.Pack) (str
 			}
 			return fonts, nil
 		},
-	})
+	}
+	// add script callbacks
+	for name, f := range s.callbacks {
+		if _, ok := m[name]; ok {
+			return nil, fmt.Errorf("callback %s is already defined", name)
+		}
+		m[name] = f
+	}
+	cbs, err := NewIpcServer(
+		m,
+		WithIpcTransport(s.flags.IpcTransport),
+		WithIpcLogf(func(z string, v ...interface{}) {
+			warnf(s.flags, z, v...)
+		}),
+	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if err := cbs.Run(ctxt); err != nil {
+		cbs.Close()
+		return nil, err
+	}
+	return cbs, nil
+}
+
+// splitAssetURL splits the url into the asset path and the url's query
+// string or fragment.
+func splitAssetURL(z string) (string, string) {
+	// fix webfonts path (fontawesome)
+	if strings.HasPrefix(z, "../webfonts/") {
+		z = z[2:]
+	}
+	if i := strings.LastIndex(z, "?"); i != -1 {
+		return z[:i], z[i:]
+	} else if i := strings.LastIndex(z, "#"); i != -1 {
+		return z[:i], z[i:]
+	}
+	return z, ""
+}
+
+// manifestKey returns the dist manifest key for the asset path. Assets of
+// additional roots are prefixed.
+func (s *Script) manifestKey(z string) string {
+	key := "/" + strings.TrimPrefix(z, "/")
+	if s.flags.root != "" {
+		key = "/" + s.flags.root + key
+	}
+	return key
+}
+
+// assetURL converts the url to a static path using the dist manifest.
+func (s *Script) assetURL(dist *pack.Pack, z string) (string, error) {
+	// save query string
+	z, qstr := splitAssetURL(z)
+	// grab manifest
+	m, err := dist.Manifest()
+	if err != nil {
+		return "", fmt.Errorf("unable to load manifest: %w", err)
+	}
+	// find asset name
+	n, ok := m[s.manifestKey(z)]
+	if !ok {
+		warnf(s.flags, "no asset %q in manifest", z)
+		n = fmt.Sprintf("__INV:%s%s__", z, qstr)
+	}
+	// inline small assets
+	if ok && qstr == "" && s.inlineMax > 0 {
+		buf, p, err := s.readAsset(z)
+		if err == nil && int64(len(buf)) <= s.inlineMax {
+			return dataURI(buf, p), nil
+		}
+	}
+	return fmt.Sprintf("url('%s%s%s')", s.urlPrefix(dist), n, qstr), nil
+}
+
+// assetHash returns the content hash of the packed asset, as used in the
+// asset's name in the dist manifest.
+func (s *Script) assetHash(dist *pack.Pack, z string) string {
+	z, _ = splitAssetURL(z)
+	h, ok := dist.Hash(s.manifestKey(z))
+	if !ok {
+		warnf(s.flags, "no asset %q in manifest", z)
+		return fmt.Sprintf("__INV:%s__", z)
+	}
+	return h
+}
+
+// dataURI returns a css url() for buf (read from n) as a base64 data uri.
+func dataURI(buf []byte, n string) string {
+	typ := mime.TypeByExtension(filepath.Ext(n))
+	if typ == "" {
+		typ = http.DetectContentType(buf)
+	}
+	return fmt.Sprintf("url('data:%s;base64,%s')", typ, base64.StdEncoding.EncodeToString(buf))
+}
+
+// inlineAssets is the script handler to inline the assets referenced with
+// asset() in the sass that are no larger than maxsize bytes as base64 data
+// uris, instead of the packed asset's url. Assets referenced with a query
+// string or fragment are never inlined.
+func (s *Script) inlineAssets(maxsize int64) {
+	s.inlineMax = maxsize
+}
+
+// readAsset reads the asset from the dist directory, or when not yet packed,
+// from the assets directory, returning its contents and path.
+func (s *Script) readAsset(z string) ([]byte, string, error) {
+	// fix webfonts path (fontawesome)
+	if strings.HasPrefix(z, "../webfonts/") {
+		z = z[2:]
+	}
+	if i := strings.IndexAny(z, "?#"); i != -1 {
+		z = z[:i]
+	}
+	z = filepath.FromSlash(path.Clean("/" + z))
+	for _, dir := range []string{s.flags.Dist, s.flags.Assets} {
+		n := filepath.Join(dir, z)
+		buf, err := ioutil.ReadFile(n)
+		switch {
+		case err != nil && os.IsNotExist(err):
+			continue
+		case err != nil:
+			return nil, "", err
+		}
+		return buf, n, nil
+	}
+	return nil, "", fmt.Errorf("no asset %q", z)
+}
+
+// nodeModuleVersion returns the installed version of the named node module.
+func nodeModuleVersion(flags *Flags, name string) (string, error) {
+	var buf []byte
+	var err error
+	for _, dir := range nodeModulesDirs(flags) {
+		if buf, err = ioutil.ReadFile(filepath.Join(dir, name, "package.json")); err == nil {
+			break
+		}
+	}
+	if err != nil {
 		return "", err
 	}
-	return cbs.SocketPath(), nil
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return "", fmt.Errorf("invalid package.json for %s: %w", name, err)
+	}
+	return v.Version, nil
+}
+
+// nodeModuleHash returns the sha256 hash of the installed files (names and
+// contents) of the named node package, excluding its nested node_modules.
+func nodeModuleHash(flags *Flags, name string) (string, error) {
+	var dir string
+	for _, d := range nodeModulesDirs(flags) {
+		if fileExists(filepath.Join(d, name, "package.json")) {
+			dir = filepath.Join(d, name)
+			break
+		}
+	}
+	if dir == "" {
+		return "", fmt.Errorf("could not find node package %s", name)
+	}
+	h := sha256.New()
+	err := filepath.Walk(dir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() && fi.Name() == nodeModulesDir:
+			return filepath.SkipDir
+		case !fi.Mode().IsRegular():
+			return nil
+		}
+		buf, err := ioutil.ReadFile(n)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, n)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", filepath.ToSlash(rel), sha256hex(buf))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not hash node package %s: %w", name, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
 // findNodeModulesFile searches node_modules package for a masked file path,
@@ -768,6 +2165,7 @@ func fixNodeModulesBinLinks(flags *Flags) error {
 			return err
 		case path == flags.NodeModulesBin:
 			return nil
+		case runtime.GOOS == "windows" && strings.HasSuffix(path, ".cmd"):
 		case fi.Mode()&os.ModeSymlink == 0:
 			return fmt.Errorf("%s is not a symlink", path)
 		}
@@ -841,6 +2239,13 @@ func fixNodeModulesBinLinks(flags *Flags) error {
 			return fmt.Errorf("unable to determine path for %s: %w", linkpath, err)
 		}
 		newname := filepath.Join(flags.NodeModulesBin, n)
+		// windows cannot execute symlinked scripts, so write a .cmd shim
+		if runtime.GOOS == "windows" {
+			if err := writeCmdShim(flags, newname+".cmd", oldname); err != nil {
+				return fmt.Errorf("unable to write shim for %s: %w", oldname, err)
+			}
+			continue
+		}
 		// check symlink exists
 		_, err = os.Stat(newname)
 		switch {
@@ -853,11 +2258,20 @@ func fixNodeModulesBinLinks(flags *Flags) error {
 			return fmt.Errorf("unable to symlink %s to %s: %w", newname, oldname, err)
 		}
 		// fix permissions
-		if runtime.GOOS != "windows" {
-			if err := os.Chmod(linkpath, 0755); err != nil {
-				return err
-			}
+		if err := os.Chmod(linkpath, 0755); err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// writeCmdShim writes a windows .cmd shim that runs the script with node,
+// unless the script is itself an executable.
+func writeCmdShim(flags *Flags, name, script string) error {
+	cmd := fmt.Sprintf("@\"%s\" \"%s\" %%*\r\n", flags.NodeBin, script)
+	switch strings.ToLower(filepath.Ext(script)) {
+	case ".exe", ".cmd", ".bat":
+		cmd = fmt.Sprintf("@\"%s\" %%*\r\n", script)
+	}
+	return ioutil.WriteFile(name, []byte("@ECHO off\r\n"+cmd), 0755)
+}
@@ -0,0 +1,133 @@
+package sigs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/blake2b"
+)
+
+// VerifyPGP verifies the detached PGP signature sig of signed against the
+// armored keyring key. The signature can be armored or binary.
+func VerifyPGP(key, signed, sig []byte) error {
+	kr, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+	if err != nil {
+		return err
+	}
+	check := openpgp.CheckDetachedSignature
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	_, err = check(kr, bytes.NewReader(signed), bytes.NewReader(sig), nil)
+	return err
+}
+
+// VerifyMinisign verifies the minisign signature sig of signed against the
+// minisign public key key.
+//
+// The public key can be either the base64 encoded key, or the contents of a
+// minisign .pub file.
+func VerifyMinisign(key, signed, sig []byte) error {
+	// decode key
+	keyLines := minisignLines(key)
+	if len(keyLines) < 1 {
+		return errors.New("invalid minisign public key")
+	}
+	pub, err := base64.StdEncoding.DecodeString(keyLines[len(keyLines)-1])
+	if err != nil || len(pub) != 42 || string(pub[:2]) != "Ed" {
+		return errors.New("invalid minisign public key")
+	}
+	// decode signature
+	sigLines := minisignLines(sig)
+	if len(sigLines) != 3 || !strings.HasPrefix(sigLines[1], "trusted comment: ") {
+		return errors.New("invalid minisign signature")
+	}
+	s, err := base64.StdEncoding.DecodeString(sigLines[0])
+	if err != nil || len(s) != 74 {
+		return errors.New("invalid minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(sigLines[2])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("invalid minisign global signature")
+	}
+	// check key id
+	if !bytes.Equal(pub[2:10], s[2:10]) {
+		return fmt.Errorf("minisign key id %X does not match signature key id %X", pub[2:10], s[2:10])
+	}
+	// prehash
+	msg := signed
+	switch string(s[:2]) {
+	case "Ed":
+	case "ED":
+		h := blake2b.Sum512(signed)
+		msg = h[:]
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", s[:2])
+	}
+	// verify
+	pk := ed25519.PublicKey(pub[10:])
+	if !ed25519.Verify(pk, msg, s[10:]) {
+		return errors.New("invalid minisign signature")
+	}
+	trusted := strings.TrimPrefix(sigLines[1], "trusted comment: ")
+	if !ed25519.Verify(pk, append(append([]byte{}, s[10:]...), trusted...), global) {
+		return errors.New("invalid minisign trusted comment signature")
+	}
+	return nil
+}
+
+// minisignLines returns the lines of a minisign file, skipping untrusted
+// comments.
+func minisignLines(buf []byte) []string {
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// VerifyCosign verifies the base64 encoded cosign blob signature sig of
+// signed against the PEM encoded public key key.
+func VerifyCosign(key, signed, sig []byte) error {
+	b, _ := pem.Decode(key)
+	if b == nil {
+		return errors.New("invalid cosign public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(b.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid cosign public key: %w", err)
+	}
+	s, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid cosign signature: %w", err)
+	}
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(signed)
+		if !ecdsa.VerifyASN1(k, h[:], s) {
+			return errors.New("invalid cosign signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, signed, s) {
+			return errors.New("invalid cosign signature")
+		}
+	default:
+		return fmt.Errorf("unsupported cosign public key type %T", pub)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package sigs
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestVerifyMinisign(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	id := []byte("12345678")
+	key := "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), pub...)) + "\n"
+	signed := []byte("abc  node-v1.0.0-linux-x64.tar.gz\n")
+	sign := func(alg string, msg []byte, trusted string) string {
+		s := append(append([]byte(alg), id...), ed25519.Sign(priv, msg)...)
+		global := ed25519.Sign(priv, append(append([]byte{}, s[10:]...), trusted...))
+		return "untrusted comment: signature\n" +
+			base64.StdEncoding.EncodeToString(s) + "\n" +
+			"trusted comment: " + trusted + "\n" +
+			base64.StdEncoding.EncodeToString(global) + "\n"
+	}
+	prehashed := blake2b.Sum512(signed)
+	tests := []struct {
+		key, sig string
+		signed   []byte
+		ok       bool
+	}{
+		{key, sign("Ed", signed, "timestamp:1"), signed, true},
+		{key, sign("ED", prehashed[:], "timestamp:1"), signed, true},
+		{key, sign("Ed", signed, "timestamp:1"), []byte("other"), false},
+		{key, sign("ED", prehashed[:], "timestamp:1"), []byte("other"), false},
+		{key, sign("Ed", []byte("other"), "timestamp:1"), signed, false},
+		{key, sign("XX", signed, "timestamp:1"), signed, false},
+		{key, "", signed, false},
+		{"", sign("Ed", signed, "timestamp:1"), signed, false},
+		{"invalid", sign("Ed", signed, "timestamp:1"), signed, false},
+	}
+	for i, test := range tests {
+		if err := VerifyMinisign([]byte(test.key), test.signed, []byte(test.sig)); (err == nil) != test.ok {
+			t.Errorf("test %d expected ok %t, got: %v", i, test.ok, err)
+		}
+	}
+	// tampered trusted comment
+	sig := []byte(sign("Ed", signed, "timestamp:1"))
+	for i := range sig {
+		if string(sig[i:i+11]) == "timestamp:1" {
+			sig[i+10] = '2'
+			break
+		}
+	}
+	if err := VerifyMinisign([]byte(key), signed, sig); err == nil {
+		t.Errorf("expected error for tampered trusted comment")
+	}
+	// mismatched key id
+	other := "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), "87654321"...), pub...)) + "\n"
+	if err := VerifyMinisign([]byte(other), signed, []byte(sign("Ed", signed, "timestamp:1"))); err == nil {
+		t.Errorf("expected error for mismatched key id")
+	}
+}
+
+func TestVerifyCosign(t *testing.T) {
+	signed := []byte("abc  node-v1.0.0-linux-x64.tar.gz\n")
+	// ecdsa
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	h := sha256.Sum256(signed)
+	esig, err := ecdsa.SignASN1(rand.Reader, ek, h[:])
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// ed25519
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	edsig := ed25519.Sign(priv, signed)
+	encode := func(pub interface{}) string {
+		buf, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: buf}))
+	}
+	ekey, edkey := encode(&ek.PublicKey), encode(pub)
+	tests := []struct {
+		key    string
+		sig    []byte
+		signed []byte
+		ok     bool
+	}{
+		{ekey, esig, signed, true},
+		{edkey, edsig, signed, true},
+		{ekey, esig, []byte("other"), false},
+		{edkey, edsig, []byte("other"), false},
+		{ekey, edsig, signed, false},
+		{edkey, esig, signed, false},
+		{"", esig, signed, false},
+		{"-----BEGIN PUBLIC KEY-----\ninvalid\n-----END PUBLIC KEY-----\n", esig, signed, false},
+	}
+	for i, test := range tests {
+		sig := base64.StdEncoding.EncodeToString(test.sig) + "\n"
+		if err := VerifyCosign([]byte(test.key), test.signed, []byte(sig)); (err == nil) != test.ok {
+			t.Errorf("test %d expected ok %t, got: %v", i, test.ok, err)
+		}
+	}
+	// invalid base64
+	if err := VerifyCosign([]byte(ekey), signed, []byte("!!")); err == nil {
+		t.Errorf("expected error for invalid signature encoding")
+	}
+}
@@ -0,0 +1,119 @@
+package gen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// assetSize holds the raw, gzip, and brotli sizes of a packed asset. The
+// brotli size is -1 when it could not be determined.
+type assetSize struct {
+	name   string
+	raw    int64
+	gzip   int64
+	brotli int64
+}
+
+// logSizes logs a table of the raw, gzip, and brotli sizes of the packed
+// assets.
+//
+// Brotli sizes are determined with node's zlib, and are omitted (with a
+// warning) when that fails, or when node is not set up.
+func logSizes(flags *Flags, dist *pack.Pack) error {
+	names := dist.Files()
+	if len(names) == 0 {
+		return nil
+	}
+	sizes := make([]assetSize, len(names))
+	paths := make([]string, len(names))
+	for i, n := range names {
+		paths[i] = filepath.Join(flags.Dist, filepath.FromSlash(n))
+		buf, err := ioutil.ReadFile(paths[i])
+		if err != nil {
+			return err
+		}
+		gz, err := gzipSize(buf)
+		if err != nil {
+			return err
+		}
+		sizes[i] = assetSize{name: n, raw: int64(len(buf)), gzip: gz, brotli: -1}
+	}
+	var br []int64
+	var err error
+	if !flags.noNode {
+		br, err = brotliSizes(flags, paths)
+	}
+	switch {
+	case flags.noNode:
+	case err != nil:
+		warnf(flags, "could not determine brotli sizes: %v", err)
+	case len(br) != len(sizes):
+		warnf(flags, "could not determine brotli sizes: expected %d sizes, got: %d", len(sizes), len(br))
+	default:
+		for i := range sizes {
+			sizes[i].brotli = br[i]
+		}
+	}
+	// log table
+	width := len("TOTAL")
+	total := assetSize{name: "TOTAL"}
+	for _, z := range sizes {
+		if len(z.name) > width {
+			width = len(z.name)
+		}
+		total.raw, total.gzip, total.brotli = total.raw+z.raw, total.gzip+z.gzip, total.brotli+z.brotli
+	}
+	if sizes[0].brotli == -1 {
+		total.brotli = -1
+	}
+	flags.Logger.Logf(LogVerbose, "SIZE: %-*s %10s %10s %10s", width, "ASSET", "RAW", "GZIP", "BROTLI")
+	for _, z := range append(sizes, total) {
+		brotli := "-"
+		if z.brotli != -1 {
+			brotli = formatBytes(z.brotli)
+		}
+		flags.Logger.Logf(LogVerbose, "SIZE: %-*s %10s %10s %10s", width, z.name, formatBytes(z.raw), formatBytes(z.gzip), brotli)
+	}
+	return nil
+}
+
+// gzipSize returns the gzip compressed size of buf.
+func gzipSize(buf []byte) (int64, error) {
+	out := new(bytes.Buffer)
+	w, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(buf); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return int64(out.Len()), nil
+}
+
+// brotliSizes returns the brotli compressed sizes of the files, using node's
+// zlib.
+func brotliSizes(flags *Flags, paths []string) ([]int64, error) {
+	if err := ioutil.WriteFile(filepath.Join(flags.Build, brotliJs), []byte(tplf(brotliJs)), 0644); err != nil {
+		return nil, fmt.Errorf("could not write %s: %w", brotliJs, err)
+	}
+	params := append([]string{filepath.Join(flags.Build, brotliJs)}, paths...)
+	debugf(flags, "%s", formatCommand(flags.NodeBin, params...))
+	buf, err := newCmd(flags, flags.NodeBin, params...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var sizes []int64
+	if err := json.Unmarshal(buf, &sizes); err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
@@ -0,0 +1,81 @@
+package gen
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// Step is a build step contributed by another package, such as a generator
+// for assets not handled by assetgen.
+type Step interface {
+	// Name returns the name of the step.
+	Name() string
+	// Deps returns the node packages (as name or name@version) used by the
+	// step, which are installed before the step is run.
+	Deps() []string
+	// Run runs the step, packing its output to dist.
+	Run(ctx context.Context, dist *pack.Pack) error
+}
+
+// registered are the registered steps.
+var registered struct {
+	steps []Step
+	sync.Mutex
+}
+
+// RegisterStep registers a step to be run by the build for the top-level
+// assets directory, after the script and directory steps, in order of
+// registration. Steps should be registered before Run is called, typically in
+// an init func.
+func RegisterStep(st Step) {
+	registered.Lock()
+	defer registered.Unlock()
+	registered.steps = append(registered.steps, st)
+}
+
+// registeredSteps returns the registered steps.
+func registeredSteps() []Step {
+	registered.Lock()
+	defer registered.Unlock()
+	return append([]Step(nil), registered.steps...)
+}
+
+// addRegisteredSteps adds the registered steps and their node package
+// dependencies to the script.
+func (s *Script) addRegisteredSteps() error {
+	seen := make(map[string]bool)
+	for _, st := range registeredSteps() {
+		st, name := st, st.Name()
+		switch {
+		case name == "":
+			return fmt.Errorf("registered step %T has no name", st)
+		case seen[name]:
+			return fmt.Errorf("step %s registered more than once", name)
+		}
+		seen[name] = true
+		for _, n := range st.Deps() {
+			var ver string
+			if i := strings.LastIndex(n, "@"); i > 0 {
+				ver, n = n[i+1:], n[:i]
+			}
+			s.nodeDeps = append(s.nodeDeps, dep{n, ver})
+		}
+		s.exec = append(s.exec, step{
+			name: name,
+			run: func(dist *pack.Pack) error {
+				if err := st.Run(s.flags.ctx, dist); err != nil {
+					return fmt.Errorf("step %s failed: %w", name, err)
+				}
+				return nil
+			},
+			plan: func() ([]string, []string, error) {
+				return []string{"run " + name}, nil, nil
+			},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,494 @@
+package gen
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	qtcparser "github.com/valyala/quicktemplate/parser"
+)
+
+// template engines.
+const (
+	engineQtc   = "qtc"
+	engineTempl = "templ"
+	engineHtml  = "html"
+)
+
+// templateTagREs are the template tags for the template engines, which are
+// preserved when minifying.
+var templateTagREs = map[string]*regexp.Regexp{
+	engineQtc:  regexp.MustCompile(`\{%[^%]+%\}`),
+	engineHtml: regexp.MustCompile(`(?s)\{\{.+?\}\}`),
+}
+
+// templateEngine is the script handler to set the template engine used for
+// the templates directory:
+//
+//	qtc - quicktemplate (.html and .qtpl files, the default)
+//	templ - a-h/templ components (.templ files)
+//	html - a precompiled html/template bundle (.html files)
+func (s *Script) templateEngine(name string) error {
+	switch name {
+	case engineQtc, engineTempl, engineHtml:
+	default:
+		return fmt.Errorf("invalid template engine %q", name)
+	}
+	s.tplEngine = name
+	return nil
+}
+
+// templateOutput is the script handler to write the Go code generated for the
+// templates directory to the package directory dir (relative to the working
+// directory), instead of alongside the templates. The package name defaults to
+// the base name of dir.
+//
+// Templates in subdirectories of the templates directory are compiled into
+// the same package, and a templates.go file is written, aggregating the
+// generated template funcs by name.
+func (s *Script) templateOutput(dir string, pkg ...string) error {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.flags.Wd, dir)
+	}
+	s.tplOut, s.tplPkg = dir, filepath.Base(dir)
+	if len(pkg) != 0 {
+		s.tplPkg = pkg[0]
+	}
+	if !isValidIdentifier(s.tplPkg) {
+		return fmt.Errorf("invalid template package name %q", s.tplPkg)
+	}
+	return nil
+}
+
+// isTemplate determines if n is a template for the script's template engine.
+func (s *Script) isTemplate(n string) bool {
+	ext := filepath.Ext(n)
+	switch s.tplEngine {
+	case engineTempl:
+		return ext == ".templ"
+	case engineHtml:
+		return ext == ".html"
+	}
+	return ext == ".html" || ext == ".qtpl"
+}
+
+// minifyTemplates determines if the template n in dir should be minified.
+func (s *Script) minifyTemplates(dir, n string) bool {
+	return s.tplEngine != engineTempl && filepath.Ext(n) == ".html" && !s.skipHtmlmin(dir, n)
+}
+
+// tMatchRE returns the regexp matching translation calls (T) with raw
+// strings for the script's template engine.
+func (s *Script) tMatchRE() *regexp.Regexp {
+	if s.tplEngine == engineHtml {
+		return regexp.MustCompile(s.flags.TFuncName + "\\s+`[^`]+`")
+	}
+	return regexp.MustCompile(s.flags.TFuncName + "\\(`[^`]+`")
+}
+
+// tFixRE matches whitespace in translation calls.
+var tFixRE = regexp.MustCompile(`\s+`)
+
+// fixTranslations normalizes the whitespace in the translation calls (T) in
+// buf.
+func (s *Script) fixTranslations(buf []byte) []byte {
+	return s.tMatchRE().ReplaceAllFunc(buf, func(b []byte) []byte {
+		return tFixRE.ReplaceAll(b, []byte(" "))
+	})
+}
+
+// compileTemplates compiles the templates in dir with the script's template
+// engine. Only templates whose content (or template settings) changed since
+// the last run are minified and compiled.
+func (s *Script) compileTemplates(dir string) error {
+	all, changed, err := s.templateFiles(dir)
+	if err != nil {
+		return err
+	}
+	if err := s.pruneTemplates(dir, all); err != nil {
+		return err
+	}
+	if s.tplOut != "" {
+		if err := os.MkdirAll(s.tplOut, 0755); err != nil {
+			return err
+		}
+	}
+	if err := s.writeDefinesGo(dir); err != nil {
+		return err
+	}
+	if err := s.writeCssModulesGo(dir); err != nil {
+		return err
+	}
+	for _, n := range changed {
+		min, err := s.compileTemplate(dir, n)
+		if err != nil {
+			return err
+		}
+		if err := s.cacheTemplate(dir, n, min); err != nil {
+			return err
+		}
+	}
+	// record includes
+	for _, n := range all {
+		if s.tplEngine == engineHtml {
+			break
+		}
+		sources := []string{n}
+		if s.tplEngine != engineTempl {
+			includes, err := templateIncludes(n)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, includes...)
+		}
+		s.addDeps(s.depPath(s.templateGoFile(dir, n)), sources...)
+	}
+	switch {
+	case len(all) == 0:
+		return nil
+	case s.tplEngine != engineHtml && s.tplOut != "":
+		return s.writeTemplatesRegistry(dir, all)
+	case s.tplEngine != engineHtml:
+		return nil
+	}
+	// bundle the minified sources from the cache
+	sources := make(map[string][]byte)
+	for _, n := range all {
+		name, _ := filepath.Rel(dir, n)
+		buf, err := ioutil.ReadFile(s.templatesCachePath(dir, n) + ".min")
+		if err != nil {
+			return err
+		}
+		sources[filepath.ToSlash(name)] = buf
+	}
+	out := dir
+	if s.tplOut != "" {
+		out = s.tplOut
+	}
+	s.addDeps(s.depPath(filepath.Join(out, templatesFile)), all...)
+	return s.writeTemplatesGo(dir, sources)
+}
+
+// compileTemplate minifies and compiles the template n in dir, returning the
+// minified template.
+func (s *Script) compileTemplate(dir, n string) ([]byte, error) {
+	// read and minimize
+	buf, err := ioutil.ReadFile(n)
+	if err != nil {
+		return nil, err
+	}
+	min := buf
+	if s.minifyTemplates(dir, n) {
+		if min, err = s.minifyTemplate(buf); err != nil {
+			return nil, fmt.Errorf("could not minify %s: %w", n, err)
+		}
+		// keep template tags on their original lines
+		min = alignTemplateLines(templateTagREs[s.tplEngine], buf, min)
+	}
+	switch s.tplEngine {
+	case engineTempl:
+		err = s.compileTempl(dir, n)
+	case engineHtml:
+		min = s.fixTranslations(min)
+	default:
+		err = s.compileQtc(dir, n, min)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return min, nil
+}
+
+// templateFiles walks the templates in dir, returning all templates and those
+// whose compiled output is missing or out of date.
+func (s *Script) templateFiles(dir string) ([]string, []string, error) {
+	var all, changed []string
+	err := s.walkTemplates(dir, func(n string) error {
+		all = append(all, n)
+		hash, err := s.templateHash(dir, n)
+		if err != nil {
+			return err
+		}
+		// read cached hash
+		cacheFile := s.templatesCachePath(dir, n)
+		buf, err := ioutil.ReadFile(cacheFile + ".md5")
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			return err
+		case err != nil, string(buf) != hash, !fileExists(s.templateOut(dir, n, cacheFile)):
+			changed = append(changed, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return all, changed, nil
+}
+
+// templateHash returns the content hash for the template n in dir, and the
+// files it includes, combined with the settings used to compile it.
+func (s *Script) templateHash(dir, n string) (string, error) {
+	buf, err := ioutil.ReadFile(n)
+	if err != nil {
+		return "", err
+	}
+	var opts string
+	if s.minifyTemplates(dir, n) && s.htmlminOpts != nil {
+		opts = fmt.Sprintf("%s %v", s.flags.HtmlMinifier, s.htmlminOpts)
+	}
+	key := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", s.tplEngine, s.flags.TFuncName, opts, s.tplOut, s.tplPkg)
+	// add included files
+	if s.tplEngine != engineHtml && s.tplEngine != engineTempl {
+		includes, err := templateIncludes(n)
+		if err != nil {
+			return "", err
+		}
+		for _, z := range includes {
+			// missing includes are reported by qtc
+			hash := "missing"
+			switch buf, err := ioutil.ReadFile(z); {
+			case err == nil:
+				hash = fmt.Sprintf("%x", md5.Sum(buf))
+			case !os.IsNotExist(err):
+				return "", err
+			}
+			key += fmt.Sprintf("%s %s\n", z, hash)
+		}
+	}
+	return fmt.Sprintf("%x", md5.Sum(append([]byte(key), buf...))), nil
+}
+
+// templateOut returns the compiled output for the template n in dir.
+func (s *Script) templateOut(dir, n, cacheFile string) string {
+	if s.tplEngine == engineHtml {
+		return cacheFile + ".min"
+	}
+	return s.templateGoFile(dir, n)
+}
+
+// templateGoFile returns the generated Go file for the template n in dir.
+// When writing to a template output package, templates in subdirectories
+// are flattened (ie, email/foo.html is written to email_foo.html.go).
+func (s *Script) templateGoFile(dir, n string) string {
+	out := n + ".go"
+	if s.tplEngine == engineTempl {
+		out = strings.TrimSuffix(n, ".templ") + "_templ.go"
+	}
+	if s.tplOut == "" {
+		return out
+	}
+	rel, _ := filepath.Rel(dir, out)
+	return filepath.Join(s.tplOut, strings.ReplaceAll(filepath.ToSlash(rel), "/", "_"))
+}
+
+// templatePkg returns the package name for the generated Go code for the
+// templates in the directory d.
+func (s *Script) templatePkg(d string) string {
+	if s.tplPkg != "" {
+		return s.tplPkg
+	}
+	return filepath.Base(d)
+}
+
+// cacheTemplate writes the content hash of the template n in dir to the
+// cache, along with its minified contents when bundling with html/template.
+func (s *Script) cacheTemplate(dir, n string, min []byte) error {
+	hash, err := s.templateHash(dir, n)
+	if err != nil {
+		return err
+	}
+	cacheFile := s.templatesCachePath(dir, n)
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		return err
+	}
+	if s.tplEngine == engineHtml {
+		if err := ioutil.WriteFile(cacheFile+".min", min, 0644); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(cacheFile+".md5", []byte(hash), 0644)
+}
+
+// templatesCachePath returns the cache path for the template n in dir.
+func (s *Script) templatesCachePath(dir, n string) string {
+	fn, _ := filepath.Rel(dir, n)
+	return filepath.Join(s.templatesCacheDir(dir), fn)
+}
+
+// templatesCacheDir returns the cache directory for the templates in dir.
+//
+// The cache directory is keyed by the absolute templates directory, as the
+// cache directory can be shared between projects (see ASSETGEN_CACHE).
+func (s *Script) templatesCacheDir(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	key := fmt.Sprintf("%x", md5.Sum([]byte(abs)))
+	return filepath.Join(s.flags.Cache, templatesDir, key[:16])
+}
+
+// pruneTemplates removes the cached hashes of templates in dir that are no
+// longer in all (ie, deleted, renamed or ignored templates), along with the
+// Go code generated for them.
+func (s *Script) pruneTemplates(dir string, all []string) error {
+	keep := make(map[string]bool)
+	for _, n := range all {
+		keep[n] = true
+	}
+	cacheDir := s.templatesCacheDir(dir)
+	if !fileExists(cacheDir) {
+		return nil
+	}
+	var stale []string
+	err := filepath.Walk(cacheDir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() || !strings.HasSuffix(n, ".md5"):
+			return nil
+		}
+		fn, err := filepath.Rel(cacheDir, strings.TrimSuffix(n, ".md5"))
+		if err != nil {
+			return err
+		}
+		if src := filepath.Join(dir, fn); !keep[src] {
+			stale = append(stale, src)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not walk %s: %w", cacheDir, err)
+	}
+	for _, n := range stale {
+		cacheFile := s.templatesCachePath(dir, n)
+		files := []string{cacheFile + ".min", cacheFile + ".md5"}
+		if s.tplEngine != engineHtml {
+			files = append([]string{s.templateGoFile(dir, n)}, files...)
+		}
+		for _, f := range files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("could not remove %s: %w", f, err)
+			}
+		}
+		debugf(s.flags, "pruned stale template %s", n)
+	}
+	return nil
+}
+
+// walkTemplates walks the templates in dir, calling f for each.
+func (s *Script) walkTemplates(dir string, f func(string) error) error {
+	return walk(s.flags, dir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case s.ignored(n, fi):
+			return skipDir(fi)
+		case fi.IsDir() || !s.isTemplate(n):
+			return nil
+		}
+		return f(n)
+	})
+}
+
+// compileQtc compiles the quicktemplate n in dir (with minified contents min)
+// using qtc, writing the generated Go code to n.go (or the template output
+// package).
+func (s *Script) compileQtc(dir, n string, min []byte) error {
+	// generate go template (qtc's parser resolves included files relative to
+	// the template's path, so pass the full path, and strip it from the line
+	// comments)
+	out := new(bytes.Buffer)
+	if err := qtcparser.Parse(out, bytes.NewReader(min), n, s.templatePkg(filepath.Dir(n))); err != nil {
+		return newQtcError(err)
+	}
+	buf := bytes.ReplaceAll(out.Bytes(), []byte("//line "+filepath.ToSlash(n)+":"), []byte("//line "+filepath.Base(n)+":"))
+	// fix T(``) strings
+	return writeGo(s.flags, s.templateGoFile(dir, n), s.fixTranslations(buf))
+}
+
+// compileTempl compiles the templ component n in dir using templ, normalizing
+// the translation calls in the generated Go code, and moving it to the
+// template output package.
+func (s *Script) compileTempl(dir, n string) error {
+	if err := runSilent(s.flags, "templ", "generate", "-f", n); err != nil {
+		return fmt.Errorf("could not run templ: %w", err)
+	}
+	gen := strings.TrimSuffix(n, ".templ") + "_templ.go"
+	buf, err := ioutil.ReadFile(gen)
+	if err != nil {
+		return err
+	}
+	out := s.templateGoFile(dir, n)
+	if out != gen {
+		buf = goPackageRE.ReplaceAll(buf, []byte("package "+s.tplPkg))
+		if err := os.Remove(gen); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(out, s.fixTranslations(buf), 0644)
+}
+
+// goPackageRE matches a Go package clause.
+var goPackageRE = regexp.MustCompile(`(?m)^package\s+\w+`)
+
+// writeTemplatesGo writes the html/template bundle for the template sources
+// in dir to the templates.go file in dir (or the template output package).
+func (s *Script) writeTemplatesGo(dir string, sources map[string][]byte) error {
+	var names []string
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var entries []string
+	for _, name := range names {
+		entries = append(entries, fmt.Sprintf("\t%q: %q,", name, sources[name]))
+	}
+	out := dir
+	if s.tplOut != "" {
+		out = s.tplOut
+	}
+	return writeGo(
+		s.flags,
+		filepath.Join(out, templatesFile),
+		[]byte(tplf(templatesFile, s.templatePkg(dir), strings.Join(entries, "\n"))),
+	)
+}
+
+// writeTemplatesRegistry writes the registry of the exported funcs in the Go
+// code generated for the templates in dir to the templates.go file in the
+// template output package.
+func (s *Script) writeTemplatesRegistry(dir string, files []string) error {
+	var names []string
+	for _, n := range files {
+		f, err := goparser.ParseFile(token.NewFileSet(), s.templateGoFile(dir, n), nil, 0)
+		if err != nil {
+			return err
+		}
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.IsExported() {
+				names = append(names, fd.Name.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	var entries []string
+	for _, name := range names {
+		entries = append(entries, fmt.Sprintf("\t%q: %s,", name, name))
+	}
+	return writeGo(
+		s.flags,
+		filepath.Join(s.tplOut, templatesFile),
+		[]byte(tplf(registryFile, s.tplPkg, strings.Join(entries, "\n"))),
+	)
+}
@@ -0,0 +1,73 @@
+package gen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneTemplates(t *testing.T) {
+	tests := []struct {
+		engine string
+		out    bool
+		files  []string
+		stale  []string
+	}{
+		{engineQtc, false, []string{"a.html", "b.html", "sub/c.qtpl"}, []string{"templates/b.html.go", "templates/sub/c.qtpl.go"}},
+		{engineQtc, true, []string{"a.html", "sub/b.html"}, []string{"out/sub_b.html.go"}},
+		{engineTempl, false, []string{"a.templ", "b.templ"}, []string{"templates/b_templ.go"}},
+		{engineHtml, false, []string{"a.html", "b.html"}, nil},
+	}
+	for i, test := range tests {
+		dir := t.TempDir()
+		tpl := filepath.Join(dir, "templates")
+		flags := &Flags{Wd: dir, Cache: filepath.Join(dir, "cache")}
+		flags.Logger, _ = NewLogger(ioutil.Discard, LogQuiet, "")
+		s := &Script{flags: flags, tplEngine: test.engine}
+		if test.out {
+			s.tplOut, s.tplPkg = filepath.Join(dir, "out"), "out"
+		}
+		var all []string
+		for _, f := range test.files {
+			n := filepath.Join(tpl, filepath.FromSlash(f))
+			for _, name := range []string{n, s.templateGoFile(tpl, n)} {
+				if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+					t.Fatalf("test %d expected no error, got: %v", i, err)
+				}
+				if err := ioutil.WriteFile(name, []byte(f), 0o644); err != nil {
+					t.Fatalf("test %d expected no error, got: %v", i, err)
+				}
+			}
+			if err := s.cacheTemplate(tpl, n, []byte(f)); err != nil {
+				t.Fatalf("test %d expected no error, got: %v", i, err)
+			}
+			all = append(all, n)
+		}
+		// remove all but the first template
+		for _, n := range all[1:] {
+			if err := os.Remove(n); err != nil {
+				t.Fatalf("test %d expected no error, got: %v", i, err)
+			}
+		}
+		if err := s.pruneTemplates(tpl, all[:1]); err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		for _, n := range all[1:] {
+			if fileExists(s.templatesCachePath(tpl, n) + ".md5") {
+				t.Errorf("test %d expected cached hash for %s to be removed", i, n)
+			}
+		}
+		for _, f := range test.stale {
+			if fileExists(filepath.Join(dir, filepath.FromSlash(f))) {
+				t.Errorf("test %d expected %s to be removed", i, f)
+			}
+		}
+		if s.tplEngine != engineHtml && !fileExists(s.templateGoFile(tpl, all[0])) {
+			t.Errorf("test %d expected %s to be kept", i, s.templateGoFile(tpl, all[0]))
+		}
+		if !fileExists(s.templatesCachePath(tpl, all[0]) + ".md5") {
+			t.Errorf("test %d expected cached hash for %s to be kept", i, all[0])
+		}
+	}
+}
@@ -6,3 +6,8 @@ import (
 
 //go:embed tpl/*
 var tpl embed.FS
+
+// starters are the starter assets trees used by the init command.
+//
+//go:embed starters
+var starters embed.FS
@@ -0,0 +1,9 @@
+var fs = require('fs');
+var zlib = require('zlib');
+
+// usage: node brotli.js <file>...
+//
+// prints the brotli compressed size of each file, as a json array
+console.log(JSON.stringify(process.argv.slice(2).map(function(n) {
+  return zlib.brotliCompressSync(fs.readFileSync(n)).length;
+})));
@@ -0,0 +1,26 @@
+var fs = require('fs');
+var path = require('path');
+
+// usage: node emails.js <in> <out>
+var args = process.argv.slice(2);
+if (args.length !== 2) {
+  console.error('error:', 'usage: emails.js <in> <out>');
+  process.exit(1);
+}
+
+var src = fs.readFileSync(args[0], 'utf8');
+var html;
+if (path.extname(args[0]) === '.mjml') {
+  var res = require('mjml')(src, {filePath: args[0]});
+  if (res.errors && res.errors.length) {
+    res.errors.forEach(function(e) {
+      console.error('error:', args[0] + ':' + e.line + ':', e.message);
+    });
+    process.exit(1);
+  }
+  html = res.html;
+} else {
+  html = require('juice')(src);
+}
+
+fs.writeFileSync(args[1], html);
@@ -0,0 +1,16 @@
+var fs = require('fs');
+var fontverter = require('fontverter');
+
+// usage: node fontconvert.js <in> <out> <format>
+var args = process.argv.slice(2);
+if (args.length !== 3) {
+  console.error('error:', 'usage: fontconvert.js <in> <out> <format>');
+  process.exit(1);
+}
+
+fontverter.convert(fs.readFileSync(args[0]), args[2]).then(function(buf) {
+  fs.writeFileSync(args[1], buf);
+}).catch(function(e) {
+  console.error('error:', e);
+  process.exit(1);
+});
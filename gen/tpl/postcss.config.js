@@ -1,10 +1,29 @@
-module.exports = {
-  plugins: [
-    require('tailwindcss')(%q),
-    require('autoprefixer'),
-    require('@fullhuman/postcss-purgecss')({
+var fs = require('fs');
+
+// scopedName is the css modules scoped class name pattern, or empty when css
+// modules are disabled.
+var scopedName = %q;
+
+module.exports = (ctx) => {
+  // css modules (*.module.css) are locally scoped, and are not purged
+  var isModule = scopedName !== '' && ctx.file && /\.module\.css$/.test(ctx.file.basename);
+  var plugins = [
+    %s
+  ];
+  if (isModule) {
+    plugins.push(require('postcss-modules')({
+      generateScopedName: scopedName,
+      getJSON: (cssFileName, json) => {
+        fs.writeFileSync(cssFileName.replace(/\.css$/, '.classes.json'), JSON.stringify(json));
+      },
+    }));
+  }
+  plugins.push(require('autoprefixer'));
+  if (!isModule) {
+    plugins.push(require('@fullhuman/postcss-purgecss')({
       content: [%q+'/*.html'],
       defaultExtractor: (content) => content.match(/[\w-/:]+(?<!:)/g) || [],
-    })
-  ]
+    }));
+  }
+  return {plugins: plugins};
 };
@@ -7,31 +7,66 @@ if (!process.env.ASSETGEN_SOCK) {
   process.exit(1);
 }
 
-// doReq sends a request to the ipc callback server.
-function doReq(params, callback) {
-  var cl = net.createConnection(process.env.ASSETGEN_SOCK)
-  cl.on('connect', function() {
-    // connect
-    cl.write(JSON.stringify(params) + '\n');
-  });
-  cl.on('data', function(data) {
-    // data
-    var p = JSON.parse(data);
-    if (p && p.error) {
-      console.error('error:', p.error);
-      process.exit(1);
-    } else if (p && !p.result) {
-      console.error('error: missing result');
-      process.exit(1);
+// sockOpts returns the connection options for the ipc callback server, which
+// is either a socket path or a tcp://host:port address.
+function sockOpts(sock) {
+  var m = /^tcp:\/\/(.+):([0-9]+)$/.exec(sock);
+  if (m) {
+    return {host : m[1], port : parseInt(m[2], 10)};
+  }
+  return {path : sock};
+}
+
+// conn is the persistent connection to the ipc callback server, and pending
+// are the callbacks for outstanding requests, keyed by request id.
+var conn = null, pending = {}, npending = 0, nextId = 1, buf = '';
+
+// getConn returns the connection to the ipc callback server, creating it if
+// necessary.
+function getConn() {
+  if (conn) {
+    return conn;
+  }
+  conn = net.createConnection(sockOpts(process.env.ASSETGEN_SOCK));
+  conn.setEncoding('utf8');
+  conn.on('data', function(data) {
+    buf += data;
+    for (var i = buf.indexOf('\n'); i != -1; i = buf.indexOf('\n')) {
+      var p = JSON.parse(buf.slice(0, i));
+      buf = buf.slice(i + 1);
+      if (p && p.error) {
+        console.error('error:', p.error);
+        process.exit(1);
+      } else if (p && !p.result) {
+        console.error('error: missing result');
+        process.exit(1);
+      }
+      var callback = pending[p.id];
+      delete pending[p.id];
+      // do not keep the process alive without outstanding requests
+      if (--npending == 0) {
+        conn.unref();
+      }
+      callback(p.result);
     }
-    callback(p.result);
-    cl.destroy();
   });
-  cl.on('error', function(e) {
-    cl.destroy();
+  conn.on('error', function(e) {
+    conn.destroy();
     console.error('error:', e);
     process.exit(1);
   });
+  return conn;
+}
+
+// doReq sends a request to the ipc callback server.
+function doReq(params, callback) {
+  params.id = nextId++;
+  params.token = process.env.ASSETGEN_TOKEN;
+  pending[params.id] = callback;
+  npending++;
+  var cl = getConn();
+  cl.ref();
+  cl.write(JSON.stringify(params) + '\n');
 }
 
 // conv handles recursively converting a sass type.
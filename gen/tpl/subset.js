@@ -0,0 +1,37 @@
+var fs = require('fs');
+var path = require('path');
+var subsetFont = require('subset-font');
+
+// usage: node subset.js <in> <out> <codepoints>
+var args = process.argv.slice(2);
+if (args.length !== 3) {
+  console.error('error:', 'usage: subset.js <in> <out> <codepoints>');
+  process.exit(1);
+}
+
+// target formats by extension
+var formats = {
+  '.woff2': 'woff2',
+  '.woff': 'woff',
+  '.ttf': 'truetype'
+};
+
+// codepoints are hex, or hex ranges (ie, 20-7e)
+var text = '';
+args[2].split(',').filter(function(c) {
+  return c !== '';
+}).forEach(function(c) {
+  var r = c.split('-'), end = parseInt(r[r.length - 1], 16);
+  for (var i = parseInt(r[0], 16); i <= end; i++) {
+    text += String.fromCodePoint(i);
+  }
+});
+
+subsetFont(fs.readFileSync(args[0]), text, {
+  targetFormat: formats[path.extname(args[0])]
+}).then(function(buf) {
+  fs.writeFileSync(args[1], buf);
+}).catch(function(e) {
+  console.error('error:', e);
+  process.exit(1);
+});
@@ -6,37 +6,56 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go/format"
 	"io"
 	"io/fs"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
 
 	"github.com/Masterminds/semver"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
 )
 
 // infof handles logging information.
 func infof(flags *Flags, s string, v ...interface{}) {
-	if flags.Verbose {
-		log.Printf(s, v...)
-	}
+	flags.Logger.Logf(LogNormal, s, v...)
 }
 
 // warnf handles logging warnings.
 func warnf(flags *Flags, s string, v ...interface{}) {
-	if flags.Verbose {
-		log.Printf("WARNING: "+s, v...)
-	}
+	flags.Logger.Logf(LogWarn, s, v...)
+}
+
+// debugf handles logging debugging messages.
+func debugf(flags *Flags, s string, v ...interface{}) {
+	flags.Logger.Logf(LogDebug, s, v...)
+}
+
+// commandf handles logging executed commands.
+func commandf(flags *Flags, name string, params ...string) {
+	flags.Logger.Logf(LogVerbose, "%s", formatCommand(name, params...))
 }
 
 // formatCommand formats the command output
@@ -51,36 +70,102 @@ func formatCommand(name string, params ...string) string {
 	return name + paramstr
 }
 
+// newCmd creates a command for name with params, using the build's context,
+// working directory, and environment.
+//
+// With the bun runtime, node package executables are run with bunx, using
+// bun's runtime instead of the node shim.
+func newCmd(flags *Flags, name string, params ...string) *exec.Cmd {
+	bin := lookPath(flags, name)
+	if flags.bun && isNodeModulesBin(flags, bin) {
+		bin, params = flags.BunBin, append([]string{"x", "--bun", name}, params...)
+	}
+	cmd := exec.CommandContext(flags.ctx, bin, params...)
+	cmd.Dir = flags.Wd
+	cmd.Env = environ(flags)
+	return cmd
+}
+
+// isNodeModulesBin determines if bin is an executable in one of the
+// node_modules/.bin directories.
+func isNodeModulesBin(flags *Flags, bin string) bool {
+	dirs := []string{flags.NodeModulesBin}
+	for _, dir := range nodeModulesDirs(flags) {
+		dirs = append(dirs, filepath.Join(dir, nodeModulesBinDir))
+	}
+	for _, dir := range dirs {
+		if dir != "" && filepath.Dir(bin) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// environ returns the environment for child processes, with the build's path
+// directories prepended to PATH.
+func environ(flags *Flags) []string {
+	env := os.Environ()
+	if len(flags.path) != 0 {
+		dirs := append(append([]string(nil), flags.path...), os.Getenv("PATH"))
+		env = append(env, "PATH="+strings.Join(dirs, string(os.PathListSeparator)))
+	}
+	return append(env, flags.env...)
+}
+
+// lookPath looks for the named executable in the build's path directories,
+// returning name when not found.
+func lookPath(flags *Flags, name string) string {
+	if strings.ContainsAny(name, `/\`) {
+		return name
+	}
+	exts := []string{""}
+	if runtime.GOOS == "windows" {
+		exts = []string{".exe", ".cmd", ".bat"}
+	}
+	for _, dir := range flags.path {
+		for _, ext := range exts {
+			n := filepath.Join(dir, name+ext)
+			if fi, err := os.Stat(n); err == nil && !fi.IsDir() {
+				return n
+			}
+		}
+	}
+	return name
+}
+
 // run runs command name with params.
+//
+// When the command fails, a *StepError with the command's stderr is returned.
 func run(flags *Flags, name string, params ...string) error {
-	if flags.Verbose {
-		fmt.Fprintln(os.Stdout, formatCommand(name, params...))
+	commandf(flags, name, params...)
+	stderr := newTailBuffer(stepErrorMaxOutput)
+	cmd := newCmd(flags, name, params...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, io.MultiWriter(os.Stderr, stderr)
+	if err := cmd.Run(); err != nil {
+		return newStepError(name, params, stderr.Bytes(), err)
 	}
-	cmd := exec.Command(name, params...)
-	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-	cmd.Dir = flags.Wd
-	return cmd.Run()
+	return nil
 }
 
 // runSilent runs command name with params silently (ie, stdout is discarded).
+//
+// When the command fails, a *StepError with the command's stderr is returned.
 func runSilent(flags *Flags, name string, params ...string) error {
-	if flags.Verbose {
-		fmt.Fprintln(os.Stdout, formatCommand(name, params...))
+	commandf(flags, name, params...)
+	stderr := newTailBuffer(stepErrorMaxOutput)
+	cmd := newCmd(flags, name, params...)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return newStepError(name, params, stderr.Bytes(), err)
 	}
-	cmd := exec.Command(name, params...)
-	cmd.Dir = flags.Wd
-	return cmd.Run()
+	return nil
 }
 
 // runCombined runs command name with params, returning the trimmed, combined
 // output of stdout and stderr.
 func runCombined(flags *Flags, name string, params ...string) (string, error) {
-	if flags.Verbose {
-		fmt.Fprintln(os.Stdout, formatCommand(name, params...))
-	}
-	cmd := exec.Command(name, params...)
-	cmd.Dir = flags.Wd
-	buf, err := cmd.CombinedOutput()
+	commandf(flags, name, params...)
+	buf, err := newCmd(flags, name, params...).CombinedOutput()
 	return string(bytes.TrimSpace(buf)), err
 }
 
@@ -88,7 +173,7 @@ func runCombined(flags *Flags, name string, params ...string) (string, error) {
 func compareSemver(version, constraint string) bool {
 	c, err := semver.NewConstraint(constraint)
 	if err != nil {
-		panic(fmt.Sprintf("invalid constraint %q: %w", constraint, err))
+		panic(fmt.Sprintf("invalid constraint %q: %v", constraint, err))
 	}
 	return c.Check(semver.MustParse(version))
 }
@@ -201,27 +286,77 @@ func forceString(v interface{}) string {
 	default:
 		return fmt.Sprintf("%v", z)
 	}
-	return ""
+}
+
+// htmlminDefaults are the default html-minifier options.
+var htmlminDefaults = map[string]interface{}{
+	"collapseBooleanAttributes":     true,
+	"collapseWhitespace":            true,
+	"removeComments":                true,
+	"removeAttributeQuotes":         true,
+	"removeScriptTypeAttributes":    true,
+	"removeStyleLinkTypeAttributes": true,
+	"minifyCSS":                     true,
+	"minifyJS":                      true,
+	"ignoreCustomFragments":         []interface{}{},
+	"trimCustomFragments":           true,
+}
+
+// htmlminParams returns the html-minifier params for the options. Template
+// tags matched by tagRE are always added to the ignored custom fragments.
+func htmlminParams(opts map[string]interface{}, tagRE *regexp.Regexp) []string {
+	var keys []string
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		name := "--" + kebabCase(k)
+		v := opts[k]
+		if k == "ignoreCustomFragments" {
+			z, _ := v.([]interface{})
+			v = append([]interface{}{tagRE.String()}, z...)
+		}
+		switch x := v.(type) {
+		case bool:
+			if x {
+				params = append(params, name)
+			}
+		case []interface{}:
+			buf := new(bytes.Buffer)
+			enc := json.NewEncoder(buf)
+			enc.SetEscapeHTML(false)
+			_ = enc.Encode(x)
+			params = append(params, name+"="+strings.TrimSpace(buf.String()))
+		default:
+			params = append(params, name+"="+fmt.Sprint(x))
+		}
+	}
+	return params
+}
+
+// kebabCase converts a camel case name (ie, minifyCSS) to kebab case (ie,
+// minify-css).
+func kebabCase(s string) string {
+	var sb strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i != 0 && !unicode.IsUpper(rune(s[i-1])) {
+				sb.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
 }
 
 // htmlmin passes the supplied byte slice to html-minifier's stdin, returning
 // the output.
-func htmlmin(flags *Flags, buf []byte) ([]byte, error) {
-	cmd := exec.Command(
-		"html-minifier",
-		"--collapse-boolean-attributes",
-		"--collapse-whitespace",
-		"--remove-comments",
-		"--remove-attribute-quotes",
-		"--remove-script-type-attributes",
-		"--remove-style-link-type-attributes",
-		"--minify-css",
-		"--minify-js",
-		`--ignore-custom-fragments="\\{%[^%]+%\\}"`,
-		"--trim-custom-fragments",
-	)
+func htmlmin(flags *Flags, params []string, buf []byte) ([]byte, error) {
+	cmd := newCmd(flags, "html-minifier", params...)
 	cmd.Stdin = bytes.NewReader(buf)
-	cmd.Dir = flags.Wd
 	out, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
@@ -239,6 +374,119 @@ func htmlmin(flags *Flags, buf []byte) ([]byte, error) {
 	return buf, nil
 }
 
+// alignTemplateLines inserts line breaks at the start of the template tags
+// matched by tagRE in the minified template min, so that each tag is on the
+// same line as in the original template orig. As such, the line numbers in
+// template compiler errors and the generated line comments refer to the
+// original template. Returns min unchanged when the template tags cannot be
+// matched.
+func alignTemplateLines(tagRE *regexp.Regexp, orig, min []byte) []byte {
+	origTags, minTags := tagRE.FindAllIndex(orig, -1), tagRE.FindAllIndex(min, -1)
+	if len(origTags) != len(minTags) {
+		return min
+	}
+	nl := []byte("\n")
+	buf := make([]byte, 0, len(min)+bytes.Count(orig, nl))
+	var origLine, origLast, line, last int
+	for k, m := range minTags {
+		origLine += bytes.Count(orig[origLast:origTags[k][0]], nl)
+		origLast = origTags[k][0]
+		// copy up to and including the tag's opening delimiter and trim
+		// marker, if any
+		i := m[0] + 2
+		if min[i] == '-' && min[i+1] == ' ' {
+			i++
+		}
+		line += bytes.Count(min[last:i], nl)
+		buf, last = append(buf, min[last:i]...), i
+		// comments must immediately follow the delimiter
+		if bytes.HasPrefix(bytes.TrimLeft(min[i:m[1]], " "), []byte("/*")) {
+			continue
+		}
+		if n := origLine - line; n > 0 {
+			buf, line = append(buf, bytes.Repeat(nl, n)...), line+n
+		}
+	}
+	return append(buf, min[last:]...)
+}
+
+// htmlminGo minifies buf using the pure Go minifier, applying the applicable
+// html-minifier options. Template tags matched by tagRE and the ignored custom
+// fragments are replaced with placeholders while minifying, and attribute
+// quotes are always kept.
+func htmlminGo(opts map[string]interface{}, tagRE *regexp.Regexp, buf []byte) ([]byte, error) {
+	fragRE, err := htmlminFragmentsRE(opts, tagRE)
+	if err != nil {
+		return nil, err
+	}
+	opt := func(name string) bool {
+		v, _ := opts[name].(bool)
+		return v
+	}
+	m := minify.New()
+	m.Add("text/html", &html.Minifier{
+		KeepComments:        !opt("removeComments"),
+		KeepDefaultAttrVals: true,
+		KeepDocumentTags:    true,
+		KeepEndTags:         true,
+		KeepQuotes:          true,
+		KeepWhitespace:      !opt("collapseWhitespace"),
+	})
+	if opt("minifyCSS") {
+		m.AddFunc("text/css", css.Minify)
+	}
+	if opt("minifyJS") {
+		m.AddFuncRegexp(regexp.MustCompile("^(application|text)/(x-)?(java|ecma)script$"), js.Minify)
+	}
+	// replace template tags and ignored fragments
+	var tags [][]byte
+	buf = fragRE.ReplaceAllFunc(buf, func(b []byte) []byte {
+		tags = append(tags, b)
+		return []byte(fmt.Sprintf("__assetgen_tpl_%d__", len(tags)-1))
+	})
+	out, err := m.Bytes("text/html", buf)
+	if err != nil {
+		return nil, err
+	}
+	// restore template tags
+	return templatePlaceholderRE.ReplaceAllFunc(out, func(b []byte) []byte {
+		i, err := strconv.Atoi(string(b[len("__assetgen_tpl_") : len(b)-2]))
+		if err != nil || i >= len(tags) {
+			return b
+		}
+		return tags[i]
+	}), nil
+}
+
+// htmlminFragmentsRE returns a regexp matching the template tags matched by
+// tagRE, or the patterns of html-minifier's ignoreCustomFragments option.
+// The patterns must be valid Go regular expressions.
+func htmlminFragmentsRE(opts map[string]interface{}, tagRE *regexp.Regexp) (*regexp.Regexp, error) {
+	z, _ := opts["ignoreCustomFragments"].([]interface{})
+	if len(z) == 0 {
+		return tagRE, nil
+	}
+	var pats []string
+	if tagRE != nil {
+		pats = append(pats, tagRE.String())
+	}
+	for _, v := range z {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid ignoreCustomFragments pattern %v", v)
+		}
+		if _, err := regexp.Compile(s); err != nil {
+			return nil, fmt.Errorf("invalid ignoreCustomFragments pattern %q: %w", s, err)
+		}
+		pats = append(pats, s)
+	}
+	return regexp.Compile("(?:" + strings.Join(pats, ")|(?:") + ")")
+}
+
+// templatePlaceholderRE matches the template tag placeholders used by
+// htmlminGo.
+var templatePlaceholderRE = regexp.MustCompile(`__assetgen_tpl_[0-9]+__`)
+
 // isValidIdentifier determines if s is a valid Go identifier.
 func isValidIdentifier(s string) bool {
 	if len(s) == 0 || !unicode.IsLetter([]rune(s[0:1])[0]) {
@@ -267,6 +515,11 @@ func md5hash(file string) (string, error) {
 	return fmt.Sprintf("%x", md5.Sum(buf)), nil
 }
 
+// sha256hex returns the sha256 hash of buf in hex format.
+func sha256hex(buf []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(buf))
+}
+
 // templates are loaded file assets used by assetgen.
 var templates map[string][]byte
 
@@ -301,6 +554,27 @@ func tplf(name string, v ...interface{}) string {
 	return fmt.Sprintf(string(t), v...)
 }
 
+// writeChanged writes buf to name, leaving name untouched when its contents
+// are unchanged.
+func writeChanged(name string, buf []byte) error {
+	if prev, err := ioutil.ReadFile(name); err == nil && bytes.Equal(prev, buf) {
+		return nil
+	}
+	return ioutil.WriteFile(name, buf, 0644)
+}
+
+// writeGo formats the generated Go code buf with go/format, and writes it to
+// name when changed, recording its package directory for -verify-go. Nothing
+// is written when the code cannot be formatted.
+func writeGo(flags *Flags, name string, buf []byte) error {
+	out, err := format.Source(buf)
+	if err != nil {
+		return newGoFormatError(name, buf, err)
+	}
+	flags.goDirs = append(flags.goDirs, filepath.Dir(name))
+	return writeChanged(name, out)
+}
+
 // fileExists returns true if name exists on disk.
 func fileExists(name string) bool {
 	_, err := os.Stat(name)
@@ -311,7 +585,23 @@ func fileExists(name string) bool {
 }
 
 // getAndCache retrieves the specified file, caching it to the specified path.
+//
+// When flags.Vendored is set, only the vendored copy is used.
+//
+// Cached files are validated against their recorded checksum before reuse.
+// Downloads are retried with backoff, and partial downloads of files that do
+// not expire (ie, ttl is 0) are resumed.
 func getAndCache(flags *Flags, urlstr string, ttl time.Duration, b64decode bool, names ...string) ([]byte, error) {
+	// only use vendored files
+	if flags.Vendored {
+		n := pathJoin(flags.Vendor, append([]string{"cache"}, names...)...)
+		buf, err := readCached(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not vendored: %w", urlstr, err)
+		}
+		debugf(flags, "VENDORED: %s -> %s", urlstr, n)
+		return buf, nil
+	}
 	n := pathJoin(flags.Cache, names...)
 	cd := filepath.Dir(n)
 	err := os.MkdirAll(cd, 0755)
@@ -320,29 +610,53 @@ func getAndCache(flags *Flags, urlstr string, ttl time.Duration, b64decode bool,
 	}
 	// check if file exists on disk
 	fi, err := os.Stat(n)
+	var stale bool
 	switch {
 	case os.IsNotExist(err):
 	case err != nil:
 		return nil, err
 	case ttl == 0 || !time.Now().After(fi.ModTime().Add(ttl)):
-		return ioutil.ReadFile(n)
+		buf, err := readCached(n)
+		if err == nil {
+			debugf(flags, "CACHED: %s -> %s", urlstr, n)
+			return buf, nil
+		}
+		warnf(flags, "%v: retrieving again", err)
+	default:
+		stale = true
+	}
+	// load validators for conditional request
+	var meta cacheMeta
+	if stale {
+		if _, err := readCached(n); err == nil {
+			meta = readCacheMeta(n)
+		}
 	}
 	infof(flags, "RETRIEVING: %s", urlstr)
 	// retrieve
-	cl := &http.Client{}
-	req, err := http.NewRequest("GET", urlstr, nil)
-	if err != nil {
-		return nil, err
+	part := n + ".part"
+	if ttl != 0 {
+		if err := os.RemoveAll(part); err != nil {
+			return nil, err
+		}
 	}
-	res, err := cl.Do(req)
-	if err != nil {
+	err = httpGet(flags, urlstr, part, &meta)
+	var rle *rateLimitError
+	switch {
+	case err != nil && stale && errors.As(err, &rle):
+		warnf(flags, "%v: using cached %s", err, n)
+		return readCached(n)
+	case err != nil:
 		return nil, err
+	case meta.notModified:
+		infof(flags, "NOT MODIFIED: %s", urlstr)
+		now := time.Now()
+		if err := os.Chtimes(n, now, now); err != nil {
+			return nil, err
+		}
+		return readCached(n)
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("could not retrieve %q (%d)", urlstr, res.StatusCode)
-	}
-	buf, err := ioutil.ReadAll(res.Body)
+	buf, err := ioutil.ReadFile(part)
 	if err != nil {
 		return nil, err
 	}
@@ -357,9 +671,337 @@ func getAndCache(flags *Flags, urlstr string, ttl time.Duration, b64decode bool,
 	if err := ioutil.WriteFile(n, buf, 0644); err != nil {
 		return nil, err
 	}
+	if err := ioutil.WriteFile(n+".sha256", []byte(sha256hex(buf)), 0644); err != nil {
+		return nil, err
+	}
+	if err := writeCacheMeta(n, meta); err != nil {
+		return nil, err
+	}
+	flags.downloads = append(flags.downloads, download{urlstr: urlstr, path: n, size: int64(len(buf))})
+	if err := os.Remove(part); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// download holds information about a retrieved file.
+type download struct {
+	urlstr string
+	path   string
+	size   int64
+}
+
+// timing holds the time taken by a build step.
+type timing struct {
+	name string
+	d    time.Duration
+}
+
+// timed runs f, recording the time it took as name.
+func timed(flags *Flags, name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	flags.timings = append(flags.timings, timing{name: name, d: time.Since(start)})
+	return err
+}
+
+// readCached reads the cached file n, verifying its contents against the
+// recorded checksum (when present).
+func readCached(n string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(n)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := ioutil.ReadFile(n + ".sha256")
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return buf, nil
+	case err != nil:
+		return nil, err
+	}
+	if hash := sha256hex(buf); hash != strings.TrimSpace(string(sum)) {
+		return nil, fmt.Errorf("cached %s does not match its checksum", n)
+	}
 	return buf, nil
 }
 
+// cacheMeta holds the http validators for a cached file.
+type cacheMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// notModified is set when a conditional request was not modified.
+	notModified bool
+}
+
+// readCacheMeta reads the http validators for the cached file n.
+func readCacheMeta(n string) cacheMeta {
+	var meta cacheMeta
+	if buf, err := ioutil.ReadFile(n + ".meta"); err == nil {
+		_ = json.Unmarshal(buf, &meta)
+	}
+	return meta
+}
+
+// writeCacheMeta writes the http validators for the cached file n.
+func writeCacheMeta(n string, meta cacheMeta) error {
+	if meta.ETag == "" && meta.LastModified == "" {
+		if err := os.Remove(n + ".meta"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(n+".meta", buf, 0644)
+}
+
+// httpGet retrieves urlstr to the file part, retrying failed requests with
+// exponential backoff (up to flags.Retries times).
+//
+// When meta has validators, a conditional request is made. meta is updated
+// with the validators of the response.
+func httpGet(flags *Flags, urlstr, part string, meta *cacheMeta) error {
+	for i := 0; ; i++ {
+		wait, err := httpGetPart(flags, urlstr, part, meta, i)
+		switch {
+		case err == nil:
+			return nil
+		case wait < 0 || i >= flags.Retries:
+			return err
+		}
+		warnf(flags, "%v: retrying in %v", err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// httpGetPart retrieves urlstr to the file part, resuming from the end of
+// part when it already has content and the server supports range requests.
+//
+// GitHub API requests are authenticated using GITHUB_TOKEN (when set).
+//
+// Returns how long to wait before the next attempt, or -1 when the error
+// should not be retried.
+func httpGetPart(flags *Flags, urlstr, part string, meta *cacheMeta, attempt int) (time.Duration, error) {
+	backoff := time.Duration(1<<attempt) * time.Second
+	req, err := http.NewRequest("GET", urlstr, nil)
+	if err != nil {
+		return -1, err
+	}
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && req.URL.Host == githubApiHost {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	// resume
+	var offset int64
+	if fi, err := os.Stat(part); err == nil && fi.Size() != 0 {
+		offset = fi.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	// conditional
+	if offset == 0 && meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if offset == 0 && meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+	res, err := flags.client.Do(req)
+	if err != nil {
+		return backoff, err
+	}
+	defer res.Body.Close()
+	if wait, ok := githubRateLimited(res, attempt); ok {
+		if wait > githubMaxWait {
+			return -1, &rateLimitError{urlstr: urlstr, wait: wait}
+		}
+		return wait, &rateLimitError{urlstr: urlstr, wait: wait}
+	}
+	mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch {
+	case res.StatusCode == http.StatusNotModified && offset == 0:
+		meta.notModified = true
+		return -1, nil
+	case res.StatusCode == http.StatusPartialContent && offset != 0:
+		mode = os.O_WRONLY | os.O_APPEND
+	case res.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset != 0:
+		// partial download is invalid, start over
+		if err := os.Remove(part); err != nil {
+			return -1, err
+		}
+		return 0, fmt.Errorf("could not resume %q", urlstr)
+	case res.StatusCode == http.StatusOK:
+	case res.StatusCode >= http.StatusInternalServerError:
+		return backoff, fmt.Errorf("could not retrieve %q (%d)", urlstr, res.StatusCode)
+	default:
+		return -1, fmt.Errorf("could not retrieve %q (%d)", urlstr, res.StatusCode)
+	}
+	meta.ETag, meta.LastModified = res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	f, err := os.OpenFile(part, mode, 0644)
+	if err != nil {
+		return -1, err
+	}
+	var r io.Reader = res.Body
+	if flags.logLevel >= LogNormal {
+		total := res.ContentLength
+		if total > 0 {
+			total += offset
+		}
+		r = newProgressReader(flags, res.Body, path.Base(req.URL.Path), offset, total)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return backoff, fmt.Errorf("could not retrieve %q: %w", urlstr, err)
+	}
+	return -1, f.Close()
+}
+
+// progressReader wraps a reader, reporting the progress of a download.
+type progressReader struct {
+	flags *Flags
+	r     io.Reader
+	name  string
+	n     int64
+	total int64
+	tty   bool
+	last  time.Time
+	done  bool
+}
+
+// newProgressReader creates a progress reader for the named download, which
+// has already read n bytes out of total. total is -1 when unknown.
+//
+// When stderr is a terminal (and not logging json), progress is drawn as a
+// progress bar, otherwise progress is logged periodically.
+func newProgressReader(flags *Flags, r io.Reader, name string, n, total int64) *progressReader {
+	fi, err := os.Stderr.Stat()
+	return &progressReader{
+		flags: flags,
+		r:     r,
+		name:  name,
+		n:     n,
+		total: total,
+		tty:   err == nil && fi.Mode()&os.ModeCharDevice != 0 && flags.LogFormat != "json",
+		last:  time.Now(),
+	}
+}
+
+// Read satisfies the io.Reader interface.
+func (p *progressReader) Read(buf []byte) (int, error) {
+	i, err := p.r.Read(buf)
+	p.n += int64(i)
+	switch {
+	case err == io.EOF && !p.done:
+		p.done = true
+		p.report()
+		if p.tty {
+			fmt.Fprintln(os.Stderr)
+		}
+	case p.tty && time.Since(p.last) >= 100*time.Millisecond,
+		!p.tty && time.Since(p.last) >= 5*time.Second:
+		p.last = time.Now()
+		p.report()
+	}
+	return i, err
+}
+
+// report reports the current progress.
+func (p *progressReader) report() {
+	if p.total <= 0 {
+		if p.tty {
+			fmt.Fprintf(os.Stderr, "\r%s %s", p.name, formatBytes(p.n))
+		} else {
+			infof(p.flags, "RETRIEVED: %s %s", p.name, formatBytes(p.n))
+		}
+		return
+	}
+	pct := int(p.n * 100 / p.total)
+	if p.tty {
+		const width = 30
+		bar := strings.Repeat("=", pct*width/100)
+		if len(bar) < width {
+			bar += ">" + strings.Repeat(" ", width-len(bar)-1)
+		}
+		fmt.Fprintf(os.Stderr, "\r%s [%s] %3d%% %s/%s", p.name, bar, pct, formatBytes(p.n), formatBytes(p.total))
+	} else {
+		infof(p.flags, "RETRIEVED: %s %d%% %s/%s", p.name, pct, formatBytes(p.n), formatBytes(p.total))
+	}
+}
+
+// formatBytes formats n bytes in human readable form.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div, exp = div*unit, exp+1
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+const (
+	githubApiHost = "api.github.com"
+	githubMaxWait = time.Minute
+)
+
+// githubRateLimited determines if res is a GitHub API rate limit response,
+// returning how long to wait before the next attempt.
+func githubRateLimited(res *http.Response, attempt int) (time.Duration, bool) {
+	if res.Request.URL.Host != githubApiHost || (res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusTooManyRequests) {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if res.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0)), true
+		}
+	}
+	if res.StatusCode == http.StatusTooManyRequests || res.Header.Get("X-RateLimit-Remaining") == "0" {
+		return time.Duration(1<<attempt) * time.Second, true
+	}
+	return 0, false
+}
+
+// rateLimitError is a rate limit error.
+type rateLimitError struct {
+	urlstr string
+	wait   time.Duration
+}
+
+// Error satisfies the error interface.
+func (err *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited retrieving %q (reset in %v): set GITHUB_TOKEN to raise the limit", err.urlstr, err.wait.Round(time.Second))
+}
+
+// newHttpClient creates the http client used for retrieving remote files,
+// honoring the proxy settings from the environment and adding any additional
+// root CAs.
+func newHttpClient(flags *Flags) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if flags.CaCert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		buf, err := ioutil.ReadFile(flags.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", flags.CaCert, err)
+		}
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificates found in %s", flags.CaCert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   flags.HttpTimeout,
+	}, nil
+}
+
 // pathJoin is a simple wrapper around filepath.Join to simplify inline syntax.
 func pathJoin(n string, m ...string) string {
 	return filepath.Join(append([]string{n}, m...)...)
@@ -472,10 +1114,15 @@ type githubAsset struct {
 	ContentType        string `json:"content_type"`
 }
 
-// githubLatestAssets retrieves the latest release assets from the named repo.
-func githubLatestAssets(flags *Flags, repo, dir string) (string, []githubAsset, error) {
-	urlstr := "https://api.github.com/repos/" + repo + "/releases/latest"
-	buf, err := getAndCache(flags, urlstr, flags.Ttl, false, dir, "latest.json")
+// githubReleaseAssets retrieves the release assets for tag from the named
+// repo, or the latest release assets when tag is empty.
+func githubReleaseAssets(flags *Flags, repo, dir, tag string) (string, []githubAsset, error) {
+	urlstr, ttl, name := "https://"+githubApiHost+"/repos/"+repo+"/releases/latest", flags.Ttl, "latest.json"
+	if tag != "" {
+		// tagged releases do not change, so cache indefinitely
+		urlstr, ttl, name = "https://"+githubApiHost+"/repos/"+repo+"/releases/tags/"+url.PathEscape(tag), 0, "release-"+tag+".json"
+	}
+	buf, err := getAndCache(flags, urlstr, ttl, false, dir, name)
 	if err != nil {
 		return "", nil, err
 	}
@@ -0,0 +1,179 @@
+package gen
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestHtmlminGo(t *testing.T) {
+	php := []interface{}{`<\?[\s\S]*?\?>`}
+	tests := []struct {
+		engine string
+		opts   map[string]interface{}
+		s      string
+		exp    string
+	}{
+		{engineQtc, htmlminDefaults, "<p>\n  {%s x %}\n</p>", "<p>{%s x %}</p>"},
+		{engineQtc, htmlminDefaults, "<p class=\"{%s x %}\">a</p>", "<p class=\"{%s x %}\">a</p>"},
+		{engineQtc, htmlminDefaults, "<!-- a -->{% if x %}<b>a</b>{% endif %}", "{% if x %}<b>a</b>{% endif %}"},
+		{engineQtc, map[string]interface{}{}, "<!-- a -->\n<p>  a  </p>", "<!-- a --><p> a </p>"},
+		{engineHtml, htmlminDefaults, "<p>\n  {{ .X }}\n</p>", "<p>{{ .X }}</p>"},
+		{engineQtc, htmlminDefaults, "<div>  <?x   y ?>  </div>", "<div></div>"},
+		{engineQtc, map[string]interface{}{"collapseWhitespace": true, "ignoreCustomFragments": php}, "<div>  <?x   y ?>  </div>", "<div><?x   y ?></div>"},
+		{engineHtml, map[string]interface{}{"collapseWhitespace": true, "ignoreCustomFragments": php}, "<p> {{ .X }} <?x ?> </p>", "<p>{{ .X }} <?x ?></p>"},
+	}
+	for i, test := range tests {
+		buf, err := htmlminGo(test.opts, templateTagREs[test.engine], []byte(test.s))
+		if err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		if s := string(buf); s != test.exp {
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, s)
+		}
+	}
+}
+
+func TestHtmlminFragmentsRE(t *testing.T) {
+	tagRE := templateTagREs[engineQtc]
+	tests := []struct {
+		v  interface{}
+		s  string
+		ok bool
+	}{
+		{nil, "{% x %}", true},
+		{[]interface{}{}, "{% x %}", true},
+		{[]interface{}{`<\?[\s\S]*?\?>`}, "{% x %}<?x ?>", true},
+		{[]interface{}{`<\?`, `\[\[.*?\]\]`}, "{% x %}<?[[y]]", true},
+		{[]interface{}{`(`}, "", false},
+		{[]interface{}{`(?<=a)b`}, "", false},
+		{[]interface{}{int64(1)}, "", false},
+	}
+	for i, test := range tests {
+		opts := map[string]interface{}{}
+		if test.v != nil {
+			opts["ignoreCustomFragments"] = test.v
+		}
+		re, err := htmlminFragmentsRE(opts, tagRE)
+		switch {
+		case err != nil && test.ok:
+			t.Errorf("test %d expected no error, got: %v", i, err)
+		case err == nil && !test.ok:
+			t.Errorf("test %d expected error", i)
+		case err == nil:
+			if s := re.ReplaceAllString(test.s, ""); s != "" {
+				t.Errorf("test %d expected all fragments matched, got: %q", i, s)
+			}
+		}
+	}
+	// templ templates have no tags
+	if re, err := htmlminFragmentsRE(map[string]interface{}{"ignoreCustomFragments": []interface{}{`<\?`}}, nil); err != nil || re.String() != `(?:<\?)` {
+		t.Errorf("expected %q, got: %v, %v", `(?:<\?)`, re, err)
+	}
+}
+
+func TestNewCmdBun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping on windows")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, nodeModulesDir, nodeModulesBinDir)
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "uglifyjs"), nil, 0755); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		bun  bool
+		name string
+		exp  []string
+	}{
+		{false, "uglifyjs", []string{filepath.Join(bin, "uglifyjs"), "-c"}},
+		{true, "uglifyjs", []string{"/bun", "x", "--bun", "uglifyjs", "-c"}},
+		{true, "go", []string{"go", "-c"}},
+		{true, "/usr/bin/env", []string{"/usr/bin/env", "-c"}},
+	}
+	for i, test := range tests {
+		flags := &Flags{
+			Wd:             dir,
+			BunBin:         "/bun",
+			NodeModules:    filepath.Join(dir, nodeModulesDir),
+			NodeModulesBin: bin,
+			path:           []string{bin},
+			bun:            test.bun,
+			ctx:            context.Background(),
+		}
+		cmd := newCmd(flags, test.name, "-c")
+		if !reflect.DeepEqual(cmd.Args, test.exp) {
+			t.Errorf("test %d expected %v, got: %v", i, test.exp, cmd.Args)
+		}
+	}
+}
+
+func TestKebabCase(t *testing.T) {
+	tests := []struct {
+		s, exp string
+	}{
+		{"", ""},
+		{"collapseWhitespace", "collapse-whitespace"},
+		{"minifyCSS", "minify-css"},
+		{"minifyJS", "minify-js"},
+		{"removeStyleLinkTypeAttributes", "remove-style-link-type-attributes"},
+		{"ignoreCustomFragments", "ignore-custom-fragments"},
+		{"CSS", "css"},
+		{"lower", "lower"},
+	}
+	for i, test := range tests {
+		if s := kebabCase(test.s); s != test.exp {
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, s)
+		}
+	}
+}
+
+func TestHtmlminParams(t *testing.T) {
+	tagRE := templateTagREs[engineQtc]
+	tests := []struct {
+		opts map[string]interface{}
+		exp  []string
+	}{
+		{map[string]interface{}{}, nil},
+		{map[string]interface{}{"collapseWhitespace": true, "removeComments": false}, []string{"--collapse-whitespace"}},
+		{map[string]interface{}{"maxLineLength": 80, "minifyCSS": true}, []string{"--max-line-length=80", "--minify-css"}},
+		{map[string]interface{}{"ignoreCustomFragments": []interface{}{}}, []string{`--ignore-custom-fragments=["\\{%[^%]+%\\}"]`}},
+		{map[string]interface{}{"ignoreCustomFragments": []interface{}{`<\?[\s\S]*?\?>`}}, []string{`--ignore-custom-fragments=["\\{%[^%]+%\\}","<\\?[\\s\\S]*?\\?>"]`}},
+		{map[string]interface{}{"ignoreCustomFragments": nil}, []string{`--ignore-custom-fragments=["\\{%[^%]+%\\}"]`}},
+	}
+	for i, test := range tests {
+		if params := htmlminParams(test.opts, tagRE); !reflect.DeepEqual(params, test.exp) {
+			t.Errorf("test %d expected %v, got: %v", i, test.exp, params)
+		}
+	}
+}
+
+func TestAlignTemplateLines(t *testing.T) {
+	tests := []struct {
+		engine    string
+		orig, min string
+		exp       string
+	}{
+		{engineQtc, "<p>{%s x %}</p>", "<p>{%s x %}</p>", "<p>{%s x %}</p>"},
+		{engineQtc, "<p>\n  {%s x %}\n</p>", "<p>{%s x %}</p>", "<p>{%\ns x %}</p>"},
+		{engineQtc, "{% func A() %}\n<p>\n\n  {%s x %}\n</p>\n{% endfunc %}\n", "{% func A() %}<p>{%s x %}</p>{% endfunc %}", "{% func A() %}<p>{%\n\n\ns x %}</p>{%\n\n endfunc %}"},
+		{engineQtc, "<p>\n{%s x %}\n{%s y %}</p>", "<p>{%s x %}{%s y %}</p>", "<p>{%\ns x %}{%\ns y %}</p>"},
+		{engineQtc, "<p>\n{%s x %}</p>", "<p></p>", "<p></p>"},
+		{engineQtc, "<p>\n{%s x %}\n\n{%s y %}</p>", "<p>\n{%s x %}{%s y %}</p>", "<p>\n{%s x %}{%\n\ns y %}</p>"},
+		{engineHtml, "<p>\n  {{ .X }}\n</p>", "<p>{{ .X }}</p>", "<p>{{\n .X }}</p>"},
+		{engineHtml, "<p>\n  {{- .X }}\n</p>", "<p>{{- .X }}</p>", "<p>{{-\n .X }}</p>"},
+		{engineHtml, "<p>\n  {{/* c */}}\n</p>", "<p>{{/* c */}}</p>", "<p>{{/* c */}}</p>"},
+	}
+	for i, test := range tests {
+		if s := string(alignTemplateLines(templateTagREs[test.engine], []byte(test.orig), []byte(test.min))); s != test.exp {
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, s)
+		}
+	}
+}
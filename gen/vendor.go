@@ -0,0 +1,92 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Vendor generates assets, and copies the retrieved tool archives and the
+// yarn offline mirror of node packages to the vendor directory.
+//
+// Once vendored, assets can be generated without network access with
+// flags.Vendored.
+func Vendor(flags *Flags) error {
+	if flags.Vendored {
+		return errors.New("cannot vendor a vendored build")
+	}
+	if flags.NoInstall {
+		return errors.New("cannot vendor with -no-install")
+	}
+	flags.vendoring = true
+	if err := Assetgen(flags); err != nil {
+		return err
+	}
+	// copy retrieved files
+	dir := filepath.Join(flags.Vendor, "cache")
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("unable to remove %s: %w", dir, err)
+	}
+	err := filepath.Walk(flags.Cache, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() && n == flags.NodeModules:
+			return filepath.SkipDir
+		case fi.IsDir() || !strings.HasSuffix(n, ".sha256"):
+			return nil
+		}
+		// retrieved files have a checksum
+		base := strings.TrimSuffix(n, ".sha256")
+		for _, z := range []string{base, n, base + ".meta"} {
+			if !fileExists(z) {
+				continue
+			}
+			rel, err := filepath.Rel(flags.Cache, z)
+			if err != nil {
+				return err
+			}
+			if err := copyFile(filepath.Join(dir, rel), z); err != nil {
+				return fmt.Errorf("unable to vendor %s: %w", rel, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	infof(flags, "VENDORED: %s", flags.Vendor)
+	return nil
+}
+
+// yarnParams adds the params for vendored builds to the yarn params.
+func yarnParams(flags *Flags, params ...string) []string {
+	if flags.Vendored && !flags.yarnBerry {
+		params = append(params, "--offline")
+	}
+	return params
+}
+
+// copyFile copies src to dst, creating the parent directory of dst.
+func copyFile(dst, src string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,32 @@
+package gen
+
+import (
+	"runtime/debug"
+)
+
+// Version, Commit, and Date are the build info of the assetgen binary, set
+// when building with:
+//
+//	-ldflags "-X github.com/kenshaw/assetgen/gen.Version=... -X github.com/kenshaw/assetgen/gen.Commit=..."
+var (
+	Version = "0.0.0-dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// buildVersion returns the version of the assetgen binary, using the module
+// version when installed with go install and the version was not set with
+// ldflags.
+func buildVersion() string {
+	v := Version
+	if info, ok := debug.ReadBuildInfo(); ok && v == "0.0.0-dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	switch {
+	case Commit != "" && Date != "":
+		v += " (" + Commit + ", " + Date + ")"
+	case Commit != "":
+		v += " (" + Commit + ")"
+	}
+	return v
+}
@@ -0,0 +1,114 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// symlink policies.
+const (
+	symlinksFollow = "follow"
+	symlinksSkip   = "skip"
+	symlinksError  = "error"
+)
+
+// walk walks the file tree rooted at root, calling f for each file or
+// directory in lexical order, the same as filepath.Walk, handling symlinks
+// per the symlink policy:
+//
+//	follow - symlinks are followed, as if they were the file or directory
+//	skip - symlinks are skipped
+//	error - symlinks cause an error
+//
+// Followed directory symlinks that would loop back to a directory being
+// walked cause an error. Paths passed to f are always within root.
+func walk(flags *Flags, root string, f filepath.WalkFunc) error {
+	fi, err := os.Stat(root)
+	if err != nil {
+		err = f(root, nil, err)
+	} else {
+		err = walkPath(flags, root, fi, nil, f)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkPath recursively walks n, tracking the real paths of the directories
+// being walked in stack. Returns filepath.SkipDir when the remaining files in
+// the parent directory should be skipped.
+func walkPath(flags *Flags, n string, fi os.FileInfo, stack []string, f filepath.WalkFunc) error {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		switch flags.Symlinks {
+		case symlinksSkip:
+			return nil
+		case symlinksError:
+			return fmt.Errorf("%s is a symlink", n)
+		}
+		z, err := os.Stat(n)
+		if err != nil {
+			return f(n, fi, err)
+		}
+		fi = z
+	}
+	if !fi.IsDir() {
+		return f(n, fi, nil)
+	}
+	// check for cycles
+	real, err := filepath.EvalSymlinks(n)
+	if err == nil {
+		for _, d := range stack {
+			if d == real {
+				return fmt.Errorf("symlink cycle at %s (%s)", n, real)
+			}
+		}
+	}
+	switch err := f(n, fi, err); {
+	case err == filepath.SkipDir:
+		return nil
+	case err != nil:
+		return err
+	}
+	names, err := readDirNames(n)
+	if err != nil {
+		if err = f(n, fi, err); err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	stack = append(stack, real)
+	for _, name := range names {
+		p := filepath.Join(n, name)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			err = f(p, fi, err)
+		} else {
+			err = walkPath(flags, p, fi, stack, f)
+		}
+		switch {
+		case err == filepath.SkipDir:
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+// readDirNames returns the sorted names of the entries in dir.
+func readDirNames(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,93 @@
+package gen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on windows")
+	}
+	dir := t.TempDir()
+	for _, n := range []string{"b/c.txt", "a.txt", "b/d/e.txt", "other/f.txt"} {
+		name := filepath.Join(dir, "root", filepath.FromSlash(n))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if err := ioutil.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	root := filepath.Join(dir, "root")
+	for n, target := range map[string]string{
+		"root/link.txt": "a.txt",
+		"root/link":     "other",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(n))); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	tests := []struct {
+		symlinks string
+		skip     string
+		exp      []string
+		err      string
+	}{
+		{symlinksFollow, "", []string{".", "a.txt", "b", "b/c.txt", "b/d", "b/d/e.txt", "link", "link/f.txt", "link.txt", "other", "other/f.txt"}, ""},
+		{symlinksSkip, "", []string{".", "a.txt", "b", "b/c.txt", "b/d", "b/d/e.txt", "other", "other/f.txt"}, ""},
+		{symlinksError, "", nil, "is a symlink"},
+		{symlinksSkip, "b", []string{".", "a.txt", "b", "other", "other/f.txt"}, ""},
+		{symlinksSkip, "b/c.txt", []string{".", "a.txt", "b", "b/c.txt", "other", "other/f.txt"}, ""},
+	}
+	for i, test := range tests {
+		var names []string
+		err := walk(&Flags{Symlinks: test.symlinks}, root, func(n string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, n)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			names = append(names, rel)
+			if rel == test.skip {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("test %d expected no error, got: %v", i, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("test %d expected error containing %q, got: %v", i, test.err, err)
+		case test.err == "" && !reflect.DeepEqual(names, test.exp):
+			t.Errorf("test %d expected %v, got: %v", i, test.exp, names)
+		}
+	}
+	// cycles
+	if err := os.Symlink("..", filepath.Join(root, "b", "loop")); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	err := walk(&Flags{Symlinks: symlinksFollow}, root, func(n string, fi os.FileInfo, err error) error {
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "symlink cycle") {
+		t.Errorf("expected symlink cycle error, got: %v", err)
+	}
+	// missing root
+	var called bool
+	err = walk(&Flags{}, filepath.Join(dir, "missing"), func(n string, fi os.FileInfo, err error) error {
+		called = true
+		return err
+	})
+	if !called || !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got: %v", err)
+	}
+}
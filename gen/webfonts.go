@@ -0,0 +1,307 @@
+package gen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/kenshaw/assetgen/pack"
+)
+
+// webfontsDir is the packed and build directory for webfonts.
+const webfontsDir = "webfonts"
+
+// webfontsDefaultGlobs are the default globs for webfonts().
+var webfontsDefaultGlobs = []string{
+	"**.{woff,woff2,ttf,otf,eot,svg}",
+	"**.{css,scss}",
+}
+
+// webfontFileRE matches font files.
+var webfontFileRE = regexp.MustCompile(`(?i)\.(woff|woff2|ttf|otf|svg|eot)$`)
+
+// webfontStyleRE matches stylesheets.
+var webfontStyleRE = regexp.MustCompile(`(?i)\.s?css$`)
+
+// cssURLRE matches css url() references.
+var cssURLRE = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+
+// webfonts is the script handler to pack the font files from a npm package
+// (such as @fontsource/inter), optionally limited to the files matching the
+// globs (relative to the package directory).
+//
+// Fonts are packed to /webfonts/<package>/, and the package's stylesheets are
+// written (as .scss, with url() rewritten to asset()) to the sass include
+// path, for use with:
+//
+//	@import "@fontsource/inter/index";
+func (s *Script) webfonts(name string, globs ...string) error {
+	var ver string
+	if i := strings.LastIndex(name, "@"); i > 0 {
+		ver, name = name[i+1:], name[:i]
+	}
+	if len(globs) == 0 {
+		globs = webfontsDefaultGlobs
+	}
+	var pats []glob.Glob
+	for _, pattern := range globs {
+		pat, err := glob.Compile(pattern, '/')
+		if err != nil {
+			return fmt.Errorf("invalid webfonts() pattern %q: %w", pattern, err)
+		}
+		pats = append(pats, pat)
+	}
+	s.nodeDeps = append(s.nodeDeps, dep{name, ver})
+	s.webfontPkgs = true
+	s.exec = append(s.exec, step{
+		name: "webfonts(" + name + ")",
+		run: func(dist *pack.Pack) error {
+			return s.installWebfonts(dist, name, pats)
+		},
+		plan: func() ([]string, []string, error) {
+			dir := filepath.Join(s.flags.NodeModules, filepath.FromSlash(name))
+			if !fileExists(dir) {
+				return []string{"extract webfonts from " + dir}, nil, nil
+			}
+			fonts, styles, err := s.webfontFiles(dir, pats)
+			if err != nil {
+				return nil, nil, err
+			}
+			var cmds, files []string
+			for _, n := range styles {
+				cmds = append(cmds, "write "+s.webfontStyleOut(name, n))
+			}
+			for _, n := range fonts {
+				if s.webfontSubset && subsetFontRE.MatchString(n) {
+					cmds = append(cmds, "subset "+filepath.Join(dir, filepath.FromSlash(n)))
+				}
+				files = append(files, path.Join(webfontsDir, name, n))
+			}
+			return cmds, files, nil
+		},
+	})
+	return nil
+}
+
+// webfontFiles returns the font files and stylesheets in dir matching the
+// globs, relative to dir.
+func (s *Script) webfontFiles(dir string, pats []glob.Glob) ([]string, []string, error) {
+	var fonts, styles []string
+	err := walk(s.flags, dir, func(n string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() && fi.Name() == nodeModulesDir:
+			return filepath.SkipDir
+		case fi.IsDir():
+			return nil
+		}
+		rel, err := filepath.Rel(dir, n)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		var match bool
+		for _, pat := range pats {
+			if match = pat.Match(rel); match {
+				break
+			}
+		}
+		switch {
+		case !match:
+		case webfontFileRE.MatchString(rel):
+			fonts = append(fonts, rel)
+		case webfontStyleRE.MatchString(rel):
+			styles = append(styles, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return fonts, styles, nil
+}
+
+// webfontStyleOut returns the build path for the stylesheet n of the named
+// package.
+func (s *Script) webfontStyleOut(name, n string) string {
+	return filepath.Join(s.flags.Build, webfontsDir, filepath.FromSlash(name), filepath.FromSlash(strings.TrimSuffix(n, path.Ext(n))+".scss"))
+}
+
+// installWebfonts packs the font files of the named npm package, and writes
+// its stylesheets to the build directory.
+func (s *Script) installWebfonts(dist *pack.Pack, name string, pats []glob.Glob) error {
+	dir := filepath.Join(s.flags.NodeModules, filepath.FromSlash(name))
+	fonts, styles, err := s.webfontFiles(dir, pats)
+	if err != nil {
+		return err
+	}
+	if len(fonts) == 0 {
+		return fmt.Errorf("no webfonts found in %s", dir)
+	}
+	codepoints, err := s.webfontsCodepoints()
+	if err != nil {
+		return err
+	}
+	if codepoints != nil {
+		if err := ioutil.WriteFile(filepath.Join(s.flags.Build, subsetJs), []byte(tplf(subsetJs)), 0644); err != nil {
+			return fmt.Errorf("could not write %s: %w", subsetJs, err)
+		}
+	}
+	for _, n := range fonts {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(n)))
+		if err != nil {
+			return err
+		}
+		if codepoints != nil && subsetFontRE.MatchString(n) {
+			subsetDir := filepath.Join(s.flags.Build, webfontsDir+"-subset", filepath.FromSlash(name), filepath.FromSlash(path.Dir(n)))
+			if buf, err = subsetFont(s.flags, subsetDir, path.Base(n), buf, codepoints); err != nil {
+				return fmt.Errorf("could not subset %s: %w", n, err)
+			}
+		}
+		if err := dist.PackBytes(path.Join(webfontsDir, name, n), buf); err != nil {
+			return err
+		}
+	}
+	for _, n := range styles {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(n)))
+		if err != nil {
+			return err
+		}
+		// rewrite relative urls to the packed fonts
+		buf = cssURLRE.ReplaceAllFunc(buf, func(b []byte) []byte {
+			u := string(cssURLRE.FindSubmatch(b)[1])
+			if strings.HasPrefix(u, "/") || strings.Contains(u, ":") {
+				return b
+			}
+			return []byte(fmt.Sprintf("asset(%q)", "/"+path.Join(webfontsDir, name, path.Dir(n), u)))
+		})
+		out := s.webfontStyleOut(name, n)
+		if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(out, buf, 0644); err != nil {
+			return fmt.Errorf("could not write %s: %w", out, err)
+		}
+	}
+	return nil
+}
+
+// webfontsSubset is the script handler to subset the fonts packed by
+// webfonts() to a css unicode-range (such as "U+0000-00FF, U+2000-206F"), or
+// to the options in a map:
+//
+//	unicodeRange - a css unicode-range
+//	text - the characters to include
+//	templates - include the characters used in the templates directory
+func (s *Script) webfontsSubset(v interface{}) error {
+	opts := map[interface{}]interface{}{"unicodeRange": v}
+	if m, ok := v.(map[interface{}]interface{}); ok {
+		opts = m
+	}
+	for k, v := range opts {
+		switch k {
+		case "unicodeRange":
+			z, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("unknown type passed to webfontsSubset(): %T", v)
+			}
+			codepoints, err := parseUnicodeRange(z)
+			if err != nil {
+				return err
+			}
+			s.webfontRanges = append(s.webfontRanges, codepoints...)
+		case "text":
+			for _, r := range forceString(v) {
+				s.webfontRanges = append(s.webfontRanges, fmt.Sprintf("%x", r))
+			}
+		case "templates":
+			z, ok := v.(bool)
+			if !ok {
+				return fmt.Errorf("invalid webfontsSubset() templates value type %T", v)
+			}
+			s.webfontTemplates = z
+		default:
+			return fmt.Errorf("invalid webfontsSubset() option %v", k)
+		}
+	}
+	s.nodeDeps = append(s.nodeDeps, dep{"subset-font", ""})
+	s.webfontSubset = true
+	return nil
+}
+
+// webfontsCodepoints returns the codepoints (as hex, or hex ranges) to subset
+// the webfonts to, or nil when not subsetting.
+func (s *Script) webfontsCodepoints() ([]string, error) {
+	if !s.webfontSubset {
+		return nil, nil
+	}
+	codepoints := append([]string{}, s.webfontRanges...)
+	if !s.webfontTemplates {
+		return codepoints, nil
+	}
+	dir := filepath.Join(s.flags.Assets, templatesDir)
+	if !fileExists(dir) {
+		return codepoints, nil
+	}
+	used := make(map[rune]bool)
+	err := s.walkTemplates(dir, func(n string) error {
+		buf, err := ioutil.ReadFile(n)
+		if err != nil {
+			return err
+		}
+		// strip template tags
+		if re := templateTagREs[s.tplEngine]; re != nil {
+			buf = re.ReplaceAll(buf, nil)
+		}
+		for _, r := range string(buf) {
+			if r >= ' ' && !used[r] {
+				used[r] = true
+				codepoints = append(codepoints, fmt.Sprintf("%x", r))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codepoints, nil
+}
+
+// parseUnicodeRange parses a css unicode-range (ie, U+26, U+0-7F, or
+// U+4??), returning the codepoints as hex or hex ranges.
+func parseUnicodeRange(str string) ([]string, error) {
+	var codepoints []string
+	for _, v := range strings.Split(str, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.HasPrefix(strings.ToUpper(v), "U+") {
+			return nil, fmt.Errorf("invalid unicode range %q", v)
+		}
+		a, b := v[2:], v[2:]
+		if i := strings.Index(a, "-"); i != -1 {
+			a, b = a[:i], a[i+1:]
+		} else if strings.Contains(a, "?") {
+			a, b = strings.ReplaceAll(a, "?", "0"), strings.ReplaceAll(a, "?", "f")
+		}
+		if !unicodeHexRE.MatchString(a) || !unicodeHexRE.MatchString(b) {
+			return nil, fmt.Errorf("invalid unicode range %q", v)
+		}
+		if a == b {
+			codepoints = append(codepoints, strings.ToLower(a))
+		} else {
+			codepoints = append(codepoints, strings.ToLower(a+"-"+b))
+		}
+	}
+	return codepoints, nil
+}
+
+// unicodeHexRE matches a unicode codepoint in hex.
+var unicodeHexRE = regexp.MustCompile(`^[0-9a-fA-F]{1,6}$`)
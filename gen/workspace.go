@@ -0,0 +1,135 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// findWorkspace returns the root of the yarn/npm workspace containing dir as a
+// workspace package, or an empty string when dir is not part of a workspace.
+func findWorkspace(dir string) (string, error) {
+	for root := filepath.Dir(dir); ; root = filepath.Dir(root) {
+		patterns, err := workspacePatterns(root)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return "", err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, pattern := range patterns {
+			g, err := glob.Compile(path.Clean(strings.TrimPrefix(pattern, "./")), '/')
+			if err != nil {
+				return "", fmt.Errorf("invalid workspace pattern %q in %s: %w", pattern, filepath.Join(root, "package.json"), err)
+			}
+			if g.Match(rel) {
+				return root, nil
+			}
+		}
+		if filepath.Dir(root) == root {
+			return "", nil
+		}
+	}
+}
+
+// workspacePatterns returns the workspace package patterns declared in the
+// package.json in dir.
+func workspacePatterns(dir string) ([]string, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	var v struct {
+		Workspaces json.RawMessage `json:"workspaces"`
+	}
+	if err := json.Unmarshal(buf, &v); err != nil || v.Workspaces == nil {
+		return nil, nil
+	}
+	// workspaces is either a list of patterns, or an object with packages
+	var patterns []string
+	if err := json.Unmarshal(v.Workspaces, &patterns); err == nil {
+		return patterns, nil
+	}
+	var obj struct {
+		Packages []string `json:"packages"`
+	}
+	if err := json.Unmarshal(v.Workspaces, &obj); err != nil {
+		return nil, fmt.Errorf("invalid workspaces in %s: %w", filepath.Join(dir, "package.json"), err)
+	}
+	return obj.Packages, nil
+}
+
+// packageRoot returns the directory of the package.json and lockfile managed
+// by the package manager (ie, the workspace root for workspace packages).
+func packageRoot(flags *Flags) string {
+	if flags.workspace != "" {
+		return flags.workspace
+	}
+	return flags.Wd
+}
+
+// nodeModulesDirs returns the node_modules directories, in resolution order.
+// For workspace packages, packages not hoisted to the workspace root are in
+// the working directory's node_modules.
+func nodeModulesDirs(flags *Flags) []string {
+	if flags.workspace != "" {
+		return []string{filepath.Join(flags.Wd, nodeModulesDir), flags.NodeModules}
+	}
+	return []string{flags.NodeModules}
+}
+
+// modulesParams returns the package manager params to install node packages
+// to flags.NodeModules.
+//
+// Yarn berry, bun, workspaces, and projects managing their own node packages
+// (ie, flags.NoInstall) manage their own node_modules and bin links.
+func modulesParams(flags *Flags) []string {
+	if flags.yarnBerry || flags.bun || flags.workspace != "" || flags.NoInstall {
+		return nil
+	}
+	return []string{"--no-bin-links", "--modules-folder=" + flags.NodeModules}
+}
+
+// resolvePackageManager determines the package manager, workspace, and
+// node_modules location, without installing any tools.
+func resolvePackageManager(flags *Flags) error {
+	var err error
+	if flags.workspace, err = findWorkspace(flags.Wd); err != nil {
+		return fmt.Errorf("unable to determine workspace: %w", err)
+	}
+	switch flags.Runtime {
+	case "node":
+		flags.yarnBerry = isBerryPackageManager(flags)
+		if flags.YarnBin == "" {
+			flags.YarnBin = "yarn"
+		}
+	case "bun":
+		flags.bun = true
+		if flags.YarnBin == "" {
+			flags.YarnBin = "bun"
+		}
+	default:
+		return fmt.Errorf("invalid runtime %q", flags.Runtime)
+	}
+	if flags.NodeModules == "" {
+		flags.NodeModules = filepath.Join(flags.Cache, nodeModulesDir)
+		if modulesParams(flags) == nil {
+			flags.NodeModules = filepath.Join(packageRoot(flags), nodeModulesDir)
+		}
+	}
+	if flags.NodeModulesBin == "" {
+		flags.NodeModulesBin = filepath.Join(flags.NodeModules, nodeModulesBinDir)
+	}
+	return nil
+}
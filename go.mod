@@ -4,9 +4,12 @@ go 1.16
 
 require (
 	github.com/Masterminds/semver v1.5.0
+	github.com/Microsoft/go-winio v0.5.2
+	github.com/ProtonMail/go-crypto v0.0.0-20210428141323-04723f9f07d7
 	github.com/gobwas/glob v0.2.3
 	github.com/mattn/anko v0.1.8
 	github.com/spf13/afero v1.6.0
+	github.com/tdewolff/minify/v2 v2.12.7
 	github.com/valyala/quicktemplate v1.6.3
 	github.com/yookoala/realpath v1.0.0
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -9,6 +10,13 @@ import (
 
 func main() {
 	if err := gen.Run(); err != nil {
+		if gen.IsReported(err) {
+			os.Exit(1)
+		}
+		var reportErr interface{ Report() string }
+		if errors.As(err, &reportErr) {
+			fmt.Fprintf(os.Stderr, "\n%s\n", reportErr.Report())
+		}
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -21,6 +23,8 @@ type Pack struct {
 	fs       afero.Fs
 	h        map[string]string
 	manifest string
+	prefix   string
+	exclude  []string
 	sync.RWMutex
 }
 
@@ -89,6 +93,63 @@ func (p *Pack) PackFile(name, path string) error {
 	return p.Pack(name, f)
 }
 
+// Merge merges the files packed by o into the manifest. The base of o must be
+// the directory of its prefix in the base of p (see WithPrefix).
+func (p *Pack) Merge(o *Pack) {
+	o.RLock()
+	defer o.RUnlock()
+	p.Lock()
+	defer p.Unlock()
+	for n, h := range o.h {
+		p.h[path.Join("/", o.prefix, n)] = h
+	}
+}
+
+// Files returns the sorted names of the packed files.
+func (p *Pack) Files() []string {
+	p.RLock()
+	defer p.RUnlock()
+	names := make([]string, 0, len(p.h))
+	for n := range p.h {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hash returns the content hash of the packed file, as used in the file's
+// name in the manifest.
+func (p *Pack) Hash(name string) (string, bool) {
+	p.RLock()
+	defer p.RUnlock()
+	name = "/" + strings.TrimLeft(name, "/")
+	if pfx := path.Join("/", p.prefix); p.prefix != "" && strings.HasPrefix(name, pfx+"/") {
+		name = strings.TrimPrefix(name, pfx)
+	}
+	h, ok := p.h[name]
+	if !ok {
+		return "", false
+	}
+	return h[:6], true
+}
+
+// ID returns a hash of the names and contents of the packed files, which
+// changes whenever a packed file changes.
+func (p *Pack) ID() string {
+	p.RLock()
+	defer p.RUnlock()
+	names := make([]string, 0, len(p.h))
+	for n := range p.h {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	h := md5.New()
+	for _, n := range names {
+		fmt.Fprintf(h, "%s:%s\n", n, p.h[n])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:6]
+}
+
 // Manifest returns a manifest of the packed files.
 func (p *Pack) Manifest() (map[string]string, error) {
 	p.RLock()
@@ -101,8 +162,23 @@ func (p *Pack) Manifest() (map[string]string, error) {
 		case fi.IsDir() || filepath.Base(n) == p.manifest:
 			return nil
 		}
-		fh := fmt.Sprintf("%x", md5.Sum([]byte(strings.TrimLeft(n, "/"))))
-		m[n] = fh[:6] + "." + p.h[n][:6] + filepath.Ext(n)
+		// skip files not packed (ie, the dist marker)
+		h, ok := p.h[n]
+		if !ok {
+			return nil
+		}
+		name := n
+		if p.prefix != "" {
+			name = path.Join("/", p.prefix, n)
+		}
+		switch excluded, err := p.excluded(name); {
+		case err != nil:
+			return err
+		case excluded:
+			return nil
+		}
+		fh := fmt.Sprintf("%x", md5.Sum([]byte(strings.TrimLeft(name, "/"))))
+		m[name] = fh[:6] + "." + h[:6] + filepath.Ext(n)
 		return nil
 	})
 	if err != nil {
@@ -111,6 +187,24 @@ func (p *Pack) Manifest() (map[string]string, error) {
 	return m, nil
 }
 
+// excluded returns true when the packed file name matches one of the
+// manifest exclusion patterns.
+func (p *Pack) excluded(name string) (bool, error) {
+	for _, pattern := range p.exclude {
+		z := path.Base(name)
+		if strings.Contains(pattern, "/") {
+			pattern, z = strings.TrimLeft(pattern, "/"), strings.TrimLeft(name, "/")
+		}
+		switch ok, err := path.Match(pattern, z); {
+		case err != nil:
+			return false, fmt.Errorf("invalid manifest exclusion pattern %q: %w", pattern, err)
+		case ok:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // ManifestInverted returns a manifest of the packed files (inverted).
 func (p *Pack) ManifestInverted() (map[string]string, error) {
 	m, err := p.Manifest()
@@ -164,9 +258,27 @@ func (p *Pack) WriteManifestInverted() error {
 // Option is an asset packer option.
 type Option func(*Pack)
 
+// WithPrefix is an asset packer option to set the prefix for packed file names
+// in the manifest.
+func WithPrefix(prefix string) Option {
+	return func(p *Pack) {
+		p.prefix = prefix
+	}
+}
+
 // WithManifest is an asset packer option to set the manifest name.
 func WithManifest(manifest string) Option {
 	return func(p *Pack) {
 		p.manifest = manifest
 	}
 }
+
+// WithManifestExclude is an asset packer option to omit the packed files
+// matching the glob patterns from the manifest, while still writing them to
+// the base (ie, for source maps, or data files read from disk). Patterns
+// without a slash match the file's base name, otherwise the file's name.
+func WithManifestExclude(patterns ...string) Option {
+	return func(p *Pack) {
+		p.exclude = append(p.exclude, patterns...)
+	}
+}
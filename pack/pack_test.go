@@ -0,0 +1,83 @@
+package pack
+
+import (
+	"crypto/md5"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestManifest(t *testing.T) {
+	tests := []struct {
+		opts  []Option
+		files []string
+		exp   []string
+	}{
+		{nil, []string{"app.css"}, []string{"/app.css"}},
+		{nil, []string{"css/app.css", "js/app.js"}, []string{"/css/app.css", "/js/app.js"}},
+		{[]Option{WithPrefix("/admin")}, []string{"app.css"}, []string{"/admin/app.css"}},
+		{[]Option{WithManifestExclude("*.map")}, []string{"app.js", "app.js.map"}, []string{"/app.js"}},
+		{[]Option{WithManifestExclude("/js/*")}, []string{"js/a.js", "b.js"}, []string{"/b.js"}},
+	}
+	for i, test := range tests {
+		fs := afero.NewMemMapFs()
+		// files written directly to the base are not in the manifest
+		if err := afero.WriteFile(fs, "/.assetgen-dist", nil, 0644); err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		if err := afero.WriteFile(fs, "/css/other.css", []byte("a{}"), 0644); err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		p := New(fs, test.opts...)
+		for _, n := range test.files {
+			if err := p.PackString(n, n); err != nil {
+				t.Fatalf("test %d expected no error, got: %v", i, err)
+			}
+		}
+		if err := afero.WriteFile(fs, "/manifest.json", []byte("{}"), 0644); err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		m, err := p.Manifest()
+		if err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		if len(m) != len(test.exp) {
+			t.Errorf("test %d expected %d entries, got: %v", i, len(test.exp), m)
+		}
+		for _, n := range test.exp {
+			h, ok := p.Hash(n)
+			if !ok {
+				t.Errorf("test %d expected hash for %s", i, n)
+				continue
+			}
+			fh := fmt.Sprintf("%x", md5.Sum([]byte(n[1:])))
+			if exp := fh[:6] + "." + h + path.Ext(n); m[n] != exp {
+				t.Errorf("test %d expected %s to be %q, got: %q", i, n, exp, m[n])
+			}
+		}
+	}
+}
+
+func TestManifestInverted(t *testing.T) {
+	p := New(afero.NewMemMapFs())
+	if err := p.PackString("css/app.css", "body{}"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	m, err := p.ManifestInverted()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(m) != 1 {
+		t.Fatalf("expected 1 entry, got: %v", m)
+	}
+	for k, v := range m {
+		if v != "/css/app.css" {
+			t.Errorf("expected %q, got: %q", "/css/app.css", v)
+		}
+		if h, _ := p.Hash("css/app.css"); k[7:13] != h {
+			t.Errorf("expected %q to contain hash %q", k, h)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package serve
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// NewAsset creates an asset for the named file with content, detecting its
+// content type.
+func NewAsset(name string, content []byte, modTime time.Time) *Asset {
+	contentType := http.DetectContentType(content)
+	switch {
+	case strings.HasPrefix(contentType, "text/") || contentType == "":
+		if i := strings.LastIndex(name, "."); i != -1 {
+			contentType = mime.TypeByExtension(name[i:])
+		}
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &Asset{
+		Hash:        fmt.Sprintf("%x", sha1.Sum(content)),
+		ModTime:     modTime,
+		ContentType: contentType,
+		Content:     content,
+	}
+}
+
+// Dist is a dist directory loaded at runtime, providing the same manifest
+// and static handler api as a generated assets package, for deployments that
+// ship the dist directory as files alongside the binary instead of embedding
+// it.
+type Dist struct {
+	manifest  map[string]string
+	rev       map[string]string
+	assets    map[string]*Asset
+	urlPrefix string
+}
+
+// LoadDir loads the manifest file (ie, manifest.json) and assets of the dist
+// directory dir from disk. The url prefix is the prefix the assets are served
+// under.
+func LoadDir(dir, manifestFile, urlPrefix string) (*Dist, error) {
+	return Load(os.DirFS(dir), manifestFile, urlPrefix)
+}
+
+// Load loads the manifest file and assets of the dist in fsys. The url prefix
+// is the prefix the assets are served under.
+func Load(fsys fs.FS, manifestFile, urlPrefix string) (*Dist, error) {
+	buf, err := fs.ReadFile(fsys, manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest: %w", err)
+	}
+	var manifest map[string]string
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", manifestFile, err)
+	}
+	modTime := time.Now()
+	d := &Dist{
+		manifest:  manifest,
+		rev:       make(map[string]string, len(manifest)),
+		assets:    make(map[string]*Asset, len(manifest)),
+		urlPrefix: urlPrefix,
+	}
+	for k, n := range manifest {
+		content, err := fs.ReadFile(fsys, strings.TrimPrefix(n, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read asset %s: %w", n, err)
+		}
+		d.rev[n] = k
+		d.assets[k] = NewAsset(n, content, modTime)
+	}
+	return d, nil
+}
+
+// Manifest returns a map of the asset names.
+func (d *Dist) Manifest() map[string]string {
+	return d.manifest
+}
+
+// Assets returns a map of the asset contents.
+func (d *Dist) Assets() map[string]*Asset {
+	return d.assets
+}
+
+// ManifestFS returns a fs.FS of the assets by their original names. See
+// ManifestFS.
+func (d *Dist) ManifestFS() fs.FS {
+	return ManifestFS(d.assets, d.manifest)
+}
+
+// ManifestPath returns a manifest path conversion func.
+func (d *Dist) ManifestPath(prefixes ...string) func(string) string {
+	prefix := path.Join(prefixes...)
+	return func(s string) string {
+		return path.Join(prefix, d.rev["/"+strings.TrimPrefix(s, "/")])
+	}
+}
+
+// ManifestURL returns a manifest url conversion func, prefixing the converted
+// paths with the url prefix.
+func (d *Dist) ManifestURL() func(string) string {
+	return func(s string) string {
+		return d.urlPrefix + d.rev["/"+strings.TrimPrefix(s, "/")]
+	}
+}
+
+// PreloadTags returns the html link tags to preload the named assets (ie, an
+// entrypoint's css and js).
+func (d *Dist) PreloadTags(names ...string) (string, error) {
+	var tags []string
+	for _, n := range names {
+		v, ok := d.rev["/"+strings.TrimPrefix(n, "/")]
+		if !ok {
+			return "", fmt.Errorf("no asset %q in manifest", n)
+		}
+		tags = append(tags, PreloadTag(d.urlPrefix+v))
+	}
+	return strings.Join(tags, "\n"), nil
+}
+
+// StaticHandler returns a static asset handler for the dist. The asset name
+// is retrieved from the request's context with f, or when f is nil, from the
+// request's path less the url prefix. Additional options (ie, WithDebug,
+// WithImmutable) are passed to New.
+func (d *Dist) StaticHandler(f func(context.Context) string, opts ...Option) http.Handler {
+	if f == nil {
+		prefix := d.urlPrefix
+		if u, err := url.Parse(prefix); err == nil {
+			prefix = u.Path
+		}
+		opts = append([]Option{WithPrefix(prefix)}, opts...)
+	} else {
+		opts = append([]Option{WithNameFunc(func(req *http.Request) string {
+			return f(req.Context())
+		})}, opts...)
+	}
+	return New(d.assets, opts...)
+}
@@ -0,0 +1,174 @@
+package serve
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestFS returns a fs.FS of the assets by their original names in the
+// manifest (ie, css/app.css), for use with standard library consumers such as
+// http.FS and template.ParseFS. Assets not in the manifest are omitted.
+func ManifestFS(assets map[string]*Asset, manifest map[string]string) fs.FS {
+	fsys := &manifestFS{
+		files: make(map[string]*Asset, len(manifest)),
+		dirs:  map[string][]string{".": nil},
+	}
+	for k, n := range manifest {
+		a, ok := assets[k]
+		if !ok {
+			continue
+		}
+		name := strings.TrimPrefix(n, "/")
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		fsys.files[name] = a
+		// add to parent directories, stopping at the first existing parent
+		for name != "." {
+			dir := path.Dir(name)
+			_, seen := fsys.dirs[dir]
+			fsys.dirs[dir] = append(fsys.dirs[dir], path.Base(name))
+			if seen {
+				break
+			}
+			name = dir
+		}
+	}
+	for _, names := range fsys.dirs {
+		sort.Strings(names)
+	}
+	return fsys
+}
+
+// manifestFS is a read-only fs.FS of assets.
+type manifestFS struct {
+	files map[string]*Asset
+	dirs  map[string][]string
+}
+
+// Open satisfies the fs.FS interface.
+func (fsys *manifestFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if a, ok := fsys.files[name]; ok {
+		return &manifestFile{
+			info:   fsys.info(name),
+			Reader: bytes.NewReader(a.Content),
+		}, nil
+	}
+	if names, ok := fsys.dirs[name]; ok {
+		d := &manifestDir{info: fsys.info(name)}
+		for _, n := range names {
+			d.entries = append(d.entries, fsys.info(path.Join(name, n)))
+		}
+		return d, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadFile satisfies the fs.ReadFileFS interface.
+func (fsys *manifestFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	a, ok := fsys.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), a.Content...), nil
+}
+
+// info returns the file info for the file or directory name.
+func (fsys *manifestFS) info(name string) *fileInfo {
+	if a, ok := fsys.files[name]; ok {
+		return &fileInfo{
+			name:    path.Base(name),
+			size:    int64(len(a.Content)),
+			mode:    0444,
+			modTime: a.ModTime,
+		}
+	}
+	return &fileInfo{
+		name: path.Base(name),
+		mode: fs.ModeDir | 0555,
+	}
+}
+
+// manifestFile is an open asset.
+type manifestFile struct {
+	info *fileInfo
+	*bytes.Reader
+}
+
+// Stat satisfies the fs.File interface.
+func (f *manifestFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Close satisfies the fs.File interface.
+func (f *manifestFile) Close() error {
+	return nil
+}
+
+// manifestDir is an open directory.
+type manifestDir struct {
+	info    *fileInfo
+	entries []*fileInfo
+	off     int
+}
+
+// Stat satisfies the fs.File interface.
+func (d *manifestDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read satisfies the fs.File interface.
+func (d *manifestDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// Close satisfies the fs.File interface.
+func (d *manifestDir) Close() error {
+	return nil
+}
+
+// ReadDir satisfies the fs.ReadDirFile interface.
+func (d *manifestDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rem := d.entries[d.off:]
+	if n > 0 && len(rem) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rem) {
+		rem = rem[:n]
+	}
+	d.off += len(rem)
+	entries := make([]fs.DirEntry, len(rem))
+	for i, e := range rem {
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+// fileInfo is the file info of an asset or directory, and satisfies both the
+// fs.FileInfo and fs.DirEntry interfaces.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string               { return fi.name }
+func (fi *fileInfo) Size() int64                { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode          { return fi.mode }
+func (fi *fileInfo) Type() fs.FileMode          { return fi.mode.Type() }
+func (fi *fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi *fileInfo) IsDir() bool                { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}           { return nil }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
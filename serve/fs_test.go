@@ -0,0 +1,104 @@
+package serve
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestManifestFS(t *testing.T) {
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assets := map[string]*Asset{
+		"app.abc123.css":     NewAsset("/css/app.css", []byte("body{}"), modTime),
+		"main.def456.js":     NewAsset("/js/main.js", []byte("x()"), modTime),
+		"b.789abc.ttf":       NewAsset("/fonts/a/b.ttf", []byte("font"), modTime),
+		"favicon.111111.ico": NewAsset("/favicon.ico", []byte("ico"), modTime),
+		"invalid.222222.css": NewAsset("/../x.css", []byte("x"), modTime),
+		"unlisted.333333.js": NewAsset("/unlisted.js", []byte("y"), modTime),
+	}
+	manifest := map[string]string{
+		"app.abc123.css":     "/css/app.css",
+		"main.def456.js":     "/js/main.js",
+		"b.789abc.ttf":       "/fonts/a/b.ttf",
+		"favicon.111111.ico": "/favicon.ico",
+		"invalid.222222.css": "/../x.css",
+		"missing.444444.css": "/css/missing.css",
+	}
+	fsys := ManifestFS(assets, manifest)
+	if err := fstest.TestFS(fsys, "css/app.css", "js/main.js", "fonts/a/b.ttf", "favicon.ico"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		name string
+		exp  string
+		err  error
+	}{
+		{"css/app.css", "body{}", nil},
+		{"fonts/a/b.ttf", "font", nil},
+		{"favicon.ico", "ico", nil},
+		{"css/missing.css", "", fs.ErrNotExist},
+		{"unlisted.js", "", fs.ErrNotExist},
+		{"app.abc123.css", "", fs.ErrNotExist},
+		{"x.css", "", fs.ErrNotExist},
+		{"/css/app.css", "", fs.ErrInvalid},
+		{"css/../css/app.css", "", fs.ErrInvalid},
+		{"css", "", fs.ErrNotExist},
+	}
+	for i, test := range tests {
+		buf, err := fs.ReadFile(fsys, test.name)
+		switch {
+		case test.err == nil && err != nil:
+			t.Errorf("test %d expected no error, got: %v", i, err)
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("test %d expected %v, got: %v", i, test.err, err)
+		case string(buf) != test.exp:
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, buf)
+		}
+	}
+	// directories
+	for name, exp := range map[string][]string{
+		".":       {"css", "favicon.ico", "fonts", "js"},
+		"fonts":   {"a"},
+		"fonts/a": {"b.ttf"},
+	} {
+		entries, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			t.Fatalf("%s expected no error, got: %v", name, err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if !reflect.DeepEqual(names, exp) {
+			t.Errorf("%s expected %v, got: %v", name, exp, names)
+		}
+	}
+	// paged reads
+	f, err := fsys.Open(".")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer f.Close()
+	d := f.(fs.ReadDirFile)
+	for i, exp := range []string{"css", "favicon.ico", "fonts", "js"} {
+		entries, err := d.ReadDir(1)
+		if err != nil || len(entries) != 1 || entries[0].Name() != exp {
+			t.Errorf("entry %d expected %s, got: %v %v", i, exp, entries, err)
+		}
+	}
+	if _, err := d.ReadDir(1); err != io.EOF {
+		t.Errorf("expected io.EOF, got: %v", err)
+	}
+	// file info
+	fi, err := fs.Stat(fsys, "css/app.css")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if fi.Name() != "app.css" || fi.Size() != 6 || !fi.ModTime().Equal(modTime) || fi.IsDir() {
+		t.Errorf("expected app.css file info, got: %s %d %v %t", fi.Name(), fi.Size(), fi.ModTime(), fi.IsDir())
+	}
+}
@@ -0,0 +1,259 @@
+package serve
+
+import (
+	"html"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Asset wraps an asset.
+type Asset struct {
+	Hash        string
+	ModTime     time.Time
+	ContentType string
+	Content     []byte
+}
+
+// Handler is a static asset handler.
+//
+// The handler only serves GET and HEAD requests for the assets it was
+// created with. Duplicate slashes are collapsed, paths containing a parent
+// directory (..) segment, a backslash, or a NUL are not found, and directories
+// are never listed, so the handler can be mounted directly.
+type Handler struct {
+	assets    map[string]*Asset
+	name      func(*http.Request) string
+	debug     bool
+	immutable bool
+	unhashed  map[string]string
+}
+
+// New creates a static asset handler for the assets, keyed by name. The
+// asset name is the request's path, unless changed with WithPrefix or
+// WithNameFunc.
+func New(assets map[string]*Asset, opts ...Option) *Handler {
+	h := &Handler{
+		assets: assets,
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	if h.name == nil {
+		WithPrefix("/")(h)
+	}
+	return h
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	// only allow get and head requests
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		res.Header().Set("Allow", "GET, HEAD")
+		http.Error(res, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	// clean name, rejecting invalid paths and never listing directories
+	name, ok := CleanPath(h.name(req))
+	if !ok || name == "" || strings.HasSuffix(name, "/") {
+		http.Error(res, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	// retrieve asset, or its unhashed alias
+	key := strings.TrimPrefix(name, "/")
+	asset, ok := h.assets[key]
+	var alias bool
+	if n, found := h.unhashed[key]; !ok && found {
+		asset, ok = h.assets[n]
+		alias = true
+	}
+	if !ok {
+		http.Error(res, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	// check if-modified-since header, bail if present
+	if t, err := time.Parse(http.TimeFormat, req.Header.Get("If-Modified-Since")); err == nil && asset.ModTime.Unix() <= t.Unix() {
+		res.WriteHeader(http.StatusNotModified) // 304
+		return
+	}
+	// check If-None-Match header, bail if present and match hash
+	if req.Header.Get("If-None-Match") == asset.Hash {
+		res.WriteHeader(http.StatusNotModified) // 304
+		return
+	}
+	// set headers
+	res.Header().Set("Content-Type", asset.ContentType)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("Date", time.Now().Format(http.TimeFormat))
+	// cache headers
+	switch {
+	case h.debug:
+		res.Header().Set("Cache-Control", "no-cache")
+	case alias:
+		// the content of unhashed names changes, so always revalidate
+		res.Header().Set("Cache-Control", "public, no-transform, no-cache")
+	default:
+		cacheControl := "public, no-transform, max-age=31536000"
+		if h.immutable {
+			cacheControl += ", immutable"
+		}
+		res.Header().Set("Cache-Control", cacheControl)
+		res.Header().Set("Expires", time.Now().AddDate(1, 0, 0).Format(http.TimeFormat))
+	}
+	res.Header().Set("Last-Modified", asset.ModTime.Format(http.TimeFormat))
+	res.Header().Set("ETag", asset.Hash)
+	if req.Method == http.MethodHead {
+		return
+	}
+	// write data to response
+	_, _ = res.Write(asset.Content)
+}
+
+// CleanPath collapses duplicate slashes in the path, returning false when the
+// path contains a parent directory (..) segment, a backslash, or a NUL.
+func CleanPath(s string) (string, bool) {
+	if strings.ContainsAny(s, "\\\x00") {
+		return "", false
+	}
+	for strings.Contains(s, "//") {
+		s = strings.ReplaceAll(s, "//", "/")
+	}
+	for _, v := range strings.Split(s, "/") {
+		if v == ".." {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// Option is a static asset handler option.
+type Option func(*Handler)
+
+// WithPrefix is a static asset handler option to retrieve the asset name from
+// the request's path less prefix. Requests for paths not starting with
+// prefix are not found.
+func WithPrefix(prefix string) Option {
+	return func(h *Handler) {
+		prefix, _ = CleanPath(prefix)
+		h.name = func(req *http.Request) string {
+			name, ok := CleanPath(req.URL.Path)
+			if !ok || !strings.HasPrefix(name, prefix) {
+				return ""
+			}
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+}
+
+// WithNameFunc is a static asset handler option to retrieve the asset name
+// from the request with f.
+func WithNameFunc(f func(*http.Request) string) Option {
+	return func(h *Handler) {
+		h.name = f
+	}
+}
+
+// WithDebug is a static asset handler option to set debug mode. When enabled,
+// assets are not cached by clients.
+func WithDebug(debug bool) Option {
+	return func(h *Handler) {
+		h.debug = debug
+	}
+}
+
+// WithImmutable is a static asset handler option to mark the assets as
+// immutable in the Cache-Control header, so clients do not revalidate them.
+// Only use with hashed asset names (ie, the names in the manifest). Has no
+// effect in debug mode.
+func WithImmutable(immutable bool) Option {
+	return func(h *Handler) {
+		h.immutable = immutable
+	}
+}
+
+// WithUnhashed is a static asset handler option to additionally serve the
+// assets by their original, unhashed names (ie, css/app.css), for consumers
+// that need stable urls (such as emails and third-party embeds). The manifest
+// maps the hashed asset names to the original names. Assets served by their
+// unhashed names are always revalidated by clients.
+func WithUnhashed(manifest map[string]string) Option {
+	return func(h *Handler) {
+		h.unhashed = make(map[string]string, len(manifest))
+		for k, n := range manifest {
+			h.unhashed[strings.TrimPrefix(n, "/")] = k
+		}
+	}
+}
+
+// PreloadLink returns the Link header value to preload the asset at urlstr.
+func PreloadLink(urlstr string) string {
+	v := "<" + urlstr + ">; rel=preload"
+	switch as := preloadAs(urlstr); as {
+	case "":
+	case "font":
+		v += "; as=font; crossorigin"
+	default:
+		v += "; as=" + as
+	}
+	return v
+}
+
+// LinkHeaders adds Link preload headers for the urls to the response.
+func LinkHeaders(res http.ResponseWriter, urls ...string) {
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		res.Header().Add("Link", PreloadLink(u))
+	}
+}
+
+// EarlyHints adds Link preload headers for the urls to the response, and
+// sends a 103 Early Hints response. The Link headers are also sent with the
+// final response.
+//
+// Sending informational responses requires Go 1.19 or later (earlier
+// versions send 103 as the final status), otherwise use LinkHeaders. Nothing
+// is sent when urls is empty.
+func EarlyHints(res http.ResponseWriter, urls ...string) {
+	if len(urls) == 0 {
+		return
+	}
+	LinkHeaders(res, urls...)
+	res.WriteHeader(http.StatusEarlyHints)
+}
+
+// PreloadTag returns the html link tag to preload the asset at urlstr.
+func PreloadTag(urlstr string) string {
+	v := `<link rel="preload" href="` + html.EscapeString(urlstr) + `"`
+	switch as := preloadAs(urlstr); as {
+	case "":
+	case "font":
+		v += ` as="font" crossorigin`
+	default:
+		v += ` as="` + as + `"`
+	}
+	return v + ">"
+}
+
+// preloadAs returns the preload destination for the asset at urlstr, based
+// on its extension.
+func preloadAs(urlstr string) string {
+	if i := strings.IndexAny(urlstr, "?#"); i != -1 {
+		urlstr = urlstr[:i]
+	}
+	switch strings.ToLower(path.Ext(urlstr)) {
+	case ".css":
+		return "style"
+	case ".js", ".mjs":
+		return "script"
+	case ".woff", ".woff2", ".ttf", ".otf", ".eot":
+		return "font"
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".ico":
+		return "image"
+	}
+	return ""
+}
@@ -0,0 +1,222 @@
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assets := map[string]*Asset{
+		"app.abc123.css":       NewAsset("app.css", []byte("body{}"), modTime),
+		"js/main.def456.js":    NewAsset("main.js", []byte("x()"), modTime),
+		"fonts/a/b.789abc.ttf": NewAsset("b.ttf", []byte("font"), modTime),
+	}
+	tests := []struct {
+		path string
+		exp  int
+		body string
+	}{
+		{"/_/app.abc123.css", http.StatusOK, "body{}"},
+		{"/_/js/main.def456.js", http.StatusOK, "x()"},
+		{"/_//js//main.def456.js", http.StatusOK, "x()"},
+		{"/_/fonts/a/b.789abc.ttf", http.StatusOK, "font"},
+		// not found
+		{"/_/missing.css", http.StatusNotFound, ""},
+		{"/app.abc123.css", http.StatusNotFound, ""},
+		{"/other/app.abc123.css", http.StatusNotFound, ""},
+		// directories
+		{"/_/", http.StatusNotFound, ""},
+		{"/_", http.StatusNotFound, ""},
+		{"/_/js/", http.StatusNotFound, ""},
+		{"/_/fonts/a", http.StatusNotFound, ""},
+		// traversal
+		{"/_/../_/app.abc123.css", http.StatusNotFound, ""},
+		{"/_/js/../app.abc123.css", http.StatusNotFound, ""},
+		{"/_/%2e%2e/app.abc123.css", http.StatusNotFound, ""},
+		{"/_/js/..", http.StatusNotFound, ""},
+		{"/_/js%5c..%5capp.abc123.css", http.StatusNotFound, ""},
+		{"/_/app.abc123.css%00", http.StatusNotFound, ""},
+		{"/_/js\\main.def456.js", http.StatusNotFound, ""},
+	}
+	h := New(assets, WithPrefix("/_/"))
+	for i, test := range tests {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest("GET", test.path, nil))
+		if res.Code != test.exp {
+			t.Errorf("test %d %q expected %d, got: %d", i, test.path, test.exp, res.Code)
+			continue
+		}
+		if test.exp == http.StatusOK && res.Body.String() != test.body {
+			t.Errorf("test %d %q expected body %q, got: %q", i, test.path, test.body, res.Body.String())
+		}
+	}
+}
+
+func TestHandlerNameFunc(t *testing.T) {
+	assets := map[string]*Asset{
+		"app.abc123.css": NewAsset("app.css", []byte("body{}"), time.Now()),
+	}
+	tests := []struct {
+		name string
+		exp  int
+	}{
+		{"app.abc123.css", http.StatusOK},
+		{"/app.abc123.css", http.StatusOK},
+		{"", http.StatusNotFound},
+		{"../app.abc123.css", http.StatusNotFound},
+		{"a/../app.abc123.css", http.StatusNotFound},
+		{"..\\app.abc123.css", http.StatusNotFound},
+		{"app.abc123.css\x00", http.StatusNotFound},
+	}
+	for i, test := range tests {
+		name := test.name
+		h := New(assets, WithNameFunc(func(*http.Request) string {
+			return name
+		}))
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+		if res.Code != test.exp {
+			t.Errorf("test %d %q expected %d, got: %d", i, test.name, test.exp, res.Code)
+		}
+	}
+}
+
+func TestHandlerMethods(t *testing.T) {
+	assets := map[string]*Asset{
+		"app.abc123.css": NewAsset("app.css", []byte("body{}"), time.Now()),
+	}
+	h := New(assets)
+	// head
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("HEAD", "/app.abc123.css", nil))
+	switch {
+	case res.Code != http.StatusOK:
+		t.Errorf("expected %d, got: %d", http.StatusOK, res.Code)
+	case res.Body.Len() != 0:
+		t.Errorf("expected no body, got: %q", res.Body.String())
+	case res.Header().Get("ETag") != assets["app.abc123.css"].Hash:
+		t.Errorf("expected etag %q, got: %q", assets["app.abc123.css"].Hash, res.Header().Get("ETag"))
+	case res.Header().Get("Content-Type") != "text/css; charset=utf-8":
+		t.Errorf("expected content type %q, got: %q", "text/css; charset=utf-8", res.Header().Get("Content-Type"))
+	}
+	// other methods
+	for _, method := range []string{"POST", "PUT", "DELETE", "OPTIONS"} {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(method, "/app.abc123.css", nil))
+		if res.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s expected %d, got: %d", method, http.StatusMethodNotAllowed, res.Code)
+		}
+		if allow := res.Header().Get("Allow"); allow != "GET, HEAD" {
+			t.Errorf("%s expected allow %q, got: %q", method, "GET, HEAD", allow)
+		}
+	}
+}
+
+func TestHandlerNotModified(t *testing.T) {
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	asset := NewAsset("app.css", []byte("body{}"), modTime)
+	h := New(map[string]*Asset{"app.abc123.css": asset})
+	tests := []struct {
+		header, value string
+		exp           int
+	}{
+		{"If-None-Match", asset.Hash, http.StatusNotModified},
+		{"If-None-Match", "other", http.StatusOK},
+		{"If-Modified-Since", modTime.Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", modTime.Add(time.Hour).Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+	}
+	for i, test := range tests {
+		req := httptest.NewRequest("GET", "/app.abc123.css", nil)
+		req.Header.Set(test.header, test.value)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != test.exp {
+			t.Errorf("test %d %s: %q expected %d, got: %d", i, test.header, test.value, test.exp, res.Code)
+		}
+		if test.exp == http.StatusNotModified && res.Body.Len() != 0 {
+			t.Errorf("test %d expected no body, got: %q", i, res.Body.String())
+		}
+	}
+	// etag from a previous response
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/app.abc123.css", nil))
+	req := httptest.NewRequest("GET", "/app.abc123.css", nil)
+	req.Header.Set("If-None-Match", res.Header().Get("ETag"))
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	if res.Code != http.StatusNotModified {
+		t.Errorf("expected %d, got: %d", http.StatusNotModified, res.Code)
+	}
+}
+
+func TestHandlerCacheControl(t *testing.T) {
+	assets := map[string]*Asset{
+		"css/app.abc123.css": NewAsset("app.css", []byte("body{}"), time.Now()),
+	}
+	manifest := map[string]string{
+		"css/app.abc123.css": "/css/app.css",
+	}
+	tests := []struct {
+		opts    []Option
+		path    string
+		exp     string
+		expires bool
+	}{
+		{nil, "/css/app.abc123.css", "public, no-transform, max-age=31536000", true},
+		{[]Option{WithImmutable(true)}, "/css/app.abc123.css", "public, no-transform, max-age=31536000, immutable", true},
+		{[]Option{WithDebug(true), WithImmutable(true)}, "/css/app.abc123.css", "no-cache", false},
+		{[]Option{WithUnhashed(manifest)}, "/css/app.abc123.css", "public, no-transform, max-age=31536000", true},
+		{[]Option{WithUnhashed(manifest), WithImmutable(true)}, "/css/app.css", "public, no-transform, no-cache", false},
+		{[]Option{WithUnhashed(manifest), WithDebug(true)}, "/css/app.css", "no-cache", false},
+	}
+	for i, test := range tests {
+		res := httptest.NewRecorder()
+		New(assets, test.opts...).ServeHTTP(res, httptest.NewRequest("GET", test.path, nil))
+		if res.Code != http.StatusOK {
+			t.Errorf("test %d %q expected %d, got: %d", i, test.path, http.StatusOK, res.Code)
+			continue
+		}
+		if s := res.Header().Get("Cache-Control"); s != test.exp {
+			t.Errorf("test %d %q expected cache control %q, got: %q", i, test.path, test.exp, s)
+		}
+		if s := res.Header().Get("Expires"); (s != "") != test.expires {
+			t.Errorf("test %d %q expected expires %t, got: %q", i, test.path, test.expires, s)
+		}
+	}
+	// unhashed names are not served without WithUnhashed
+	res := httptest.NewRecorder()
+	New(assets).ServeHTTP(res, httptest.NewRequest("GET", "/css/app.css", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected %d, got: %d", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+		ok  bool
+	}{
+		{"", "", true},
+		{"/", "/", true},
+		{"/a/b.css", "/a/b.css", true},
+		{"//a///b.css", "/a/b.css", true},
+		{"a..b.css", "a..b.css", true},
+		{"/..", "", false},
+		{"../a", "", false},
+		{"/a/../b", "", false},
+		{"/a/..", "", false},
+		{"/a\\b", "", false},
+		{"/a\x00b", "", false},
+	}
+	for i, test := range tests {
+		s, ok := CleanPath(test.s)
+		if s != test.exp || ok != test.ok {
+			t.Errorf("test %d %q expected %q, %t, got: %q, %t", i, test.s, test.exp, test.ok, s, ok)
+		}
+	}
+}